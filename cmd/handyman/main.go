@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/gammazero/workerpool"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
-	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	migrations "senjun.ru/handyman/etc/postgres_migrations"
 	"senjun.ru/handyman/internal"
 )
 
@@ -18,18 +24,19 @@ const version = "1.0"
 const addrHandyman = "0.0.0.0:8080"
 
 const timeoutReplyToUser = 50 * time.Second
+const timeoutShutdown = 60 * time.Second
+
+const defaultDeferredWritesPath = "deferred_writes.json"
+
+const defaultPlaygroundsGcInterval = time.Hour
 
 func main() {
-	internal.Logger = &log.Logger{
-		Out:   os.Stderr,
-		Level: log.DebugLevel,
-		Formatter: &prefixed.TextFormatter{
-			DisableColors:   true,
-			TimestampFormat: "2006-01-02 15:04:05.000",
-			FullTimestamp:   true,
-			ForceFormatting: true,
-		},
+	// Level can be changed without restart: /log_level
+	logLevel, err := log.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		logLevel = log.DebugLevel
 	}
+	internal.Logger = internal.NewLogger(os.Getenv("LOG_FORMAT"), logLevel)
 
 	internal.Logger.WithFields(log.Fields{
 		"version":    version,
@@ -37,6 +44,21 @@ func main() {
 		"GOMAXPROCS": runtime.GOMAXPROCS(-1),
 	}).Info("Started handyman")
 
+	if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "summary" || os.Args[1] == "playgrounds") {
+		connStr := os.Getenv("POSTGRES_CONN_STR")
+		if connStr == "" {
+			panic("set POSTGRES_CONN_STR plz")
+		}
+
+		if os.Args[1] == "summary" {
+			os.Exit(runSummary(os.Args[2:], connStr))
+		}
+		if os.Args[1] == "playgrounds" {
+			os.Exit(runPlaygrounds(os.Args[2:], connStr))
+		}
+		os.Exit(runMigrate(os.Args[2:], connStr))
+	}
+
 	if len(os.Args) > 1 {
 		internal.Logger.WithFields(log.Fields{
 			"path": os.Args[1],
@@ -48,6 +70,16 @@ func main() {
 		}).Info("Using default path to courses. You can redefine it by passing as 1st argument")
 	}
 
+	internal.RootTemplates = os.Getenv("TEMPLATES_PATH")
+	internal.Logger.WithFields(log.Fields{
+		"path": internal.GetTemplatesPath(),
+	}).Info("Using path to playground templates. You can redefine it by TEMPLATES_PATH")
+
+	internal.ContainersConfigPath = os.Getenv("CONTAINERS_CONFIG")
+	internal.Logger.WithFields(log.Fields{
+		"path": internal.GetContainersConfigPath(),
+	}).Info("Using config of container types. You can redefine it by CONTAINERS_CONFIG")
+
 	connStr := os.Getenv("POSTGRES_CONN_STR")
 	if connStr == "" {
 		panic("set POSTGRES_CONN_STR plz")
@@ -57,49 +89,144 @@ func main() {
 		panic("set WATCHMAN_ADDR plz")
 	}
 
-	internal.DB = internal.ConnectDb(connStr)
-	defer internal.DB.Close()
+	// Optional export of traces over OTLP/HTTP. Exporter reads the rest of OTEL_EXPORTER_OTLP_* variables
+	var tracing *sdktrace.TracerProvider
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Fatal("Couldn't create exporter of traces")
+		}
+
+		tracing = internal.SetupTracing(sdktrace.WithBatcher(exporter))
+		internal.Logger.Info("Enabled export of traces")
+	}
+
+	db := internal.ConnectDb(connStr)
+	defer db.Close()
 	internal.Logger.Info("DB is online, checked connection")
 
-	internal.WP = workerpool.New(12)
+	migrator, err := internal.NewMigrator(db, migrations.FS)
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Couldn't load migrations")
+	}
+	checkSchema(migrator)
+
+	// Optional read replica for heavy read-only queries of users progress
+	var replica *pgxpool.Pool
+	if replicaConnStr := os.Getenv("POSTGRES_REPLICA_CONN_STR"); replicaConnStr != "" {
+		replica = internal.ConnectDb(replicaConnStr)
+		defer replica.Close()
+		internal.Logger.Info("DB replica is online, checked connection")
+	}
+
+	store := internal.NewPostgresStore(db, replica)
+
+	_, err = internal.ReloadCatalog(context.Background(), store)
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Couldn't load catalog of courses")
+	}
+
+	wp := workerpool.New(12)
 	internal.Logger.Info("Created worker pool for DB deferred queries")
 
+	// Failed deferred writes are saved to file and retried after restart
+	deferredWritesPath := os.Getenv("DEFERRED_WRITES_PATH")
+	if deferredWritesPath == "" {
+		deferredWritesPath = defaultDeferredWritesPath
+	}
+	deferred := internal.NewWriteBehind(store, wp, deferredWritesPath)
+
+	// Optional DB for metrics of users activity on chapters
+	var activity *internal.ActivityRecorder
+	if metricsConnStr := os.Getenv("METRICS_CONN_STR"); metricsConnStr != "" {
+		metricsDb := internal.ConnectDb(metricsConnStr)
+		defer metricsDb.Close()
+		internal.Logger.Info("Metrics DB is online, checked connection")
+
+		activity = internal.NewActivityRecorder(internal.NewMetricsStore(metricsDb))
+	}
+
+	// Garbage collection of expired playgrounds
+	janitor, err := newPlaygroundJanitor(store)
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Couldn't start garbage collection of playgrounds")
+	}
+
+	server := internal.NewServer(store, deferred, activity)
+
 	internal.BindWatchman(watchmanConnStr)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/get_courses", internal.HandleGetCourses)
-
-	r.HandleFunc("/update_course_progress", internal.HandleUpdateCourseProgress)
-	r.HandleFunc("/update_chapter_progress", internal.HandleUpdateChapterProgress)
-	r.HandleFunc("/run_task", internal.HandleRunTask)
-	r.HandleFunc("/save_task", internal.HandleSaveTask)
-	r.HandleFunc("/get_progress", internal.HandleGetProgress)
-	r.HandleFunc("/get_chapter", internal.HandleGetChapter)
-	r.HandleFunc("/get_practice", internal.HandleGetPractice)
-	r.HandleFunc("/get_course_info", internal.HandleGetCourseInfo)
-	r.HandleFunc("/get_chapters", internal.HandleGetChapters)
-	r.HandleFunc("/get_course_description", internal.HandleGetCourseDescription)
-
-	r.HandleFunc("/get_active_chapter", internal.HandleGetActiveChapter)
-	r.HandleFunc("/courses_stats", internal.HandleCoursesStats)
-	r.HandleFunc("/course_stats", internal.HandleCourseStats)
-	r.HandleFunc("/get_task", internal.HandleGetTask)
+	r.HandleFunc("/get_courses", server.HandleGetCourses)
+
+	r.HandleFunc("/update_course_progress", server.HandleUpdateCourseProgress)
+	r.HandleFunc("/update_chapter_progress", server.HandleUpdateChapterProgress)
+	r.HandleFunc("/run_task", server.HandleRunTask)
+	r.HandleFunc("/save_task", server.HandleSaveTask)
+	r.HandleFunc("/get_progress", server.HandleGetProgress)
+	r.HandleFunc("/get_chapter", server.HandleGetChapter)
+	r.HandleFunc("/get_practice", server.HandleGetPractice)
+	r.HandleFunc("/get_course_info", server.HandleGetCourseInfo)
+	r.HandleFunc("/get_chapters", server.HandleGetChapters)
+	r.HandleFunc("/get_course_description", server.HandleGetCourseDescription)
+
+	r.HandleFunc("/get_active_chapter", server.HandleGetActiveChapter)
+	r.HandleFunc("/courses_stats", server.HandleCoursesStats)
+	r.HandleFunc("/course_stats", server.HandleCourseStats)
+	r.HandleFunc("/get_task", server.HandleGetTask)
+	r.HandleFunc("/search", server.HandleSearch)
+
+	// Images and diagrams of chapters and practice projects
+	r.HandleFunc("/assets/{course_id}/{item_id}/{path:.+}", server.HandleGetAsset).Methods("GET", "HEAD")
 
 	// APIs for syncing telegram bot account and site account:
-	r.HandleFunc("/merge_users", internal.HandleMergeUsers)
-	r.HandleFunc("/split_users", internal.HandleSplitUsers)
+	r.HandleFunc("/merge_users", server.HandleMergeUsers)
+	r.HandleFunc("/split_users", server.HandleSplitUsers)
+
+	// APIs for billing backend: access to paid courses
+	r.HandleFunc("/grant_access", server.HandleGrantAccess)
+	r.HandleFunc("/revoke_access", server.HandleRevokeAccess)
+
+	// Call after courses import to reload in-memory catalog of courses
+	r.HandleFunc("/reload_catalog", server.HandleReloadCatalog)
+
+	// Get or change level of logs: {"level": "info"}
+	r.HandleFunc("/log_level", server.HandleLogLevel)
+
+	// Progress on tasks changed by courses import
+	r.HandleFunc("/get_task_changes", server.HandleGetTaskChanges)
+	r.HandleFunc("/apply_task_changes", server.HandleApplyTaskChanges)
 
 	r.Handle("/metrics", promhttp.Handler())
 
-	r.HandleFunc("/run_code", internal.HandleRunCode)
-	r.HandleFunc("/get_playground_code", internal.HandleGetPlaygroundCode)
+	r.HandleFunc("/run_code", server.HandleRunCode)
+	r.HandleFunc("/get_playground_code", server.HandleGetPlaygroundCode)
+	r.HandleFunc("/save_playground", server.HandleSavePlayground)
+	r.HandleFunc("/fork_playground", server.HandleForkPlayground)
+	r.HandleFunc("/get_playgrounds", server.HandleGetPlaygrounds)
+	r.HandleFunc("/get_playground_snapshot", server.HandleGetPlaygroundSnapshot)
+	r.HandleFunc("/get_playground_templates", server.HandleGetPlaygroundTemplates)
+	r.HandleFunc("/get_languages", server.HandleGetLanguages)
 
-	r.HandleFunc("/inject_playground_code", internal.HandleInjectPlaygroundCode)
+	r.HandleFunc("/inject_playground_code", server.HandleInjectPlaygroundCode)
 
-	r.HandleFunc("/get_practice", internal.HandleGetPractice)
+	r.HandleFunc("/get_practice", server.HandleGetPractice)
 
 	// Run, test or save practice project
-	r.HandleFunc("/handle_practice_code", internal.HandlePracticeCode)
+	r.HandleFunc("/handle_practice_code", server.HandlePracticeCode)
+
+	// Id of request for logs and watchman, spans of requests, latency and outcome of every endpoint
+	r.Use(internal.RequestIdMiddleware)
+	r.Use(internal.TracingMiddleware)
+	r.Use(internal.MetricsMiddleware)
 
 	srv := &http.Server{
 		Handler:      r,
@@ -107,5 +234,83 @@ func main() {
 		WriteTimeout: timeoutReplyToUser,
 		ReadTimeout:  timeoutReplyToUser,
 	}
-	internal.Logger.Fatal(srv.ListenAndServe())
+
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			internal.Logger.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+
+	internal.Logger.WithFields(log.Fields{
+		"signal": sig.String(),
+	}).Info("Stopping handyman")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutShutdown)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Couldn't wait for requests in progress")
+	}
+
+	janitor.Close()
+
+	if err := deferred.Close(ctx); err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Couldn't flush deferred writes")
+	}
+
+	if err := activity.Close(ctx); err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Couldn't flush chapters activity to metrics DB")
+	}
+
+	if tracing != nil {
+		if err := tracing.Shutdown(ctx); err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Couldn't export traces")
+		}
+	}
+}
+
+// newPlaygroundJanitor starts garbage collection of playgrounds configured by PLAYGROUNDS_* variables.
+// Returns nil if it is disabled by PLAYGROUNDS_GC_INTERVAL=0
+func newPlaygroundJanitor(store *internal.PostgresStore) (*internal.PlaygroundJanitor, error) {
+	interval := defaultPlaygroundsGcInterval
+	if value := os.Getenv("PLAYGROUNDS_GC_INTERVAL"); value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if interval <= 0 {
+		internal.Logger.Info("Garbage collection of playgrounds is disabled")
+		return nil, nil
+	}
+
+	rules, err := internal.ParseRetentionRules(os.Getenv("PLAYGROUNDS_RETENTION"))
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := os.Getenv("PLAYGROUNDS_GC_DRY_RUN") == "1"
+
+	janitor := internal.NewPlaygroundJanitor(store, rules)
+	janitor.Start(interval, dryRun)
+
+	internal.Logger.WithFields(log.Fields{
+		"interval": interval.String(),
+		"dry_run":  dryRun,
+	}).Info("Started garbage collection of playgrounds")
+	return janitor, nil
 }
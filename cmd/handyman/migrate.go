@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	migrations "senjun.ru/handyman/etc/postgres_migrations"
+	"senjun.ru/handyman/internal"
+)
+
+const usageMigrate = `Usage: handyman migrate up|down [steps]|status|baseline <version>
+  up                  apply all not applied migrations
+  down [steps]        roll back the latest applied migrations (1 by default)
+  status              show applied and not applied migrations
+  baseline <version>  record migrations up to version as applied without running them:
+                      for DB to which migrations were applied by hand`
+
+// runMigrate handles "handyman migrate ..." command. Returns exit code.
+func runMigrate(args []string, connStr string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usageMigrate)
+		return 2
+	}
+
+	number := func(def int) (int, error) {
+		if len(args) < 2 {
+			return def, nil
+		}
+		return strconv.Atoi(args[1])
+	}
+
+	db := internal.ConnectDb(connStr)
+	defer db.Close()
+
+	migrator, err := internal.NewMigrator(db, migrations.FS)
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Couldn't load migrations")
+		return 1
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"applied": count,
+				"error":   err.Error(),
+			}).Error("migrate up: failed")
+			return 1
+		}
+
+		internal.Logger.WithFields(log.Fields{
+			"applied": count,
+			"version": migrator.LatestVersion(),
+		}).Info("migrate up: completed")
+
+	case "down":
+		steps, err := number(1)
+		if err != nil || steps < 1 {
+			fmt.Fprintln(os.Stderr, usageMigrate)
+			return 2
+		}
+
+		count, err := migrator.Down(ctx, steps)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"rolled_back": count,
+				"error":       err.Error(),
+			}).Error("migrate down: failed")
+			return 1
+		}
+
+		internal.Logger.WithFields(log.Fields{
+			"rolled_back": count,
+		}).Info("migrate down: completed")
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("migrate status: failed")
+			return 1
+		}
+
+		for _, s := range statuses {
+			applied := "not applied"
+			if s.IsApplied {
+				applied = "applied " + s.DtApply.Format("2006-01-02 15:04:05")
+			}
+
+			manual := ""
+			if s.IsManual() {
+				manual = " (manual)"
+			}
+
+			fmt.Printf("%04d_%s%s: %s\n", s.Version, s.Name, manual, applied)
+		}
+
+	case "baseline":
+		version, err := number(0)
+		if err != nil || version < 1 {
+			fmt.Fprintln(os.Stderr, usageMigrate)
+			return 2
+		}
+
+		err = migrator.Baseline(ctx, version)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"version": version,
+				"error":   err.Error(),
+			}).Error("migrate baseline: failed")
+			return 1
+		}
+
+		internal.Logger.WithFields(log.Fields{
+			"version": version,
+		}).Info("migrate baseline: completed")
+
+	default:
+		fmt.Fprintln(os.Stderr, usageMigrate)
+		return 2
+	}
+
+	return 0
+}
+
+// checkSchema refuses to serve against DB with not applied migrations.
+func checkSchema(migrator *internal.Migrator) {
+	err := migrator.CheckSchema(context.Background())
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"version": migrator.LatestVersion(),
+			"error":   err.Error(),
+		}).Fatal("DB schema is not up to date: run \"handyman migrate up\"")
+	}
+}
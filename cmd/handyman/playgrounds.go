@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"senjun.ru/handyman/internal"
+)
+
+const usagePlaygrounds = `Usage: handyman playgrounds gc [--dry-run]
+  gc            delete expired playgrounds by retention rules of PLAYGROUNDS_RETENTION
+  gc --dry-run  print count of expired playgrounds without deleting them`
+
+// runPlaygrounds handles "handyman playgrounds ..." command. Returns exit code.
+func runPlaygrounds(args []string, connStr string) int {
+	if len(args) == 0 || len(args) > 2 || args[0] != "gc" || (len(args) == 2 && args[1] != "--dry-run") {
+		fmt.Fprintln(os.Stderr, usagePlaygrounds)
+		return 2
+	}
+	dryRun := len(args) == 2
+
+	rules, err := internal.ParseRetentionRules(os.Getenv("PLAYGROUNDS_RETENTION"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	db := internal.ConnectDb(connStr)
+	defer db.Close()
+
+	store := internal.NewPostgresStore(db, nil)
+	reports, err := internal.NewPlaygroundJanitor(store, rules).Collect(context.Background(), dryRun)
+
+	for _, report := range reports {
+		fmt.Printf("%s (retention %s): expired %d, deleted %d\n", report.Rule, report.Retention, report.Expired, report.Deleted)
+	}
+
+	if err != nil {
+		internal.Logger.WithFields(log.Fields{
+			"dry_run": dryRun,
+			"error":   err.Error(),
+		}).Error("playgrounds gc: failed")
+		return 1
+	}
+
+	internal.Logger.WithFields(log.Fields{
+		"dry_run": dryRun,
+	}).Info("playgrounds gc: completed")
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"senjun.ru/handyman/internal"
+)
+
+const usageSummary = `Usage: handyman summary rebuild|check [user_id]
+  rebuild [user_id]  recount summary of progress on courses of user (all users by default)
+  check [user_id]    compare summary of progress with full recount, exit code 1 on mismatch`
+
+// Mismatches printed by "handyman summary check"
+const summaryCheckLimit = 100
+
+// runSummary handles "handyman summary ..." command. Returns exit code.
+func runSummary(args []string, connStr string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, usageSummary)
+		return 2
+	}
+
+	userId := 0
+	if len(args) == 2 {
+		var err error
+		userId, err = strconv.Atoi(args[1])
+		if err != nil || userId < 1 {
+			fmt.Fprintln(os.Stderr, usageSummary)
+			return 2
+		}
+	}
+
+	db := internal.ConnectDb(connStr)
+	defer db.Close()
+
+	store := internal.NewPostgresStore(db, nil)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "rebuild":
+		fixed, err := store.RebuildCourseSummaries(ctx, userId)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"user_id": userId,
+				"error":   err.Error(),
+			}).Error("summary rebuild: failed")
+			return 1
+		}
+
+		internal.Logger.WithFields(log.Fields{
+			"user_id": userId,
+			"fixed":   fixed,
+		}).Info("summary rebuild: completed")
+
+	case "check":
+		mismatches, err := store.CheckCourseSummaries(ctx, userId, summaryCheckLimit)
+		if err != nil {
+			internal.Logger.WithFields(log.Fields{
+				"user_id": userId,
+				"error":   err.Error(),
+			}).Error("summary check: failed")
+			return 1
+		}
+
+		for _, m := range mismatches {
+			fmt.Printf("user %d, course %s: summary %d/%d/%d, recount %d/%d/%d (chapters/tasks/projects)\n",
+				m.UserId, m.CourseId,
+				m.Summary.ChaptersCompleted, m.Summary.TasksCompleted, m.Summary.ProjectsCompleted,
+				m.Recount.ChaptersCompleted, m.Recount.TasksCompleted, m.Recount.ProjectsCompleted)
+		}
+
+		if len(mismatches) > 0 {
+			internal.Logger.WithFields(log.Fields{
+				"user_id":    userId,
+				"mismatches": len(mismatches),
+			}).Error("summary check: summary differs from progress, run \"handyman summary rebuild\"")
+			return 1
+		}
+
+		internal.Logger.WithFields(log.Fields{
+			"user_id": userId,
+		}).Info("summary check: summary matches progress")
+
+	default:
+		fmt.Fprintln(os.Stderr, usageSummary)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,18 @@
+-- Drops all tables of handyman. Progress of users is lost.
+
+DROP TABLE practice_progress;
+DROP TABLE practice;
+DROP TABLE playgrounds;
+DROP TABLE user_interactions;
+DROP TABLE task_progress;
+DROP TABLE chapter_progress;
+DROP TABLE course_progress;
+DROP TABLE tasks;
+DROP TABLE chapters;
+DROP TABLE courses;
+
+DROP FUNCTION best_solution(edu_material_status, text, edu_material_status, text);
+DROP FUNCTION max_edu_status(edu_material_status, edu_material_status);
+
+DROP TYPE course_type;
+DROP TYPE edu_material_status;
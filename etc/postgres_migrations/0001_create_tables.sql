@@ -84,7 +84,7 @@ CREATE TABLE course_progress (
     status edu_material_status NOT NULL,
     CONSTRAINT fk_course_id FOREIGN KEY(course_id) REFERENCES courses(course_id)
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_user_course_id ON course_progress(user_id, course_id);
+CREATE UNIQUE INDEX unique_user_course_id ON course_progress(user_id, course_id);
 ALTER TABLE course_progress ADD CONSTRAINT unique_user_course_id UNIQUE USING INDEX unique_user_course_id;
 ALTER TABLE course_progress OWNER TO senjun;
 
@@ -94,7 +94,7 @@ CREATE TABLE chapter_progress (
     status edu_material_status NOT NULL,
     CONSTRAINT fk_chapter_id FOREIGN KEY(chapter_id) REFERENCES chapters(chapter_id)
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_user_chapter_id ON chapter_progress(user_id, chapter_id);
+CREATE UNIQUE INDEX unique_user_chapter_id ON chapter_progress(user_id, chapter_id);
 ALTER TABLE chapter_progress ADD CONSTRAINT unique_user_chapter_id UNIQUE USING INDEX unique_user_chapter_id;
 ALTER TABLE chapter_progress OWNER TO senjun;
 
@@ -106,7 +106,7 @@ CREATE TABLE task_progress (
     attempts_count smallint DEFAULT 0 NOT NULL,
     CONSTRAINT fk_task_id FOREIGN KEY(task_id) REFERENCES tasks(task_id)
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_user_task_id ON task_progress(user_id, task_id);
+CREATE UNIQUE INDEX unique_user_task_id ON task_progress(user_id, task_id);
 ALTER TABLE task_progress ADD CONSTRAINT unique_user_task_id UNIQUE USING INDEX unique_user_task_id;
 ALTER TABLE task_progress OWNER TO senjun;
 
@@ -116,7 +116,7 @@ CREATE TABLE user_interactions (
     interaction_val varchar NOT NULL,
     day date NOT NULL DEFAULT CURRENT_DATE
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_user_key_id ON user_interactions(user_id, interaction_key);
+CREATE UNIQUE INDEX unique_user_key_id ON user_interactions(user_id, interaction_key);
 ALTER TABLE user_interactions ADD CONSTRAINT unique_user_key_id UNIQUE USING INDEX unique_user_key_id;
 ALTER TABLE user_interactions ADD UNIQUE (user_id, interaction_key);
 ALTER TABLE user_interactions OWNER TO senjun;
@@ -130,7 +130,7 @@ CREATE TABLE playgrounds (
     dt_last_request TIMESTAMPTZ NOT NULL DEFAULT Now(),
     user_code text
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_playground_id ON playgrounds(playground_id);
+CREATE UNIQUE INDEX unique_playground_id ON playgrounds(playground_id);
 ALTER TABLE playgrounds ADD CONSTRAINT unique_playground_id UNIQUE USING INDEX unique_playground_id;
 ALTER TABLE playgrounds OWNER TO senjun;
 
@@ -146,7 +146,7 @@ CREATE TABLE practice (
     CONSTRAINT fk_course_id FOREIGN KEY(course_id) REFERENCES courses(course_id)
 );
 
-CREATE UNIQUE INDEX CONCURRENTLY unique_project_id ON practice(project_id);
+CREATE UNIQUE INDEX unique_project_id ON practice(project_id);
 ALTER TABLE practice OWNER TO senjun;
 
 CREATE TABLE practice_progress (
@@ -157,6 +157,6 @@ CREATE TABLE practice_progress (
     attempts_count smallint DEFAULT 0 NOT NULL,
     CONSTRAINT fk_project_id FOREIGN KEY(project_id) REFERENCES practice(project_id)
 );
-CREATE UNIQUE INDEX CONCURRENTLY unique_user_practice_id ON practice_progress(user_id, project_id);
+CREATE UNIQUE INDEX unique_user_practice_id ON practice_progress(user_id, project_id);
 ALTER TABLE practice_progress ADD CONSTRAINT unique_user_practice_id UNIQUE USING INDEX unique_user_practice_id;
 ALTER TABLE practice_progress OWNER TO senjun;
\ No newline at end of file
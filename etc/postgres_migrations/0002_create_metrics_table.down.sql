@@ -0,0 +1,5 @@
+-- migrate: manual
+-- Metrics live in separate database. Drop it by hand:
+-- DROP DATABASE metrics;
+-- DROP USER metrics_reader;
+-- DROP USER metrics_writer;
@@ -1,3 +1,6 @@
+-- migrate: manual
+-- Metrics live in separate database and are created by hand: handyman migrate skips this migration.
+
 CREATE USER metrics_writer WITH PASSWORD 'metrics_writer';
 CREATE DATABASE metrics OWNER metrics_writer;
 
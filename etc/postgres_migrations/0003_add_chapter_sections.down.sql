@@ -0,0 +1,4 @@
+-- Chapters of sections become top-level chapters.
+
+ALTER TABLE chapters DROP CONSTRAINT fk_parent_chapter_id;
+ALTER TABLE chapters DROP COLUMN parent_chapter_id;
@@ -0,0 +1,6 @@
+-- Sections: chapters which group other chapters of the course (parts, modules).
+-- Section is a regular chapter with its own text.md. Its children reference it by parent_chapter_id.
+-- Top-level chapters have NULL parent_chapter_id.
+
+ALTER TABLE chapters ADD COLUMN parent_chapter_id varchar NULL;
+ALTER TABLE chapters ADD CONSTRAINT fk_parent_chapter_id FOREIGN KEY(parent_chapter_id) REFERENCES chapters(chapter_id);
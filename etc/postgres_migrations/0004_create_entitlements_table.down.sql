@@ -0,0 +1,3 @@
+-- Access to paid courses granted by billing backend is lost.
+
+DROP TABLE entitlements;
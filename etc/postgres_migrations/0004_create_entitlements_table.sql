@@ -0,0 +1,15 @@
+-- Access of users to paid courses. Granted and revoked by billing backend.
+-- Trial chapters of paid course are declared in tags.json and are available without entitlement.
+
+CREATE TABLE entitlements (
+    user_id BIGINT NOT NULL,
+    course_id varchar NOT NULL,
+    dt_grant TIMESTAMPTZ NOT NULL DEFAULT Now(),
+    dt_expire TIMESTAMPTZ, -- null for access without expiration
+    source varchar NOT NULL DEFAULT '', -- order id or any other reason of grant
+    CONSTRAINT fk_course_id FOREIGN KEY(course_id) REFERENCES courses(course_id)
+);
+-- Table is empty: index is built without CONCURRENTLY in transaction of migration
+CREATE UNIQUE INDEX unique_user_entitlement_id ON entitlements(user_id, course_id);
+ALTER TABLE entitlements ADD CONSTRAINT unique_user_entitlement_id UNIQUE USING INDEX unique_user_entitlement_id;
+ALTER TABLE entitlements OWNER TO senjun;
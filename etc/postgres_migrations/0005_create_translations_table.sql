@@ -0,0 +1,12 @@
+-- Translated titles and tags of courses, chapters and practice projects.
+-- Content of default locale (ru) lives in courses, chapters and practice tables.
+-- Translated texts are stored on disk next to default ones: text.en.md, hint.en.md, description.en.md, tags.en.json.
+
+CREATE TABLE translations (
+    item_id varchar NOT NULL, -- course_id, chapter_id or project_id
+    locale varchar NOT NULL,
+    title varchar NOT NULL,
+    tags jsonb, -- only for courses
+    PRIMARY KEY(item_id, locale)
+);
+ALTER TABLE translations OWNER TO senjun;
@@ -0,0 +1,10 @@
+-- Revisions of content and changes of tasks are lost.
+
+DROP TABLE task_changes;
+DROP TYPE revision_policy;
+DROP TABLE course_revisions;
+
+ALTER TABLE task_progress DROP COLUMN revision;
+ALTER TABLE tasks DROP COLUMN content_hash;
+ALTER TABLE tasks DROP COLUMN revision;
+ALTER TABLE courses DROP COLUMN revision;
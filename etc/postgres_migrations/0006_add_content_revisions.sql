@@ -0,0 +1,37 @@
+-- Content revisions. import_courses.py computes revision of each course (hash of its content)
+-- and hash of each task directory. If task content changed, task gets revision of the course
+-- and the change is recorded to task_changes. Progress on task references the revision of task
+-- it was earned against: if they differ, user sees that task changed since it was solved.
+-- Admin decides what to do with progress on changed task:
+-- keep - leave progress as is;
+-- invalidate - solved task becomes in_progress again;
+-- migrate - change is cosmetic, progress is moved to the new revision.
+
+ALTER TABLE courses ADD COLUMN revision varchar NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN revision varchar NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN content_hash varchar NOT NULL DEFAULT '';
+ALTER TABLE task_progress ADD COLUMN revision varchar NOT NULL DEFAULT '';
+
+CREATE TABLE course_revisions (
+    course_id varchar NOT NULL,
+    revision varchar NOT NULL,
+    git_commit varchar NOT NULL DEFAULT '', -- commit of courses repo if it is available on import
+    dt_import TIMESTAMPTZ NOT NULL DEFAULT Now(),
+    PRIMARY KEY(course_id, revision),
+    CONSTRAINT fk_course_id FOREIGN KEY(course_id) REFERENCES courses(course_id)
+);
+ALTER TABLE course_revisions OWNER TO senjun;
+
+CREATE TYPE revision_policy AS ENUM ('pending', 'keep', 'invalidate', 'migrate');
+
+CREATE TABLE task_changes (
+    task_id varchar NOT NULL,
+    old_revision varchar NOT NULL,
+    new_revision varchar NOT NULL,
+    policy revision_policy NOT NULL DEFAULT 'pending',
+    dt_change TIMESTAMPTZ NOT NULL DEFAULT Now(),
+    dt_apply TIMESTAMPTZ, -- null until policy is applied
+    PRIMARY KEY(task_id, new_revision),
+    CONSTRAINT fk_task_id FOREIGN KEY(task_id) REFERENCES tasks(task_id)
+);
+ALTER TABLE task_changes OWNER TO senjun;
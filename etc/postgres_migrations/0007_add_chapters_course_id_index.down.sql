@@ -0,0 +1,3 @@
+-- Index for aggregation of progress by courses.
+
+DROP INDEX chapters_course_id;
@@ -0,0 +1,6 @@
+-- migrate: no-transaction
+-- Progress of user is aggregated by courses with joins of task_progress, chapter_progress
+-- and practice_progress with catalog tables instead of matching ids with LIKE.
+-- Index for lookups of chapters of the course.
+
+CREATE INDEX CONCURRENTLY chapters_course_id ON chapters(course_id);
@@ -0,0 +1,3 @@
+-- Index for aggregation of progress by courses.
+
+DROP INDEX tasks_chapter_id;
@@ -0,0 +1,4 @@
+-- migrate: no-transaction
+-- Index for lookups of tasks of the chapter in aggregation of progress by courses.
+
+CREATE INDEX CONCURRENTLY tasks_chapter_id ON tasks(chapter_id);
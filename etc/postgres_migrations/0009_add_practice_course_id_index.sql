@@ -0,0 +1,4 @@
+-- migrate: no-transaction
+-- Index for lookups of practice projects of the course in aggregation of progress by courses.
+
+CREATE INDEX CONCURRENTLY practice_course_id ON practice(course_id);
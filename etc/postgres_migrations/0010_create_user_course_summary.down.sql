@@ -0,0 +1,3 @@
+-- Summary of progress is dropped: previous versions of handyman recount progress.
+
+DROP TABLE user_course_summary;
@@ -0,0 +1,34 @@
+-- Counters of completed chapters, tasks and practice projects of user on course.
+-- They are changed together with progress by handyman, so that progress page doesn't recount them.
+-- Missing row means zero counters. "handyman summary check" compares counters with full recount
+-- of progress, "handyman summary rebuild" recounts them.
+
+CREATE TABLE user_course_summary (
+    user_id BIGINT NOT NULL,
+    course_id varchar NOT NULL,
+    chapters_completed integer NOT NULL DEFAULT 0,
+    tasks_completed integer NOT NULL DEFAULT 0,
+    projects_completed integer NOT NULL DEFAULT 0,
+    dt_last_activity TIMESTAMPTZ, -- last change of progress on course, null if unknown
+    CONSTRAINT user_course_summary_pkey PRIMARY KEY(user_id, course_id),
+    CONSTRAINT fk_course_id FOREIGN KEY(course_id) REFERENCES courses(course_id)
+);
+ALTER TABLE user_course_summary OWNER TO senjun;
+
+INSERT INTO user_course_summary(user_id, course_id, chapters_completed, tasks_completed, projects_completed)
+SELECT user_id, course_id, sum(chapters), sum(tasks), sum(projects) FROM (
+    SELECT chapter_progress.user_id, chapters.course_id, 1 AS chapters, 0 AS tasks, 0 AS projects
+    FROM chapter_progress INNER JOIN chapters ON chapters.chapter_id = chapter_progress.chapter_id
+    WHERE chapter_progress.status = 'completed'
+    UNION ALL
+    SELECT task_progress.user_id, chapters.course_id, 0, 1, 0
+    FROM task_progress
+    INNER JOIN tasks ON tasks.task_id = task_progress.task_id
+    INNER JOIN chapters ON chapters.chapter_id = tasks.chapter_id
+    WHERE task_progress.status = 'completed'
+    UNION ALL
+    SELECT practice_progress.user_id, practice.course_id, 0, 0, 1
+    FROM practice_progress INNER JOIN practice ON practice.project_id = practice_progress.project_id
+    WHERE practice_progress.status = 'completed'
+) AS completed
+GROUP BY user_id, course_id;
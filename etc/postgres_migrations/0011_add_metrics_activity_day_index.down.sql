@@ -0,0 +1,6 @@
+-- migrate: manual
+-- Metrics live in separate database: roll back by hand in metrics DB.
+
+-- \c metrics
+
+DROP INDEX CONCURRENTLY chapters_activity_day;
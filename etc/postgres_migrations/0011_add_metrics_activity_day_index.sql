@@ -0,0 +1,7 @@
+-- migrate: manual
+-- Metrics live in separate database: apply by hand in metrics DB.
+-- handyman recounts weekly and monthly active users from activity of the latest days.
+
+-- \c metrics
+
+CREATE INDEX CONCURRENTLY chapters_activity_day ON chapters_activity(day);
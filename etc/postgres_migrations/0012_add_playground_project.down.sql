@@ -0,0 +1,3 @@
+-- Multi-file projects of playgrounds.
+
+ALTER TABLE playgrounds DROP COLUMN project;
@@ -0,0 +1,5 @@
+-- Playgrounds are saved by /run_code and /save_playground: single-file code in user_code
+-- and multi-file project in project. Ids of new playgrounds are generated by handyman.
+-- Playground of signed in user is updated only by this user.
+
+ALTER TABLE playgrounds ADD COLUMN project text;
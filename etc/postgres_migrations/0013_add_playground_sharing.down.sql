@@ -0,0 +1,6 @@
+-- Sharing of playgrounds.
+
+ALTER TABLE playgrounds DROP COLUMN parent_id;
+ALTER TABLE playgrounds DROP COLUMN visibility;
+
+DROP TYPE playground_visibility;
@@ -0,0 +1,10 @@
+-- Sharing of playgrounds. Owner of playground is user_id: only owner updates it.
+-- Visibility: private - only owner opens it, unlisted - anyone who has the link,
+-- public - anyone, it's also listed in playgrounds of the owner for other users.
+-- Fork is a copy of playground in account of another user, parent_id references the original.
+
+CREATE TYPE playground_visibility AS ENUM ('private', 'unlisted', 'public');
+ALTER TYPE playground_visibility OWNER TO senjun;
+
+ALTER TABLE playgrounds ADD COLUMN visibility playground_visibility NOT NULL DEFAULT 'unlisted';
+ALTER TABLE playgrounds ADD COLUMN parent_id varchar NULL;
@@ -0,0 +1,3 @@
+-- Listing of playgrounds of user.
+
+DROP INDEX playgrounds_user_id;
@@ -0,0 +1,4 @@
+-- migrate: no-transaction
+-- Playgrounds of user are listed by owner, recently used first.
+
+CREATE INDEX CONCURRENTLY playgrounds_user_id ON playgrounds(user_id, dt_last_request DESC);
@@ -0,0 +1,3 @@
+-- Garbage collection of playgrounds.
+
+DROP INDEX playgrounds_dt_last_request;
@@ -0,0 +1,4 @@
+-- migrate: no-transaction
+-- Garbage collection of playgrounds: expired playgrounds are found by time of the last use.
+
+CREATE INDEX CONCURRENTLY playgrounds_dt_last_request ON playgrounds(dt_last_request);
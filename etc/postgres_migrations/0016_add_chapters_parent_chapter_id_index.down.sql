@@ -0,0 +1,3 @@
+-- Lookups of chapters of section.
+
+DROP INDEX chapters_parent_chapter_id;
@@ -0,0 +1,5 @@
+-- migrate: no-transaction
+-- Chapters of section are selected by parent_chapter_id.
+-- Index exists in DBs to which 0003_add_chapter_sections was applied by hand.
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS chapters_parent_chapter_id ON chapters(parent_chapter_id);
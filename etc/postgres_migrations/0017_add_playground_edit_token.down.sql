@@ -0,0 +1,3 @@
+-- Anonymous playgrounds become read-only.
+
+ALTER TABLE playgrounds DROP COLUMN edit_token;
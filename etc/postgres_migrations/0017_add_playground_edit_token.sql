@@ -0,0 +1,4 @@
+-- Anonymous playground is updated by those who have its edit token returned on creation.
+-- sha256 of token is stored. Playgrounds of users have no token.
+
+ALTER TABLE playgrounds ADD COLUMN edit_token varchar NULL;
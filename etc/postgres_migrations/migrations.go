@@ -0,0 +1,8 @@
+// Package postgres_migrations embeds sql migrations into handyman binary.
+// Migration NNNN_name.sql is rolled back by NNNN_name.down.sql.
+package postgres_migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,200 @@
+"""
+Loading of course content shared by init_db.py and import_courses.py:
+revisions of courses and tasks, sections from tags.json and translations
+"""
+import hashlib
+import json
+import logging
+import os
+import re
+import subprocess
+from typing import Dict, List, Optional
+
+from psycopg2 import sql
+
+
+def run_cmd(conn, cmd) -> None:
+    with conn.cursor() as cursor:
+        cursor.execute(cmd)
+
+
+def get_dir_hash(path: str) -> str:
+    """
+    Returns hash of all files in directory. It is used as content revision of course or task
+    """
+    h = hashlib.sha256()
+
+    for root, dirs, files in os.walk(path):
+        # Skip .git and other hidden directories
+        dirs[:] = sorted(d for d in dirs if not d.startswith("."))
+
+        for file in sorted(files):
+            file_path = os.path.join(root, file)
+            h.update(os.path.relpath(file_path, path).encode())
+            with open(file_path, "rb") as f:
+                h.update(f.read())
+
+    return h.hexdigest()[:16]
+
+
+def get_git_commit(path: str) -> str:
+    try:
+        res = subprocess.run(["git", "-C", path, "rev-parse", "HEAD"], capture_output=True, text=True, check=True)
+        return res.stdout.strip()
+    except Exception:
+        return ""
+
+
+def import_course_revisions(courses: List, conn) -> None:
+    """
+    Records revisions of courses. Course is tuple (course_id, path, ..., revision)
+    """
+    revisions = [(c[0], c[-1], get_git_commit(c[1])) for c in courses]
+    if len(revisions) == 0:
+        return
+
+    insert = sql.SQL(
+        """INSERT INTO course_revisions(course_id, revision, git_commit) VALUES {}
+        ON CONFLICT (course_id, revision) DO NOTHING"""
+    ).format(sql.SQL(",").join(map(sql.Literal, revisions)))
+
+    run_cmd(conn, insert)
+    logging.info(f"Imported course revisions: {[r[:2] for r in revisions]}")
+
+
+def import_task_changes(chapter_id: str, tasks: List, revision: str, conn) -> None:
+    """
+    Records changes of already imported tasks. Task is tuple (task_id, chapter_id, revision, content_hash)
+    """
+    with conn.cursor() as cursor:
+        cursor.execute("SELECT task_id, revision, content_hash FROM tasks WHERE chapter_id = %s", (chapter_id,))
+        imported = {row[0]: (row[1], row[2]) for row in cursor.fetchall()}
+
+    for task_id, _, _, content_hash in tasks:
+        if task_id not in imported:
+            continue
+
+        old_revision, old_hash = imported[task_id]
+        if old_hash == content_hash:
+            continue
+
+        if not old_hash:
+            # Task was imported before revisions: progress on it was earned against current content
+            run_cmd(conn, sql.SQL("UPDATE task_progress SET revision = {} WHERE task_id = {} AND revision = ''").format(
+                sql.Literal(revision), sql.Literal(task_id)))
+        else:
+            logging.info(f"Task {task_id} changed in revision {revision}")
+            run_cmd(conn, sql.SQL(
+                """INSERT INTO task_changes(task_id, old_revision, new_revision) VALUES ({}, {}, {})
+                ON CONFLICT (task_id, new_revision) DO NOTHING"""
+            ).format(sql.Literal(task_id), sql.Literal(old_revision), sql.Literal(revision)))
+
+
+def get_sections(course_dir: str) -> Dict[str, str]:
+    """
+    Returns mapping chapter_id -> parent_chapter_id for sections declared in tags.json:
+    "sections": {"cpp_chapter_0010": ["cpp_chapter_0020", "cpp_chapter_0030"]}
+    """
+    try:
+        with open(os.path.join(course_dir, "tags.json")) as file_tags:
+            sections = json.load(file_tags).get("sections", {})
+    except Exception:
+        return {}
+
+    parents = {}
+    for section_id, chapter_ids in sections.items():
+        for chapter_id in chapter_ids:
+            parents[chapter_id] = section_id
+
+    return parents
+
+
+def get_parent_chapter_id(chapter_id: str, chapter_ids: List, sections: Dict[str, str]) -> Optional[str]:
+    if sections:
+        return sections.get(chapter_id)
+
+    # No sections in tags.json: python_chapter_0021 is a subchapter of python_chapter_0020
+    if chapter_id.endswith("0"):
+        return None
+
+    parent_id = chapter_id[:-1] + "0"
+    if parent_id in chapter_ids:
+        return parent_id
+
+    return None
+
+
+def get_text_locales(item_dir: str) -> List[str]:
+    """
+    Returns locales of translated texts of chapter or practice project: text.en.md -> en
+    """
+    locales = []
+    for file in os.listdir(item_dir):
+        m = re.fullmatch(r"text\.([a-z]{2})\.md", file)
+        if m:
+            locales.append(m.group(1))
+
+    return locales
+
+
+def get_translated_title(item_dir: str, locale: str) -> str:
+    # First line of file is title
+    with open(os.path.join(item_dir, f"text.{locale}.md")) as f:
+        for line in f:
+            return line.strip("#").strip()
+
+    return ""
+
+
+def get_translations_for_course(course_dir: str, course_id: str) -> List:
+    translations = []
+
+    for file in os.listdir(course_dir):
+        m = re.fullmatch(r"tags\.([a-z]{2})\.json", file)
+        if not m:
+            continue
+
+        with open(os.path.join(course_dir, file)) as file_tags:
+            tags = file_tags.read()
+
+        title = json.loads(tags).get("title", course_id.capitalize())
+        translations.append((course_id, m.group(1), title, tags))
+
+    items_dirs = [course_dir]
+    practice_dir = os.path.join(course_dir, "practice")
+    if os.path.exists(practice_dir):
+        items_dirs.append(practice_dir)
+
+    for items_dir in items_dirs:
+        for item_id in os.listdir(items_dir):
+            item_dir = os.path.join(items_dir, item_id)
+            if not item_id.startswith(course_id) or not os.path.isdir(item_dir):
+                # Skip additional files and directories
+                continue
+
+            for locale in get_text_locales(item_dir):
+                translations.append((item_id, locale, get_translated_title(item_dir, locale), None))
+
+    return translations
+
+
+def import_translations(courses_dir: str, course_ids: List, conn) -> None:
+    translations = []
+
+    for course_id in course_ids:
+        course_dir = os.path.join(courses_dir, course_id)
+        translations.extend(get_translations_for_course(course_dir, course_id))
+
+    logging.info(f"Found {len(translations)} translations")
+
+    if len(translations) == 0:
+        return
+
+    insert = sql.SQL(
+        """INSERT INTO translations(item_id, locale, title, tags) VALUES {}
+        ON CONFLICT (item_id, locale) DO UPDATE
+        SET title=EXCLUDED.title, tags=EXCLUDED.tags"""
+    ).format(sql.SQL(",").join(map(sql.Literal, translations)))
+
+    run_cmd(conn, insert)
+    logging.info("Imported translations")
@@ -10,6 +10,9 @@ import psycopg2
 from click_extra import extra_command, option
 from psycopg2 import sql
 
+from course_loader import (get_dir_hash, get_parent_chapter_id, get_sections, import_course_revisions,
+                           import_task_changes, import_translations, run_cmd)
+
 
 logging.basicConfig(
     level=logging.INFO, format="[%(asctime)s] %(levelname)-8s %(message)s"
@@ -17,11 +20,6 @@ logging.basicConfig(
 #click_extra.logging.logger.set_logger(logging.getLogger())
 
 
-def run_cmd(conn, cmd) -> None:
-    with conn.cursor() as cursor:
-        cursor.execute(cmd)
-
-
 def import_courses(courses_dir: str, conn) -> List:
     courses = []
 
@@ -38,20 +36,24 @@ def import_courses(courses_dir: str, conn) -> List:
             continue
 
         title = json.loads(tags).get("title", course_id.capitalize())
-        course_data = (course_id, path, title, course_type, tags)
+        course_type = json.loads(tags).get("type", course_type)
+        course_data = (course_id, path, title, course_type, tags, get_dir_hash(path))
         courses.append(course_data)
 
     course_ids = [c[0] for c in courses]
     logging.info(f"Found {len(courses)} courses: {course_ids}")
 
     insert = sql.SQL(
-        """INSERT INTO courses VALUES {}
+        """INSERT INTO courses(course_id, path_on_disk, title, type, tags, revision) VALUES {}
         ON CONFLICT (course_id) DO UPDATE
-        SET path_on_disk=EXCLUDED.path_on_disk, title=EXCLUDED.title, type=EXCLUDED.type, tags=EXCLUDED.tags"""
+        SET path_on_disk=EXCLUDED.path_on_disk, title=EXCLUDED.title, type=EXCLUDED.type, tags=EXCLUDED.tags,
+        revision=EXCLUDED.revision"""
     ).format(sql.SQL(",").join(map(sql.Literal, courses)))
 
     run_cmd(conn, insert)
     logging.info("Imported courses")
+
+    import_course_revisions(courses, conn)
     return course_ids
 
 
@@ -74,15 +76,18 @@ def import_chapters_for_course(course_dir: str, course_id: str, conn) -> None:
 
         title = get_chapter_title(os.path.join(course_dir, chapter_id))
 
-        chapter_data = (chapter_id, course_id, title)
-        chapters.append(chapter_data)
+        chapters.append((chapter_id, course_id, title))
 
     logging.info(f"Course {course_id}. Found {len(chapters)} chapters")
 
+    sections = get_sections(course_dir)
+    chapter_ids = [c[0] for c in chapters]
+    chapters = [(*c, get_parent_chapter_id(c[0], chapter_ids, sections)) for c in chapters]
+
     insert = sql.SQL(
-        """INSERT INTO chapters VALUES {}
+        """INSERT INTO chapters(chapter_id, course_id, title, parent_chapter_id) VALUES {}
         ON CONFLICT (chapter_id) DO UPDATE
-        SET title=EXCLUDED.title"""
+        SET title=EXCLUDED.title, parent_chapter_id=EXCLUDED.parent_chapter_id"""
     ).format(sql.SQL(",").join(map(sql.Literal, chapters)))
 
     run_cmd(conn, insert)
@@ -95,7 +100,7 @@ def import_chapters(courses_dir: str, course_ids: List, conn) -> None:
         import_chapters_for_course(course_dir, course_id, conn)
 
 
-def import_tasks_for_chapter(chapter_id: str, tasks_dir: str, conn) -> None:
+def import_tasks_for_chapter(chapter_id: str, tasks_dir: str, revision: str, conn) -> None:
     if not os.path.exists(tasks_dir):
         logging.warning(
             f"Tasks directory for {chapter_id} doesn't exist. Skipping"
@@ -109,7 +114,7 @@ def import_tasks_for_chapter(chapter_id: str, tasks_dir: str, conn) -> None:
             # Skip additional files and directories
             continue
 
-        task_data = (task_id, chapter_id)
+        task_data = (task_id, chapter_id, revision, get_dir_hash(os.path.join(tasks_dir, task_id)))
         tasks.append(task_data)
 
     logging.info(f"Chapter {chapter_id}. Found {len(tasks)} tasks")
@@ -117,9 +122,14 @@ def import_tasks_for_chapter(chapter_id: str, tasks_dir: str, conn) -> None:
     if len(tasks) == 0:
         return
 
+    import_task_changes(chapter_id, tasks, revision, conn)
+
+    # Revision of unchanged task stays the same
     insert = sql.SQL(
-        """INSERT INTO tasks VALUES {}
-        ON CONFLICT (task_id) DO NOTHING"""
+        """INSERT INTO tasks(task_id, chapter_id, revision, content_hash) VALUES {}
+        ON CONFLICT (task_id) DO UPDATE
+        SET revision=EXCLUDED.revision, content_hash=EXCLUDED.content_hash
+        WHERE tasks.content_hash <> EXCLUDED.content_hash"""
     ).format(sql.SQL(",").join(map(sql.Literal, tasks)))
 
     run_cmd(conn, insert)
@@ -127,13 +137,15 @@ def import_tasks_for_chapter(chapter_id: str, tasks_dir: str, conn) -> None:
 
 
 def import_tasks_for_course(course_dir, course_id, conn) -> None:
+    revision = get_dir_hash(course_dir)
+
     for chapter_id in os.listdir(course_dir):
         if not chapter_id.startswith(course_id):
             # Skip additional files and directories
             continue
 
         tasks_dir = os.path.join(course_dir, chapter_id, "tasks")
-        import_tasks_for_chapter(chapter_id, tasks_dir, conn)
+        import_tasks_for_chapter(chapter_id, tasks_dir, revision, conn)
 
     logging.info(f"Imported all tasks for course {course_id}")
 
@@ -202,6 +214,7 @@ def main(courses_dir: str, postgres_conn: str) -> None:
         import_chapters(courses_dir, course_ids, conn)
         import_tasks(courses_dir, course_ids, conn)
         import_practice(courses_dir, course_ids,conn)
+        import_translations(courses_dir, course_ids, conn)
 
         logging.info(f"Completed courses import from {courses_dir} to db")
     except Exception:
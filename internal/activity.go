@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Activity of users on chapters for metrics DB (0002_create_metrics_table.sql).
+// Actions are counted in memory per user, chapter and day and flushed to metrics DB periodically.
+// Weekly and monthly active users are recounted from activity by scheduled job.
+
+const (
+	ActionView         = "view"
+	ActionRunTask      = "run_task"
+	ActionChangeStatus = "change_status"
+)
+
+type ChapterActivity struct {
+	ChapterId string
+	UserId    int64
+	// Day in format 2006-01-02
+	Day    string
+	Action string
+	Count  int
+}
+
+type MetricsRepo interface {
+	// AddChapterActivity adds counts of actions to already saved ones
+	AddChapterActivity(ctx context.Context, activity []ChapterActivity) error
+	// UpdateActiveUsers recounts weekly and monthly active users on day (2006-01-02)
+	UpdateActiveUsers(ctx context.Context, day string) error
+}
+
+const activityFlushInterval = time.Minute
+const activeUsersInterval = time.Hour
+const activityWriteTimeout = 30 * time.Second
+
+// Activity is not collected over this count of keys while metrics DB is down
+const activityMaxKeys = 1000000
+
+type activityKey struct {
+	chapterId string
+	userId    int64
+	day       string
+	action    string
+}
+
+type ActivityRecorder struct {
+	repo MetricsRepo
+
+	mutex  sync.Mutex
+	counts map[activityKey]int
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewActivityRecorder starts periodic flush of activity and recount of active users.
+func NewActivityRecorder(repo MetricsRepo) *ActivityRecorder {
+	r := newActivityRecorder(repo)
+	go r.run()
+	return r
+}
+
+func newActivityRecorder(repo MetricsRepo) *ActivityRecorder {
+	return &ActivityRecorder{
+		repo:    repo,
+		counts:  make(map[activityKey]int),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Record counts action of user on chapter. Anonymous users are not counted.
+// Nil recorder records nothing: metrics DB is not configured.
+func (r *ActivityRecorder) Record(userId string, chapterId string, action string) {
+	if r == nil || len(chapterId) == 0 {
+		return
+	}
+
+	id, err := strconv.ParseInt(userId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	key := activityKey{chapterId: chapterId, userId: id, day: time.Now().Format("2006-01-02"), action: action}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.counts[key]; !ok && len(r.counts) >= activityMaxKeys {
+		return
+	}
+
+	r.counts[key]++
+}
+
+// Flush writes collected activity to metrics DB. On error activity is kept until the next flush.
+func (r *ActivityRecorder) Flush(ctx context.Context) error {
+	r.mutex.Lock()
+	counts := r.counts
+	r.counts = make(map[activityKey]int)
+	r.mutex.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	activity := make([]ChapterActivity, 0, len(counts))
+	for key, count := range counts {
+		activity = append(activity, ChapterActivity{
+			ChapterId: key.chapterId,
+			UserId:    key.userId,
+			Day:       key.day,
+			Action:    key.action,
+			Count:     count,
+		})
+	}
+
+	// Stable order of rows: concurrent flushes of instances don't deadlock
+	sort.Slice(activity, func(i, j int) bool {
+		a, b := activity[i], activity[j]
+		if a.ChapterId != b.ChapterId {
+			return a.ChapterId < b.ChapterId
+		}
+		if a.UserId != b.UserId {
+			return a.UserId < b.UserId
+		}
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		return a.Action < b.Action
+	})
+
+	err := r.repo.AddChapterActivity(ctx, activity)
+	if err == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, count := range counts {
+		r.counts[key] += count
+	}
+
+	return err
+}
+
+// UpdateActiveUsers recounts active users for today and yesterday: yesterday gets its final value
+// after the last flush of the day.
+func (r *ActivityRecorder) UpdateActiveUsers(ctx context.Context) error {
+	now := time.Now()
+
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+		if err := r.repo.UpdateActiveUsers(ctx, day.Format("2006-01-02")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *ActivityRecorder) run() {
+	defer close(r.stopped)
+
+	flushTicker := time.NewTicker(activityFlushInterval)
+	defer flushTicker.Stop()
+
+	activeUsersTicker := time.NewTicker(activeUsersInterval)
+	defer activeUsersTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), activityWriteTimeout)
+			err := r.Flush(ctx)
+			cancel()
+
+			if err != nil {
+				Logger.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Warning("[metrics] couldn't flush chapters activity")
+			}
+
+		case <-activeUsersTicker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), activityWriteTimeout)
+			err := r.UpdateActiveUsers(ctx)
+			cancel()
+
+			if err != nil {
+				Logger.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Warning("[metrics] couldn't update weekly and monthly active users")
+			}
+
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Close stops periodic jobs and flushes collected activity.
+func (r *ActivityRecorder) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	close(r.stop)
+	<-r.stopped
+
+	return r.Flush(ctx)
+}
+
+// MetricsStore implements MetricsRepo over connection pool to metrics DB.
+type MetricsStore struct {
+	db *pgxpool.Pool
+}
+
+func NewMetricsStore(db *pgxpool.Pool) *MetricsStore {
+	return &MetricsStore{db: db}
+}
+
+func (s *MetricsStore) AddChapterActivity(ctx context.Context, activity []ChapterActivity) error {
+	defer observeQuery("add_chapter_activity")()
+	query := `
+		INSERT INTO chapters_activity(chapter_id, user_id, day, action, count)
+		SELECT chapter_id, user_id, day::date, action::chapter_action, count
+		FROM unnest($1::varchar[], $2::bigint[], $3::text[], $4::text[], $5::integer[])
+		AS activity(chapter_id, user_id, day, action, count)
+		ON CONFLICT (chapter_id, user_id, day, action)
+		DO UPDATE SET
+		count = chapters_activity.count + EXCLUDED.count
+	`
+	chapterIds := make([]string, len(activity))
+	userIds := make([]int64, len(activity))
+	days := make([]string, len(activity))
+	actions := make([]string, len(activity))
+	counts := make([]int32, len(activity))
+
+	for i, a := range activity {
+		chapterIds[i], userIds[i], days[i], actions[i], counts[i] = a.ChapterId, a.UserId, a.Day, a.Action, int32(a.Count)
+	}
+
+	_, err := s.db.Exec(ctx, query, chapterIds, userIds, days, actions, counts)
+	return err
+}
+
+func (s *MetricsStore) UpdateActiveUsers(ctx context.Context, day string) error {
+	defer observeQuery("update_active_users")()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// Tables have no unique day: rows of day are replaced by one instance of handyman at a time
+	var locked bool
+	err = tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock(hashtext('handyman_active_users'))`).Scan(&locked)
+	if err != nil || !locked {
+		return err
+	}
+
+	for table, days := range map[string]int{"weekly_active_users": 7, "monthly_active_users": 30} {
+		if err = replaceActiveUsers(tx, ctx, table, days, day); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// replaceActiveUsers counts users active during period of days which ends on day
+func replaceActiveUsers(tx pgx.Tx, ctx context.Context, table string, days int, day string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE day = $1::date`, day); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ` + table + `(day, count)
+		SELECT $1::date, count(DISTINCT user_id) FROM chapters_activity
+		WHERE day > $1::date - $2::integer AND day <= $1::date
+	`
+	_, err := tx.Exec(ctx, query, day, days)
+	return err
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testMetricsRepo struct {
+	down     bool
+	activity []ChapterActivity
+	days     []string
+}
+
+func (r *testMetricsRepo) AddChapterActivity(ctx context.Context, activity []ChapterActivity) error {
+	if r.down {
+		return errors.New("connection refused")
+	}
+
+	r.activity = append(r.activity, activity...)
+	return nil
+}
+
+func (r *testMetricsRepo) UpdateActiveUsers(ctx context.Context, day string) error {
+	r.days = append(r.days, day)
+	return nil
+}
+
+func TestActivityRecorder(t *testing.T) {
+	ctx := context.Background()
+	repo := &testMetricsRepo{down: true}
+	r := newActivityRecorder(repo)
+
+	r.Record("42", "rust_chapter_0010", ActionView)
+	r.Record("42", "rust_chapter_0010", ActionView)
+	r.Record("42", "rust_chapter_0010", ActionRunTask)
+	r.Record("", "rust_chapter_0010", ActionView)
+	r.Record("42", "", ActionView)
+
+	if err := r.Flush(ctx); err == nil {
+		t.Fatalf(`Activity is flushed while metrics DB is down`)
+	}
+
+	// Activity is kept until metrics DB is up
+	repo.down = false
+	r.Record("42", "rust_chapter_0010", ActionView)
+
+	if err := r.Flush(ctx); err != nil {
+		t.Fatalf(`Couldn't flush activity: %v`, err)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	plan := []ChapterActivity{
+		{ChapterId: "rust_chapter_0010", UserId: 42, Day: today, Action: ActionRunTask, Count: 1},
+		{ChapterId: "rust_chapter_0010", UserId: 42, Day: today, Action: ActionView, Count: 3},
+	}
+
+	if len(repo.activity) != len(plan) {
+		t.Fatalf(`Wrong activity. Plan: %v Fact: %v`, plan, repo.activity)
+	}
+
+	for i := range plan {
+		if repo.activity[i] != plan[i] {
+			t.Fatalf(`Wrong activity. Plan: %v Fact: %v`, plan[i], repo.activity[i])
+		}
+	}
+
+	if err := r.UpdateActiveUsers(ctx); err != nil || len(repo.days) != 2 || repo.days[1] != today {
+		t.Fatalf(`Wrong days of active users: %v %v`, repo.days, err)
+	}
+
+	// Metrics DB is not configured
+	var disabled *ActivityRecorder
+	disabled.Record("42", "rust_chapter_0010", ActionView)
+	if err := disabled.Close(ctx); err != nil {
+		t.Fatalf(`Couldn't close disabled recorder: %v`, err)
+	}
+}
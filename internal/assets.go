@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Static assets (images, diagrams) of chapters and practice projects are stored next to text.md.
+// Only files with known extensions are served: chapter directories also contain tasks with solutions.
+
+const assetsMaxAge = 24 * 60 * 60
+
+var assetContentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".svg":  "image/svg+xml",
+	".mp4":  "video/mp4",
+}
+
+// GetAssetPath returns path on disk to asset of chapter or practice project (itemId).
+func GetAssetPath(c *Catalog, courseId string, itemId string, assetPath string) (string, error) {
+	var dir string
+
+	if p, ok := c.Practice[itemId]; ok && p.CourseId == courseId {
+		dir = filepath.Join(RootCourses, courseId, "practice", itemId)
+	} else if chapter, ok := c.Chapters[itemId]; ok && chapter.CourseId == courseId {
+		dir = filepath.Join(RootCourses, courseId, itemId)
+	} else {
+		return "", fmt.Errorf("unknown item %s of course %s", itemId, courseId)
+	}
+
+	if _, ok := assetContentTypes[strings.ToLower(filepath.Ext(assetPath))]; !ok {
+		return "", fmt.Errorf("unsupported asset type: %s", assetPath)
+	}
+
+	// Clean path from root to forbid going outside the item directory
+	cleanPath := filepath.Clean("/" + assetPath)
+	for _, part := range strings.Split(cleanPath, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("hidden files are not served: %s", assetPath)
+		}
+	}
+
+	return filepath.Join(dir, cleanPath), nil
+}
+
+func (s *Server) HandleGetAsset(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	courseId, itemId, assetPath := vars["course_id"], vars["item_id"], vars["path"]
+	userId := GetUserId(r)
+
+	path, err := GetAssetPath(GetCatalog(), courseId, itemId, assetPath)
+	if err != nil {
+		http.NotFound(w, r)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": courseId,
+			"item_id":   itemId,
+			"path":      assetPath,
+			"error":     err.Error(),
+		}).Warning("/assets: bad asset path")
+		return
+	}
+
+	denied, err := s.GetAccessDenied(r.Context(), userId, courseId, itemId)
+	if err != nil || denied != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"item_id":   itemId,
+		}).Info("/assets: user has no access to asset")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", assetsMaxAge)
+	if courseType, _, _ := GetCourseTypeAndTags(courseId); courseType == "paid" {
+		cacheControl = fmt.Sprintf("private, max-age=%d", assetsMaxAge)
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, stat.ModTime().Unix(), stat.Size()))
+	w.Header().Set("Content-Type", assetContentTypes[strings.ToLower(filepath.Ext(path))])
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Scripts in svg must not be executed
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+
+	// Handles Range, If-None-Match and If-Modified-Since headers
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), f)
+}
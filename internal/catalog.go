@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Catalog is an in-process snapshot of courses, chapters, tasks and practice projects
+// with markdown texts of them. Catalog changes only on courses import, so it is loaded
+// at startup and swapped atomically on reload. Snapshot is immutable: copy data before changing it.
+
+type CatalogCourse struct {
+	CourseForUser
+
+	// Chapter ids in reading order: each section is followed by its chapters
+	ChapterIds []string
+
+	// Chapters and practice projects in reading order
+	Items []ChapterForUser
+}
+
+type CatalogChapter struct {
+	ChapterId       string
+	CourseId        string
+	Title           string
+	ParentChapterId string
+	TaskIds         []string
+}
+
+type CatalogPractice struct {
+	Practice
+	ProjectId string
+	CourseId  string
+}
+
+type Catalog struct {
+	Version int64
+	DtLoad  time.Time
+
+	CourseIds []string
+	Courses   map[string]*CatalogCourse
+	Chapters  map[string]*CatalogChapter
+	Practice  map[string]*CatalogPractice
+
+	// Task id -> chapter id
+	Tasks map[string]string
+
+	// Task id -> course revision in which task content changed last time
+	TaskRevisions map[string]string
+
+	// Path to markdown file -> its content. Includes translated files: text.en.md
+	Texts map[string]string
+
+	// Item id (course, chapter or project id) -> locale -> translation
+	Translations map[string]map[string]Translation
+
+	// Locales which have any translated content
+	Locales map[string]bool
+
+	// Full-text index of chapters and practice projects
+	Search *SearchIndex
+
+	// Template id -> starter template of playground. Ids are sorted
+	Templates   map[string]*PlaygroundTemplate
+	TemplateIds []string
+
+	// Container types of watchman and course id -> lang id of its container
+	Containers       *ContainerRegistry
+	CourseContainers map[string]string
+
+	// Path to markdown file -> RenderedText. Filled on demand
+	rendered sync.Map
+}
+
+var catalog atomic.Value
+var catalogReloadMutex sync.Mutex
+
+func NewCatalog() *Catalog {
+	return &Catalog{
+		CourseIds: []string{},
+		Courses:   make(map[string]*CatalogCourse),
+		Chapters:  make(map[string]*CatalogChapter),
+		Practice:  make(map[string]*CatalogPractice),
+		Tasks:     make(map[string]string),
+		Texts:     make(map[string]string),
+
+		TaskRevisions: make(map[string]string),
+
+		Translations: make(map[string]map[string]Translation),
+		Locales:      map[string]bool{DefaultLocale: true},
+
+		Templates:   make(map[string]*PlaygroundTemplate),
+		TemplateIds: []string{},
+
+		Containers:       NewContainerRegistry(DefaultContainerTypes()),
+		CourseContainers: make(map[string]string),
+	}
+}
+
+// GetCatalog returns current catalog snapshot. It is empty until the first load.
+func GetCatalog() *Catalog {
+	if c, ok := catalog.Load().(*Catalog); ok {
+		return c
+	}
+
+	return NewCatalog()
+}
+
+func SetCatalog(c *Catalog) {
+	catalog.Store(c)
+}
+
+// ReloadCatalog loads new catalog snapshot from DB and disk and replaces current one.
+func ReloadCatalog(ctx context.Context, repo CatalogRepo) (*Catalog, error) {
+	catalogReloadMutex.Lock()
+	defer catalogReloadMutex.Unlock()
+
+	c, err := repo.LoadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.LoadContainers(GetContainersConfigPath())
+	c.BuildCourseContainers()
+
+	// Catalog is loaded without templates if they are broken: templates are not required for courses
+	c.LoadTemplates(GetTemplatesPath())
+
+	c.Version = GetCatalog().Version + 1
+	SetCatalog(c)
+
+	Logger.WithContext(ctx).WithFields(log.Fields{
+		"version":   c.Version,
+		"courses":   len(c.Courses),
+		"chapters":  len(c.Chapters),
+		"tasks":     len(c.Tasks),
+		"practice":  len(c.Practice),
+		"texts":     len(c.Texts),
+		"locales":   len(c.Locales),
+		"templates": len(c.TemplateIds),
+		"languages": len(c.Containers.LangIds),
+	}).Info("Loaded catalog")
+
+	return c, nil
+}
+
+func (c *Catalog) AddCourse(course CatalogCourse) {
+	c.CourseIds = append(c.CourseIds, course.CourseId)
+	c.Courses[course.CourseId] = &course
+}
+
+func (c *Catalog) AddChapter(chapter CatalogChapter) {
+	c.Chapters[chapter.ChapterId] = &chapter
+}
+
+func (c *Catalog) AddTask(taskId string, chapterId string) {
+	chapter, ok := c.Chapters[chapterId]
+	if !ok {
+		return
+	}
+
+	chapter.TaskIds = append(chapter.TaskIds, taskId)
+	c.Tasks[taskId] = chapterId
+}
+
+func (c *Catalog) AddPractice(p CatalogPractice) {
+	c.Practice[p.ProjectId] = &p
+}
+
+// Build orders chapters and practice projects of each course.
+func (c *Catalog) Build() {
+	sort.Strings(c.CourseIds)
+
+	items := make(map[string][]ChapterForUser)
+
+	chapterIds := make([]string, 0, len(c.Chapters))
+	for chapterId := range c.Chapters {
+		chapterIds = append(chapterIds, chapterId)
+	}
+	sort.Strings(chapterIds)
+
+	projectIds := make(map[string][]string)
+	for projectId, p := range c.Practice {
+		projectIds[p.ChapterId] = append(projectIds[p.ChapterId], projectId)
+	}
+
+	for _, chapterId := range chapterIds {
+		chapter := c.Chapters[chapterId]
+		sort.Strings(chapter.TaskIds)
+
+		items[chapter.CourseId] = append(items[chapter.CourseId], ChapterForUser{
+			ChapterId:       chapter.ChapterId,
+			Title:           chapter.Title,
+			Status:          "not_started",
+			ParentChapterId: chapter.ParentChapterId,
+			TasksTotal:      len(chapter.TaskIds),
+		})
+
+		// Practice projects follow their chapter
+		sort.Strings(projectIds[chapterId])
+		for _, projectId := range projectIds[chapterId] {
+			p := c.Practice[projectId]
+			items[p.CourseId] = append(items[p.CourseId], ChapterForUser{
+				ChapterId:       p.ProjectId,
+				Title:           p.Title,
+				Status:          "not_started",
+				ParentChapterId: chapter.ParentChapterId,
+				TasksTotal:      1,
+			})
+		}
+	}
+
+	for courseId, course := range c.Courses {
+		course.Items = OrderChaptersBySections(items[courseId])
+		course.ChapterIds = []string{}
+
+		for _, item := range course.Items {
+			if !IsPracticeId(item.ChapterId) {
+				course.ChapterIds = append(course.ChapterIds, item.ChapterId)
+			}
+		}
+	}
+}
+
+// LoadTexts reads markdown files of courses, chapters and practice projects with their translations.
+// Missing files are not cached: ReadCatalogText falls back to disk for them.
+func (c *Catalog) LoadTexts() {
+	paths := []string{}
+
+	for _, course := range c.Courses {
+		paths = append(paths, filepath.Join(course.Path, "description.md"))
+	}
+
+	for _, chapter := range c.Chapters {
+		contentPath, keywordsPath := GetPathToChapterText(chapter.CourseId, chapter.ChapterId)
+		paths = append(paths, contentPath, keywordsPath)
+	}
+
+	for _, p := range c.Practice {
+		practicePath := filepath.Join(RootCourses, p.CourseId, "practice", p.ProjectId)
+		paths = append(paths, filepath.Join(practicePath, "text.md"), filepath.Join(practicePath, "hint.md"))
+	}
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			c.Texts[path] = string(content)
+		}
+
+		// Translations: text.md -> text.en.md
+		base := strings.TrimSuffix(path, ".md")
+		localizedPaths, _ := filepath.Glob(base + ".*.md")
+
+		for _, localizedPath := range localizedPaths {
+			locale := strings.TrimSuffix(strings.TrimPrefix(localizedPath, base+"."), ".md")
+			if locale != NormalizeLocale(locale) || localizedPath != GetLocalizedPath(path, locale) {
+				continue
+			}
+
+			content, err := os.ReadFile(localizedPath)
+			if err == nil {
+				c.Texts[localizedPath] = string(content)
+				c.Locales[locale] = true
+			}
+		}
+	}
+}
+
+// ReadText returns cached content of markdown file or reads it from disk.
+func (c *Catalog) ReadText(path string) (string, error) {
+	if content, ok := c.Texts[path]; ok {
+		return content, nil
+	}
+
+	return ReadTextFile(path)
+}
+
+func ReadCatalogText(path string) (string, error) {
+	return GetCatalog().ReadText(path)
+}
+
+// RenderText returns markdown file of chapter or practice project (itemId) in given locale rendered to HTML.
+// Rendered texts are cached until catalog reload.
+func (c *Catalog) RenderText(courseId string, itemId string, path string, locale string) (RenderedText, error) {
+	key := GetLocalizedPath(path, locale)
+	if rendered, ok := c.rendered.Load(key); ok {
+		return rendered.(RenderedText), nil
+	}
+
+	markdown, err := c.ReadLocalizedText(path, locale)
+	if err != nil {
+		return RenderedText{}, err
+	}
+
+	rendered, err := RenderMarkdown(c, courseId, itemId, markdown)
+	if err != nil {
+		return RenderedText{}, err
+	}
+
+	c.rendered.Store(key, rendered)
+	return rendered, nil
+}
+
+func RenderCatalogText(courseId string, itemId string, path string, locale string) (RenderedText, error) {
+	return GetCatalog().RenderText(courseId, itemId, path, locale)
+}
+
+func (c *Catalog) GetCourse(courseId string) (*CatalogCourse, error) {
+	course, ok := c.Courses[courseId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return course, nil
+}
+
+func (c *Catalog) GetChapter(chapterId string) (*CatalogChapter, error) {
+	chapter, ok := c.Chapters[chapterId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return chapter, nil
+}
+
+func (c *Catalog) GetPractice(projectId string) (*CatalogPractice, error) {
+	p, ok := c.Practice[projectId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// GetItems returns copy of chapters and practice projects of the course in reading order.
+func (c *Catalog) GetItems(courseId string) []ChapterForUser {
+	course, ok := c.Courses[courseId]
+	if !ok {
+		return []ChapterForUser{}
+	}
+
+	items := make([]ChapterForUser, len(course.Items))
+	copy(items, course.Items)
+	return items
+}
+
+func (s *Server) HandleReloadCatalog(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	c, err := ReloadCatalog(r.Context(), s.store)
+	if err != nil {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"version": GetCatalog().Version,
+			"error":   err.Error(),
+		}).Error("/reload_catalog: couldn't load catalog")
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  -1,
+			"version": GetCatalog().Version,
+		})
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"version": c.Version,
+	}).Info("/reload_catalog: completed")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  0,
+		"version": c.Version,
+	})
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"testing"
+)
+
+func setTestCatalog() *Catalog {
+	c := NewCatalog()
+
+	var course CatalogCourse
+	course.CourseId = "rust"
+	course.Title = "Rust"
+	course.Tags = `{"title": "Rust"}`
+	c.AddCourse(course)
+
+	c.AddChapter(CatalogChapter{ChapterId: "rust_chapter_0010", CourseId: "rust", Title: "Basics"})
+	c.AddChapter(CatalogChapter{ChapterId: "rust_chapter_0011", CourseId: "rust", Title: "Variables", ParentChapterId: "rust_chapter_0010"})
+	c.AddChapter(CatalogChapter{ChapterId: "rust_chapter_0020", CourseId: "rust", Title: "Ownership"})
+	c.AddTask("rust_chapter_0011_task_0020", "rust_chapter_0011")
+	c.AddTask("rust_chapter_0011_task_0010", "rust_chapter_0011")
+
+	var p CatalogPractice
+	p.ProjectId = "rust_chapter_0011_project"
+	p.CourseId = "rust"
+	p.ChapterId = "rust_chapter_0011"
+	p.Title = "Calculator"
+	c.AddPractice(p)
+
+	c.Build()
+	SetCatalog(c)
+	return c
+}
+
+func TestCatalogChapters(t *testing.T) {
+	setTestCatalog()
+
+	plan := []string{"rust_chapter_0010", "rust_chapter_0011", "rust_chapter_0011_project", "rust_chapter_0020"}
+	fact := GetChapters("rust")
+
+	if len(plan) != len(fact) {
+		t.Fatalf(`Wrong chapters count. Plan: %v Fact: %v`, len(plan), len(fact))
+	}
+
+	for i := range plan {
+		if plan[i] != fact[i].ChapterId {
+			t.Fatalf(`Wrong chapter at position %v. Plan: %v Fact: %v`, i, plan[i], fact[i].ChapterId)
+		}
+	}
+
+	if fact[1].TasksTotal != 2 || fact[2].ParentChapterId != "rust_chapter_0010" {
+		t.Fatalf(`Wrong chapter details: %v %v`, fact[1], fact[2])
+	}
+
+	// Returned chapters are copies of catalog data
+	fact[0].Status = "completed"
+	if GetChapters("rust")[0].Status != "not_started" {
+		t.Fatalf(`Catalog is changed by caller`)
+	}
+}
+
+func TestCatalogNextChapterId(t *testing.T) {
+	setTestCatalog()
+
+	firstChapterId, err := GetFirstChapterId("rust")
+	if err != nil || firstChapterId != "rust_chapter_0010" {
+		t.Fatalf(`Wrong first chapter: %v %v`, firstChapterId, err)
+	}
+
+	nextChapterId, err := GetNextChapterId("rust", "rust_chapter_0011", false)
+	if err != nil || nextChapterId != "rust_chapter_0020" {
+		t.Fatalf(`Wrong next chapter: %v %v`, nextChapterId, err)
+	}
+
+	nextChapterId, err = GetNextChapterId("rust", "rust_chapter_0011", true)
+	if err != nil || nextChapterId != "rust_chapter_0011_project" {
+		t.Fatalf(`Wrong next practice: %v %v`, nextChapterId, err)
+	}
+
+	_, err = GetNextChapterId("rust", "rust_chapter_0020", false)
+	if err == nil {
+		t.Fatalf(`Found next chapter for the last chapter`)
+	}
+
+	parentId, parentTitle, err := GetParentChapter("rust_chapter_0011")
+	if err != nil || parentId != "rust_chapter_0010" || parentTitle != "Basics" {
+		t.Fatalf(`Wrong parent chapter: %v %v %v`, parentId, parentTitle, err)
+	}
+}
+
+func TestTaskRevisions(t *testing.T) {
+	c := setTestCatalog()
+	c.TaskRevisions["rust_chapter_0011_task_0010"] = "a1b2"
+
+	if IsTaskChanged("rust_chapter_0011_task_0010", "a1b2") {
+		t.Fatalf(`Task is changed for the current revision`)
+	}
+
+	if !IsTaskChanged("rust_chapter_0011_task_0010", "c3d4") {
+		t.Fatalf(`Task is not changed for the previous revision`)
+	}
+
+	// Progress saved before revisions were recorded and task without revision
+	if IsTaskChanged("rust_chapter_0011_task_0010", "") || IsTaskChanged("rust_chapter_0011_task_0020", "c3d4") {
+		t.Fatalf(`Task without revision is changed`)
+	}
+
+	if IsRevisionPolicyValid(RevisionPolicyPending) || !IsRevisionPolicyValid(RevisionPolicyMigrate) {
+		t.Fatalf(`Wrong policy validation`)
+	}
+}
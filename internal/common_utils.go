@@ -1,12 +1,14 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -44,13 +46,25 @@ type Options struct {
 	Status string `json:"status,omitempty"`
 
 	// Filled based on the chapter id prefix
-	containerType string `json:"container_type"`
+	containerType string
 
 	userId string
 
 	ColorOutput          bool   `json:"color_output,omitempty"`
 	RunStaticTypeChecker bool   `json:"run_static_type_checker,omitempty"`
 	ExampleId            string `json:"example_id,omitempty"`
+
+	// Stdin and arguments of program. Allowed only for examples
+	ProgramInput
+
+	// "html": return texts rendered to HTML in addition to markdown
+	Format string `json:"format,omitempty"`
+
+	// Preferred locale of content. Has priority over Accept-Language header
+	Lang string `json:"lang,omitempty"`
+
+	// Filled based on lang option and Accept-Language header
+	locale string
 }
 
 type OptionsPlayground struct {
@@ -58,15 +72,31 @@ type OptionsPlayground struct {
 	LangId       string `json:"lang_id,omitempty"`
 	UserCode     string `json:"user_code,omitempty"`
 	Project      string `json:"project,omitempty"`
-	userId       string
+	// private, unlisted or public. Empty keeps visibility of saved playground
+	Visibility string `json:"visibility,omitempty"`
+	// Owner of listed playgrounds. Other users get only public ones
+	OwnerId string `json:"owner_id,omitempty"`
+	// Starter template of project. Project and code of request have priority over template
+	TemplateId string `json:"template_id,omitempty"`
+	// Token which allows to update anonymous playground
+	EditToken string `json:"edit_token,omitempty"`
+	ProgramInput
+	userId string
+	// Set if playground is saved as fork
+	parentId string
+	// Set if anonymous playground is created
+	newEditToken string
 }
 
 type WatchmanOptions struct {
-	SourceCodeRun  string   `json:"source_run"`
-	Project        string   `json:"project,omitempty"`
-	SourceCodeTest string   `json:"source_test,omitempty"`
-	ContainerType  string   `json:"container_type"`
-	CmdLineArgs    []string `json:"cmd_line_args,omitempty"`
+	SourceCodeRun  string `json:"source_run"`
+	Project        string `json:"project,omitempty"`
+	SourceCodeTest string `json:"source_test,omitempty"`
+	ContainerType  string `json:"container_type"`
+	// Flags of runner. Input of user program is passed separately
+	CmdLineArgs []string `json:"cmd_line_args,omitempty"`
+	TimeoutSec  int      `json:"timeout_sec,omitempty"`
+	ProgramInput
 }
 
 type Practice struct {
@@ -78,6 +108,12 @@ type Practice struct {
 	Project            string `json:"project,omitempty"`
 	ProjectDescription string `json:"project_description,omitempty"`
 	ProjectHint        string `json:"project_hint,omitempty"`
+
+	// Filled if HTML format is requested
+	ProjectDescriptionHtml string     `json:"project_description_html,omitempty"`
+	ProjectHintHtml        string     `json:"project_hint_html,omitempty"`
+	Toc                    []TocEntry `json:"toc,omitempty"`
+
 	Tags               string `json:"tags,omitempty"`
 	MainFile           string `json:"main_file,omitempty"`
 	DefaultCmdLineArgs string `json:"default_cmd_line_args,omitempty"`
@@ -91,6 +127,7 @@ func ParseOptions(r *http.Request) (Options, error) {
 	}
 
 	opts.userId = GetUserId(r)
+	opts.locale = GetLocale(r, opts.Lang)
 
 	if len(opts.TaskId) > 0 {
 		err := FillOptionsByTaskId(&opts)
@@ -143,29 +180,6 @@ func FillOptionsByChapterId(opts *Options) error {
 	return nil
 }
 
-func GetContainerType(s string) string {
-	if strings.HasPrefix(s, "python") {
-		return "python"
-	}
-	if strings.HasPrefix(s, "rust") {
-		return "rust"
-	}
-
-	if strings.HasPrefix(s, "go") {
-		return "golang"
-	}
-
-	if strings.HasPrefix(s, "haskell") {
-		return "haskell"
-	}
-
-	if strings.HasPrefix(s, "cpp") {
-		return "cpp"
-	}
-
-	return ""
-}
-
 func GetUserId(r *http.Request) string {
 	urlParams := r.URL.Query()
 	return urlParams.Get("user_id")
@@ -232,7 +246,10 @@ func normalizeCodePlayground(opts *OptionsPlayground) {
 	opts.UserCode = strings.Map(runeReplacementMapping, opts.UserCode)
 }
 
-func InjectCodeToTestWrapper(opts *Options) error {
+func InjectCodeToTestWrapper(ctx context.Context, opts *Options) (err error) {
+	span := startWrapperSpan(ctx, opts, "wrapper_test")
+	defer func() { endSpan(span, err) }()
+
 	wrapperPath := GetPathToWrapper(opts, "wrapper_test")
 	content, err := ReadTextFile(wrapperPath)
 	if err != nil {
@@ -247,7 +264,10 @@ func InjectCodeToTestWrapper(opts *Options) error {
 	return nil
 }
 
-func InjectCodeToWrapper(opts *Options, wrapperName string) error {
+func InjectCodeToWrapper(ctx context.Context, opts *Options, wrapperName string) (err error) {
+	span := startWrapperSpan(ctx, opts, wrapperName)
+	defer func() { endSpan(span, err) }()
+
 	if opts.TaskType != "code" {
 		opts.SourceCodeRun = ""
 		return nil
@@ -316,6 +336,7 @@ type CourseForUser struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Tags        string `json:"tags"`
+	Revision    string `json:"revision,omitempty"`
 }
 
 type CourseStatus struct {
@@ -328,6 +349,8 @@ type CourseStatus struct {
 	TotalProjects    int    `json:"total_projects"`
 	FinishedProjects int    `json:"finished_projects"`
 	Status           string `json:"status,omitempty"`
+
+	DtLastActivity *time.Time `json:"dt_last_activity,omitempty"`
 }
 
 type ChapterForUser struct {
@@ -340,12 +363,23 @@ type ChapterForUser struct {
 	ParentChapterTitle string `json:"parent_chapter_title,omitempty"`
 	TasksTotal         int    `json:"tasks_total"`
 	TasksCompleted     int    `json:"tasks_completed"`
+
+	// Filled only for blocked chapters: ids of materials to complete first
+	LockedBy []string `json:"locked_by,omitempty"`
+
+	// Filled only for sections: chapters of section and progress on them
+	ChaptersTotal     int              `json:"chapters_total,omitempty"`
+	ChaptersCompleted int              `json:"chapters_completed,omitempty"`
+	Chapters          []ChapterForUser `json:"chapters,omitempty"`
 }
 
 type TaskForUser struct {
 	TaskId   string `json:"task_id"`
 	UserCode string `json:"task_code"`
 	Status   string `json:"status"`
+
+	// Task changed since user solved it
+	IsChanged bool `json:"is_changed,omitempty"`
 }
 
 type ChapterContent struct {
@@ -354,6 +388,10 @@ type ChapterContent struct {
 	Tasks      []TaskForUser `json:"tasks"`
 	Keywords   string        `json:"keywords,omitempty"`
 	IsPractice bool          `json:"is_practice"`
+
+	// Filled if HTML format is requested
+	Html string     `json:"html,omitempty"`
+	Toc  []TocEntry `json:"toc,omitempty"`
 }
 
 type UserProgress struct {
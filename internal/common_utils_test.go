@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"strings"
 	"testing"
 )
 
@@ -59,8 +58,9 @@ func TestGetPathToTaskWrapper(t *testing.T) {
 		t.Fatalf(`Couldn't fill options by task id: %v`, err)
 	}
 
-	plan := "/data/courses/go/go_chapter_0006/tasks/go_chapter_0006_task_0001/wrapper"
-	fact := GetPathToTaskWrapper(&opts)
+	// There is no such wrapper on disk, so the course fallback is expected
+	plan := "/data/courses/go/wrapper_run_fallback"
+	fact := GetPathToWrapper(&opts, "wrapper_run")
 
 	if plan != fact {
 		t.Fatalf(`Wrong path. Plan: %v Fact: %v`, plan, fact)
@@ -71,31 +71,14 @@ func TestGetPathToChapterText(t *testing.T) {
 	courseId := "rust"
 	chapterId := "rust_chapter_0052"
 	plan := "/data/courses/rust/rust_chapter_0052/text.md"
-	fact, err := GetPathToChapterText(courseId, chapterId)
-
-	if err != nil {
-		t.Fatalf(`Couldn't get path to chapter text: %v`, err)
-	}
+	planKeywords := "/data/courses/rust/rust_chapter_0052/keywords.md"
+	fact, factKeywords := GetPathToChapterText(courseId, chapterId)
 
 	if plan != fact {
 		t.Fatalf(`Wrong path. Plan: %v Fact: %v`, plan, fact)
 	}
-}
-
-func TestGenTaskTmpId(t *testing.T) {
-	var opts Options
-	opts.TaskId = "bash_chapter_0008_task_0011"
-	opts.userId = "73563"
-
-	err := FillOptionsByTaskId(&opts)
-	if err != nil {
-		t.Fatalf(`Couldn't fill options by task id: %v`, err)
-	}
-
-	planPrefix := "73563_bash_chapter_0008_task_0011_"
-	fact := genTaskTmpId(opts)
 
-	if !strings.HasPrefix(fact, planPrefix) {
-		t.Fatalf(`Wrong task tmp id. Plan prefix: %v Fact string: %v`, planPrefix, fact)
+	if planKeywords != factKeywords {
+		t.Fatalf(`Wrong keywords path. Plan: %v Fact: %v`, planKeywords, factKeywords)
 	}
 }
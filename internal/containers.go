@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Registry of container types of watchman: languages with options of their runners.
+// Built-in types are overridden and extended by json config next to courses:
+// [{"lang_id": "java", "title": "Java", "timeout_sec": 20}]
+// Course declares its container type in tags.json: "lang_id": "golang".
+// Courses without declaration get container type by id: "go_chapter_0010" -> golang.
+
+// Path to config of container types. If it's empty, config is next to courses: /data/courses -> /data/containers.json
+var ContainersConfigPath string
+
+type ContainerType struct {
+	LangId string `json:"lang_id"`
+	Title  string `json:"title"`
+	// Prefixes of course ids besides lang_id: course "go" is golang
+	Aliases []string `json:"aliases,omitempty"`
+	// Language has static type checker: shown by /get_languages.
+	// Flag "-t strict" is passed to runner on request for any language
+	StaticTypeChecker bool `json:"static_type_checker"`
+	// Flags of runner for colored and plain output. Empty flag isn't passed
+	ColorFlagAlways string `json:"color_flag_always,omitempty"`
+	ColorFlagNever  string `json:"color_flag_never,omitempty"`
+	// Limit of run passed to watchman. Zero means default limit of watchman
+	TimeoutSec int `json:"timeout_sec,omitempty"`
+}
+
+type ContainerRegistry struct {
+	// Sorted lang ids
+	LangIds []string
+	Types   map[string]*ContainerType
+
+	// Lang id or alias -> lang id
+	prefixes map[string]string
+}
+
+func DefaultContainerTypes() []ContainerType {
+	return []ContainerType{
+		{LangId: "cpp", Title: "C++", ColorFlagAlways: "-c always", ColorFlagNever: "-c never"},
+		{LangId: "golang", Title: "Go", Aliases: []string{"go"}, ColorFlagAlways: "-c always", ColorFlagNever: "-c never"},
+		{LangId: "haskell", Title: "Haskell", ColorFlagAlways: "-c always", ColorFlagNever: "-c never"},
+		{LangId: "python", Title: "Python", StaticTypeChecker: true, ColorFlagAlways: "-c always", ColorFlagNever: "-c never"},
+		{LangId: "rust", Title: "Rust", ColorFlagAlways: "-c always", ColorFlagNever: "-c never"},
+	}
+}
+
+func NewContainerRegistry(types []ContainerType) *ContainerRegistry {
+	registry := &ContainerRegistry{
+		LangIds:  []string{},
+		Types:    make(map[string]*ContainerType),
+		prefixes: make(map[string]string),
+	}
+
+	for _, t := range types {
+		registry.Add(t)
+	}
+
+	return registry
+}
+
+// Add registers container type or replaces registered one with the same lang id
+func (r *ContainerRegistry) Add(t ContainerType) {
+	if old, ok := r.Types[t.LangId]; ok {
+		for _, alias := range old.Aliases {
+			delete(r.prefixes, alias)
+		}
+	} else {
+		r.LangIds = append(r.LangIds, t.LangId)
+		sort.Strings(r.LangIds)
+	}
+
+	r.Types[t.LangId] = &t
+	r.prefixes[t.LangId] = t.LangId
+	for _, alias := range t.Aliases {
+		r.prefixes[alias] = t.LangId
+	}
+}
+
+// Get returns container type by lang id or its alias
+func (r *ContainerRegistry) Get(langId string) (*ContainerType, bool) {
+	t, ok := r.Types[r.prefixes[langId]]
+	return t, ok
+}
+
+// GetByPrefix returns lang id by prefix of course, chapter or task id: "go_chapter_0010" -> golang.
+// Prefix ends with "_", so "gorm_basics" isn't golang.
+func (r *ContainerRegistry) GetByPrefix(id string) string {
+	if langId, ok := r.prefixes[id]; ok {
+		return langId
+	}
+
+	if i := strings.Index(id, splitChar); i > 0 {
+		return r.prefixes[id[:i]]
+	}
+
+	return ""
+}
+
+func GetContainersConfigPath() string {
+	if len(ContainersConfigPath) > 0 {
+		return ContainersConfigPath
+	}
+
+	return filepath.Join(filepath.Dir(filepath.Clean(RootCourses)), "containers.json")
+}
+
+// LoadContainers reads config of container types over built-in ones. Missing config isn't an error.
+func (c *Catalog) LoadContainers(path string) {
+	c.Containers = NewContainerRegistry(DefaultContainerTypes())
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	var types []ContainerType
+	if err == nil {
+		err = json.Unmarshal(content, &types)
+	}
+
+	if err != nil {
+		Logger.WithFields(log.Fields{
+			"path":  path,
+			"error": err.Error(),
+		}).Warning("Couldn't read config of container types. Using built-in ones")
+		return
+	}
+
+	for _, t := range types {
+		if !isValidPlaygroundId(t.LangId) || strings.Contains(t.LangId, splitChar) {
+			Logger.WithFields(log.Fields{
+				"lang_id": t.LangId,
+			}).Warning("Skipping container type with invalid lang_id")
+			continue
+		}
+
+		c.Containers.Add(t)
+	}
+}
+
+// ParseCourseLangId returns container type declared in tags.json of course or empty string
+func ParseCourseLangId(tags string) (string, error) {
+	var courseTags struct {
+		LangId string `json:"lang_id"`
+	}
+
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	err := json.Unmarshal([]byte(tags), &courseTags)
+	return courseTags.LangId, err
+}
+
+// BuildCourseContainers resolves container type of every course: declared in tags.json or by course id
+func (c *Catalog) BuildCourseContainers() {
+	c.CourseContainers = make(map[string]string)
+
+	for courseId, course := range c.Courses {
+		langId, err := ParseCourseLangId(course.Tags)
+		if err != nil {
+			Logger.WithFields(log.Fields{
+				"course_id": courseId,
+				"error":     err.Error(),
+			}).Warning("Couldn't parse lang_id of course from tags")
+		}
+
+		if len(langId) > 0 {
+			if t, ok := c.Containers.Get(langId); ok {
+				c.CourseContainers[courseId] = t.LangId
+				continue
+			}
+
+			Logger.WithFields(log.Fields{
+				"course_id": courseId,
+				"lang_id":   langId,
+			}).Warning("Course declares unknown lang_id")
+		}
+
+		if langId := c.Containers.GetByPrefix(courseId); len(langId) > 0 {
+			c.CourseContainers[courseId] = langId
+		}
+	}
+}
+
+// GetCourseIdOf returns course of course, chapter, task or practice project id from catalog
+func (c *Catalog) GetCourseIdOf(id string) (string, bool) {
+	if _, ok := c.Courses[id]; ok {
+		return id, true
+	}
+
+	if chapterId, ok := c.Tasks[id]; ok {
+		id = chapterId
+	}
+
+	if chapter, ok := c.Chapters[id]; ok {
+		return chapter.CourseId, true
+	}
+
+	if p, ok := c.Practice[id]; ok {
+		return p.CourseId, true
+	}
+
+	return "", false
+}
+
+// GetContainerType returns lang id of container for course, chapter, task or practice project id.
+// Ids missing in catalog are resolved by prefix.
+func (c *Catalog) GetContainerType(id string) string {
+	if courseId, ok := c.GetCourseIdOf(id); ok {
+		if langId, ok := c.CourseContainers[courseId]; ok {
+			return langId
+		}
+	}
+
+	return c.Containers.GetByPrefix(id)
+}
+
+func GetContainerType(id string) string {
+	return GetCatalog().GetContainerType(id)
+}
+
+// GetLangId returns lang id of registered container type by lang id or alias: "go" -> golang
+func GetLangId(langId string) string {
+	if t, ok := GetCatalog().Containers.Get(langId); ok {
+		return t.LangId
+	}
+
+	return ""
+}
+
+// GetRunnerFlags returns flags of runner for task run
+func (t *ContainerType) GetRunnerFlags(colorOutput bool, staticTypeChecker bool) []string {
+	flags := []string{}
+
+	colorFlag := t.ColorFlagNever
+	if colorOutput {
+		colorFlag = t.ColorFlagAlways
+	}
+
+	if len(colorFlag) > 0 {
+		flags = append(flags, colorFlag)
+	}
+
+	if staticTypeChecker {
+		flags = append(flags, "-t strict")
+	}
+
+	return flags
+}
+
+// HandleGetLanguages lists supported languages with options of their runners.
+func (s *Server) HandleGetLanguages(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	registry := GetCatalog().Containers
+	languages := make([]*ContainerType, 0, len(registry.LangIds))
+	for _, langId := range registry.LangIds {
+		languages = append(languages, registry.Types[langId])
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"languages": languages,
+	})
+}
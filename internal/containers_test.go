@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetContainerTypeByCourse(t *testing.T) {
+	newTestServer()
+
+	config := filepath.Join(t.TempDir(), "containers.json")
+	err := os.WriteFile(config, []byte(`[
+		{"lang_id": "kotlin", "title": "Kotlin", "aliases": ["kt"], "timeout_sec": 20},
+		{"lang_id": "python", "title": "Python", "color_flag_always": "--color"},
+		{"lang_id": "bad_id"}
+	]`), 0644)
+	if err != nil {
+		t.Fatalf("Couldn't write config: %v", err)
+	}
+
+	c := NewCatalog()
+	c.AddCourse(CatalogCourse{CourseForUser: CourseForUser{CourseId: "gorm_basics", Tags: `{"lang_id": "golang"}`}})
+	c.AddCourse(CatalogCourse{CourseForUser: CourseForUser{CourseId: "gopher"}})
+	c.AddCourse(CatalogCourse{CourseForUser: CourseForUser{CourseId: "android", Tags: `{"lang_id": "kt"}`}})
+	c.AddCourse(CatalogCourse{CourseForUser: CourseForUser{CourseId: "cobol", Tags: `{"lang_id": "cobol"}`}})
+	c.AddChapter(CatalogChapter{ChapterId: "gorm_basics_chapter_0010", CourseId: "gorm_basics"})
+	c.AddTask("gorm_basics_chapter_0010_task_0010", "gorm_basics_chapter_0010")
+	c.Build()
+
+	c.LoadContainers(config)
+	c.BuildCourseContainers()
+
+	plan := []string{"cpp", "golang", "haskell", "kotlin", "python", "rust"}
+	if !reflect.DeepEqual(c.Containers.LangIds, plan) {
+		t.Fatalf("Wrong container types. Plan: %v Fact: %v", plan, c.Containers.LangIds)
+	}
+
+	tests := []struct {
+		id     string
+		langId string
+	}{
+		// Declared in tags.json
+		{"gorm_basics", "golang"},
+		{"gorm_basics_chapter_0010", "golang"},
+		{"gorm_basics_chapter_0010_task_0010", "golang"},
+		{"android", "kotlin"},
+		// By prefix of id
+		{"go_chapter_0006_task_0001", "golang"},
+		{"python_chapter_0031", "python"},
+		{"kt_chapter_0010", "kotlin"},
+		{"gopher", ""},
+		{"cobol", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if langId := c.GetContainerType(test.id); langId != test.langId {
+			t.Fatalf("Wrong container type of %v. Plan: %v Fact: %v", test.id, test.langId, langId)
+		}
+	}
+
+	python, _ := c.Containers.Get("python")
+	if flags := python.GetRunnerFlags(true, true); !reflect.DeepEqual(flags, []string{"--color", "-t strict"}) {
+		t.Fatalf("Wrong flags of overridden container type. Plan: %v Fact: %v", []string{"--color", "-t strict"}, flags)
+	}
+
+	rust, _ := c.Containers.Get("rust")
+	if flags := rust.GetRunnerFlags(false, true); !reflect.DeepEqual(flags, []string{"-c never", "-t strict"}) {
+		t.Fatalf("Wrong flags of rust. Plan: %v Fact: %v", []string{"-c never", "-t strict"}, flags)
+	}
+}
+
+func TestHandleGetLanguages(t *testing.T) {
+	s, _ := newTestServer()
+
+	var response struct {
+		Languages []ContainerType `json:"languages"`
+	}
+	if err := callHandler(s.HandleGetLanguages, "/get_languages", "", &response); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if len(response.Languages) != 5 || response.Languages[1].LangId != "golang" || response.Languages[1].Aliases[0] != "go" {
+		t.Fatalf("Wrong languages. Plan: %v Fact: %v", "5 built-in languages", response.Languages)
+	}
+}
@@ -0,0 +1,396 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Write-behind of non-critical writes: marks of started courses and user interactions
+// are not needed by the response, so handlers enqueue them instead of waiting for DB.
+// Queued writes are applied in batches by worker pool. Failed writes go to retry queue
+// which is saved to disk: they survive DB outages and restarts of handyman.
+// All deferred writes are idempotent, so retry of partially applied batch is ok.
+
+const (
+	DeferredStartCourse     = "start_course"
+	DeferredUserInteraction = "user_interaction"
+)
+
+type DeferredWrite struct {
+	Kind   string `json:"kind"`
+	UserId string `json:"user_id"`
+	// Course id or interaction key
+	Key      string    `json:"key"`
+	Value    string    `json:"value,omitempty"`
+	Attempts int       `json:"attempts"`
+	DtCreate time.Time `json:"dt_create"`
+}
+
+const deferredBatchSize = 100
+const deferredFlushInterval = time.Second
+const deferredRetryInterval = 5 * time.Second
+const deferredWriteTimeout = 10 * time.Second
+
+// Queued writes over capacity go directly to retry queue
+const deferredQueueCapacity = 10000
+
+// Failed writes are dropped after this count of attempts or when retry queue is full
+const deferredMaxAttempts = 20
+const deferredMaxRetryQueue = 100000
+
+var deferredQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "handyman_deferred_writes_queue_depth",
+	Help: "Deferred writes waiting in queue: pending, batches waiting for worker, retry",
+}, []string{"queue"})
+
+var deferredWritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "handyman_deferred_writes_total",
+	Help: "Deferred writes labelled by kind and result: applied, retried, dropped",
+}, []string{"kind", "result"})
+
+type WriteBehind struct {
+	repo DeferredRepo
+	pool *workerpool.WorkerPool
+
+	// File with retry queue. Empty if retry queue is kept only in memory
+	path string
+
+	batchSize     int
+	flushInterval time.Duration
+	retryInterval time.Duration
+
+	queue   chan DeferredWrite
+	stop    chan struct{}
+	stopped chan struct{}
+
+	mutex   sync.Mutex
+	retry   []DeferredWrite
+	dtRetry time.Time
+	closed  bool
+}
+
+// NewWriteBehind loads retry queue saved by previous run and starts applying writes.
+func NewWriteBehind(repo DeferredRepo, pool *workerpool.WorkerPool, path string) *WriteBehind {
+	wb := newWriteBehind(repo, pool, path)
+	go wb.run()
+	return wb
+}
+
+func newWriteBehind(repo DeferredRepo, pool *workerpool.WorkerPool, path string) *WriteBehind {
+	wb := &WriteBehind{
+		repo:          repo,
+		pool:          pool,
+		path:          path,
+		batchSize:     deferredBatchSize,
+		flushInterval: deferredFlushInterval,
+		retryInterval: deferredRetryInterval,
+		queue:         make(chan DeferredWrite, deferredQueueCapacity),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		retry:         []DeferredWrite{},
+	}
+
+	retry, err := loadRetryQueue(path)
+	if err != nil {
+		Logger.WithFields(log.Fields{
+			"path":  path,
+			"error": err.Error(),
+		}).Error("[worker pool] couldn't load retry queue of deferred writes")
+	}
+
+	if len(retry) > 0 {
+		Logger.WithFields(log.Fields{
+			"path":  path,
+			"count": len(retry),
+		}).Info("[worker pool] loaded retry queue of deferred writes")
+	}
+
+	wb.retry = append(wb.retry, retry...)
+	deferredQueueDepth.WithLabelValues("retry").Set(float64(len(wb.retry)))
+	return wb
+}
+
+func loadRetryQueue(path string) ([]DeferredWrite, error) {
+	writes := []DeferredWrite{}
+	if len(path) == 0 {
+		return writes, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return writes, nil
+	}
+
+	if err != nil {
+		return writes, err
+	}
+
+	err = json.Unmarshal(content, &writes)
+	return writes, err
+}
+
+// Enqueue schedules write. It never blocks: if queue is full or writer is closed, write goes to retry queue.
+func (wb *WriteBehind) Enqueue(w DeferredWrite) {
+	if w.DtCreate.IsZero() {
+		w.DtCreate = time.Now()
+	}
+
+	// Closed flag is checked and write is queued under the same mutex as in Close:
+	// write can't get to queue after it is drained
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	if !wb.closed {
+		select {
+		case wb.queue <- w:
+			return
+		default:
+		}
+	}
+
+	wb.addRetry(w)
+	wb.saveRetry()
+}
+
+func (wb *WriteBehind) run() {
+	defer close(wb.stopped)
+
+	ticker := time.NewTicker(wb.flushInterval)
+	defer ticker.Stop()
+
+	batch := []DeferredWrite{}
+
+	for {
+		select {
+		case w := <-wb.queue:
+			batch = append(batch, w)
+			if len(batch) >= wb.batchSize {
+				wb.submit(batch)
+				batch = []DeferredWrite{}
+			}
+
+		case <-ticker.C:
+			wb.submit(batch)
+			batch = []DeferredWrite{}
+			wb.submitRetry(false)
+
+		case <-wb.stop:
+			for len(wb.queue) > 0 {
+				batch = append(batch, <-wb.queue)
+			}
+
+			for len(batch) > 0 {
+				n := len(batch)
+				if n > wb.batchSize {
+					n = wb.batchSize
+				}
+
+				wb.submit(batch[:n])
+				batch = batch[n:]
+			}
+			return
+		}
+
+		deferredQueueDepth.WithLabelValues("pending").Set(float64(len(wb.queue) + len(batch)))
+		deferredQueueDepth.WithLabelValues("workers").Set(float64(wb.pool.WaitingQueueSize()))
+	}
+}
+
+func (wb *WriteBehind) submit(batch []DeferredWrite) {
+	if len(batch) == 0 {
+		return
+	}
+
+	wb.pool.Submit(func() {
+		wb.apply(batch)
+	})
+}
+
+// submitRetry moves retry queue to worker pool once in retry interval or immediately if force is set.
+func (wb *WriteBehind) submitRetry(force bool) {
+	wb.mutex.Lock()
+	if len(wb.retry) == 0 || (!force && time.Since(wb.dtRetry) < wb.retryInterval) {
+		wb.mutex.Unlock()
+		return
+	}
+
+	// File keeps writes in flight until the next save: they are idempotent, so crash only repeats them
+	retry := wb.retry
+	wb.retry = []DeferredWrite{}
+	wb.dtRetry = time.Now()
+	wb.mutex.Unlock()
+
+	for len(retry) > 0 {
+		n := len(retry)
+		if n > wb.batchSize {
+			n = wb.batchSize
+		}
+
+		wb.submit(retry[:n])
+		retry = retry[n:]
+	}
+}
+
+// apply writes batch. If batch fails, writes are applied one by one: bad write doesn't hold back others.
+func (wb *WriteBehind) apply(batch []DeferredWrite) {
+	ctx, cancel := context.WithTimeout(context.Background(), deferredWriteTimeout)
+	defer cancel()
+
+	err := wb.repo.ApplyDeferredWrites(ctx, batch)
+	if err == nil {
+		for _, w := range batch {
+			deferredWritesTotal.WithLabelValues(w.Kind, "applied").Inc()
+		}
+		return
+	}
+
+	failed := []DeferredWrite{}
+
+	if len(batch) == 1 {
+		failed = batch
+	} else {
+		for _, w := range batch {
+			if err := wb.repo.ApplyDeferredWrites(ctx, []DeferredWrite{w}); err != nil {
+				failed = append(failed, w)
+				continue
+			}
+
+			deferredWritesTotal.WithLabelValues(w.Kind, "applied").Inc()
+		}
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+
+	Logger.WithFields(log.Fields{
+		"batch":  len(batch),
+		"failed": len(failed),
+		"error":  err.Error(),
+	}).Warning("[worker pool] couldn't apply deferred writes")
+
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	for _, w := range failed {
+		wb.addRetry(w)
+	}
+	wb.saveRetry()
+}
+
+// addRetry puts write to retry queue. Called under mutex.
+func (wb *WriteBehind) addRetry(w DeferredWrite) {
+	w.Attempts++
+	if w.Attempts > deferredMaxAttempts {
+		deferredWritesTotal.WithLabelValues(w.Kind, "dropped").Inc()
+
+		Logger.WithFields(log.Fields{
+			"kind":     w.Kind,
+			"user_id":  w.UserId,
+			"key":      w.Key,
+			"attempts": w.Attempts,
+		}).Error("[worker pool] deferred write is dropped: too many attempts")
+		return
+	}
+
+	if len(wb.retry) >= deferredMaxRetryQueue {
+		dropped := wb.retry[0]
+		wb.retry = wb.retry[1:]
+		deferredWritesTotal.WithLabelValues(dropped.Kind, "dropped").Inc()
+
+		Logger.WithFields(log.Fields{
+			"kind":    dropped.Kind,
+			"user_id": dropped.UserId,
+			"key":     dropped.Key,
+		}).Error("[worker pool] deferred write is dropped: retry queue is full")
+	}
+
+	wb.retry = append(wb.retry, w)
+	deferredWritesTotal.WithLabelValues(w.Kind, "retried").Inc()
+}
+
+// saveRetry writes retry queue to file. Called under mutex.
+func (wb *WriteBehind) saveRetry() {
+	deferredQueueDepth.WithLabelValues("retry").Set(float64(len(wb.retry)))
+
+	if len(wb.path) == 0 {
+		return
+	}
+
+	content, err := json.Marshal(wb.retry)
+	if err == nil {
+		// Rename is atomic: file is never half-written
+		err = os.WriteFile(wb.path+".tmp", content, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(wb.path+".tmp", wb.path)
+	}
+
+	if err != nil {
+		Logger.WithFields(log.Fields{
+			"path":  wb.path,
+			"count": len(wb.retry),
+			"error": err.Error(),
+		}).Error("[worker pool] couldn't save retry queue of deferred writes")
+	}
+}
+
+// RetryQueueLen returns count of failed writes waiting for retry
+func (wb *WriteBehind) RetryQueueLen() int {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	return len(wb.retry)
+}
+
+// Close applies queued writes, makes the last attempt of failed ones and saves the rest to disk.
+// Writes enqueued after Close go to retry queue.
+func (wb *WriteBehind) Close(ctx context.Context) error {
+	wb.mutex.Lock()
+	if wb.closed {
+		wb.mutex.Unlock()
+		return nil
+	}
+	wb.closed = true
+	wb.mutex.Unlock()
+
+	close(wb.stop)
+	<-wb.stopped
+
+	wb.submitRetry(true)
+
+	done := make(chan struct{})
+	go func() {
+		wb.pool.StopWait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	wb.saveRetry()
+
+	Logger.WithFields(log.Fields{
+		"retry": len(wb.retry),
+		"path":  wb.path,
+	}).Info("[worker pool] flushed deferred writes")
+
+	return err
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gammazero/workerpool"
+)
+
+// Store which fails writes while it is down and refuses writes of user "bad"
+type flakyRepo struct {
+	*MemoryStore
+
+	mutex sync.Mutex
+	down  bool
+}
+
+func (r *flakyRepo) setDown(down bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.down = down
+}
+
+func (r *flakyRepo) ApplyDeferredWrites(ctx context.Context, writes []DeferredWrite) error {
+	r.mutex.Lock()
+	down := r.down
+	r.mutex.Unlock()
+
+	if down {
+		return errors.New("connection refused")
+	}
+
+	for _, w := range writes {
+		if w.UserId == "bad" {
+			return errors.New("invalid input syntax for type bigint")
+		}
+	}
+
+	return r.MemoryStore.ApplyDeferredWrites(ctx, writes)
+}
+
+func newTestWriteBehind(repo DeferredRepo, path string) *WriteBehind {
+	wb := newWriteBehind(repo, workerpool.New(2), path)
+	wb.flushInterval = 10 * time.Millisecond
+	wb.retryInterval = 10 * time.Millisecond
+	go wb.run()
+	return wb
+}
+
+func TestWriteBehind(t *testing.T) {
+	newTestServer()
+	ctx := context.Background()
+	repo := &flakyRepo{MemoryStore: NewMemoryStore(setTestCatalog())}
+
+	wb := newTestWriteBehind(repo, "")
+	s := NewServer(repo, wb, nil)
+
+	s.TryStartCourse(ctx, "42", "rust")
+	s.TryStartCourse(ctx, "bad", "rust")
+	s.AddUserInteraction(ctx, "42", "onboarding", "done")
+
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf(`Couldn't flush deferred writes: %v`, err)
+	}
+
+	if status, _ := repo.GetCourseProgress(ctx, "42", "rust"); status != "in_progress" {
+		t.Fatalf(`Wrong status of course. Plan: %v Fact: %v`, "in_progress", status)
+	}
+
+	if repo.interactions["42"]["onboarding"] != "done" {
+		t.Fatalf(`Interaction is not saved: %v`, repo.interactions)
+	}
+
+	// Bad write doesn't hold back others and waits for retry
+	if n := wb.RetryQueueLen(); n != 1 {
+		t.Fatalf(`Wrong length of retry queue. Plan: %v Fact: %v`, 1, n)
+	}
+}
+
+func TestWriteBehindRetryQueue(t *testing.T) {
+	newTestServer()
+	ctx := context.Background()
+	repo := &flakyRepo{MemoryStore: NewMemoryStore(setTestCatalog()), down: true}
+	path := filepath.Join(t.TempDir(), "deferred_writes.json")
+
+	wb := newTestWriteBehind(repo, path)
+	wb.Enqueue(DeferredWrite{Kind: DeferredStartCourse, UserId: "42", Key: "rust"})
+
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf(`Couldn't flush deferred writes: %v`, err)
+	}
+
+	if status, _ := repo.GetCourseProgress(ctx, "42", "rust"); status != "" {
+		t.Fatalf(`Course is started while DB is down: %v`, status)
+	}
+
+	// Writes survive restart
+	repo.setDown(false)
+	wb = newTestWriteBehind(repo, path)
+	if n := wb.RetryQueueLen(); n != 1 {
+		t.Fatalf(`Wrong length of loaded retry queue. Plan: %v Fact: %v`, 1, n)
+	}
+
+	for i := 0; i < 100 && wb.RetryQueueLen() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf(`Couldn't flush deferred writes: %v`, err)
+	}
+
+	if status, _ := repo.GetCourseProgress(ctx, "42", "rust"); status != "in_progress" {
+		t.Fatalf(`Wrong status of course after retry. Plan: %v Fact: %v`, "in_progress", status)
+	}
+
+	loaded, err := loadRetryQueue(path)
+	if err != nil || len(loaded) != 0 {
+		t.Fatalf(`Retry queue is not cleared on disk: %v %v`, loaded, err)
+	}
+}
+
+func TestWriteBehindEnqueueAfterClose(t *testing.T) {
+	newTestServer()
+	ctx := context.Background()
+	repo := &flakyRepo{MemoryStore: NewMemoryStore(setTestCatalog())}
+	path := filepath.Join(t.TempDir(), "deferred_writes.json")
+
+	wb := newTestWriteBehind(repo, path)
+
+	// Writes racing with Close are either applied or kept in retry queue
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wb.Enqueue(DeferredWrite{Kind: DeferredUserInteraction, UserId: "42", Key: strconv.Itoa(i), Value: "done"})
+		}(i)
+	}
+
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf(`Couldn't flush deferred writes: %v`, err)
+	}
+	wg.Wait()
+
+	wb.Enqueue(DeferredWrite{Kind: DeferredUserInteraction, UserId: "42", Key: "late", Value: "done"})
+
+	if applied, n := len(repo.interactions["42"]), wb.RetryQueueLen(); applied+n != 101 {
+		t.Fatalf(`Writes are lost. Plan: %v Fact: %v applied, %v in retry queue`, 101, applied, n)
+	}
+
+	loaded, err := loadRetryQueue(path)
+	if err != nil || len(loaded) != wb.RetryQueueLen() {
+		t.Fatalf(`Late writes are not saved to retry file: %v %v`, loaded, err)
+	}
+}
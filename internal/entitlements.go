@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Paid courses are available only for users with entitlement.
+// Trial chapters of paid course are declared in tags.json and are available for everyone:
+// "trial_chapters": ["cpp_chapter_0010", "cpp_chapter_0020"]
+
+// Status of response to user without entitlement
+const AccessDeniedStatus = "not_entitled"
+
+type AccessDenied struct {
+	Error    string `json:"error"`
+	Status   string `json:"status"`
+	CourseId string `json:"course_id"`
+	ItemId   string `json:"item_id,omitempty"`
+}
+
+type OptionsEntitlement struct {
+	UserId   int        `json:"user_id"`
+	CourseId string     `json:"course_id"`
+	DtExpire *time.Time `json:"dt_expire,omitempty"`
+	Source   string     `json:"source,omitempty"`
+}
+
+func ParseTrialChapters(tags string) (map[string]bool, error) {
+	var courseTags struct {
+		TrialChapters []string `json:"trial_chapters"`
+	}
+
+	trialChapters := make(map[string]bool)
+
+	if len(tags) == 0 {
+		return trialChapters, nil
+	}
+
+	err := json.Unmarshal([]byte(tags), &courseTags)
+	if err != nil {
+		return trialChapters, err
+	}
+
+	for _, chapterId := range courseTags.TrialChapters {
+		trialChapters[chapterId] = true
+	}
+
+	return trialChapters, nil
+}
+
+func NewAccessDenied(courseId string, itemId string) AccessDenied {
+	return AccessDenied{
+		Error:    "Not entitled to paid course",
+		Status:   AccessDeniedStatus,
+		CourseId: courseId,
+		ItemId:   itemId,
+	}
+}
+
+func GetCourseTypeAndTags(courseId string) (string, string, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return "", "", err
+	}
+
+	return course.CourseType, course.Tags, nil
+}
+
+// GetAccessDenied checks if user may access chapter, task or practice project (itemId) of the course.
+// Returns nil if access is allowed.
+func (s *Server) GetAccessDenied(ctx context.Context, userId string, courseId string, itemId string) (*AccessDenied, error) {
+	courseType, tags, err := GetCourseTypeAndTags(courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	if courseType != "paid" {
+		return nil, nil
+	}
+
+	trialChapters, err := ParseTrialChapters(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if trialChapters[itemId] {
+		return nil, nil
+	}
+
+	denied := NewAccessDenied(courseId, itemId)
+
+	// Anonymous user
+	if len(userId) == 0 {
+		return &denied, nil
+	}
+
+	isEntitled, err := s.store.HasEntitlement(ctx, userId, courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	if isEntitled {
+		return nil, nil
+	}
+
+	return &denied, nil
+}
+
+func parseOptionsEntitlement(r *http.Request) (OptionsEntitlement, error) {
+	var opts OptionsEntitlement
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil {
+		return OptionsEntitlement{}, err
+	}
+
+	if opts.UserId == 0 || len(opts.CourseId) == 0 {
+		return opts, fmt.Errorf("user_id and course_id are required")
+	}
+
+	return opts, nil
+}
+
+func (s *Server) HandleGrantAccess(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := parseOptionsEntitlement(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/grant_access: couldn't parse request")
+		return
+	}
+
+	status := 0
+
+	err = s.store.GrantEntitlement(r.Context(), opts)
+	if err != nil {
+		status = -1
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+			"db_error":  err.Error(),
+		}).Error("/grant_access: couldn't grant access to course")
+	} else {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+			"dt_expire": opts.DtExpire,
+			"source":    opts.Source,
+		}).Info("/grant_access: completed")
+	}
+
+	body, _ := json.Marshal(map[string]int{
+		"status": status,
+	})
+	w.Write(body)
+}
+
+func (s *Server) HandleRevokeAccess(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := parseOptionsEntitlement(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/revoke_access: couldn't parse request")
+		return
+	}
+
+	status := 0
+
+	err = s.store.RevokeEntitlement(r.Context(), opts)
+	if err != nil {
+		status = -1
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+			"db_error":  err.Error(),
+		}).Error("/revoke_access: couldn't revoke access to course")
+	} else {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.UserId,
+			"course_id": opts.CourseId,
+		}).Info("/revoke_access: completed")
+	}
+
+	body, _ := json.Marshal(map[string]int{
+		"status": status,
+	})
+	w.Write(body)
+}
+
+// checkAccess writes "not entitled" error to response if user has no access to paid course.
+// Returns true if request may be handled further.
+func (s *Server) checkAccess(ctx context.Context, w http.ResponseWriter, api string, userId string, courseId string, itemId string) bool {
+	denied, err := s.GetAccessDenied(ctx, userId, courseId, itemId)
+	if err != nil && err != sql.ErrNoRows {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"item_id":   itemId,
+			"error":     err.Error(),
+		}).Error(api + ": couldn't check access to course")
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't check access to course",
+		})
+		return false
+	}
+
+	if denied != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"item_id":   itemId,
+		}).Info(api + ": user is not entitled to paid course")
+
+		json.NewEncoder(w).Encode(denied)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseTrialChapters(t *testing.T) {
+	tags := `{"title": "C++", "type": "paid", "trial_chapters": ["cpp_chapter_0010", "cpp_chapter_0020"]}`
+
+	trialChapters, err := ParseTrialChapters(tags)
+	if err != nil {
+		t.Fatalf(`Couldn't parse trial chapters: %v`, err)
+	}
+
+	if !trialChapters["cpp_chapter_0020"] || trialChapters["cpp_chapter_0030"] {
+		t.Fatalf(`Wrong trial chapters: %v`, trialChapters)
+	}
+}
+
+// newPaidTestServer returns test server with free course "rust" and paid course "cpp"
+func newPaidTestServer() (*Server, *MemoryStore) {
+	s, store := newTestServer()
+
+	var course CatalogCourse
+	course.CourseId = "cpp"
+	course.Title = "C++"
+	course.CourseType = "paid"
+	course.Tags = `{"title": "C++", "type": "paid", "trial_chapters": ["cpp_chapter_0010"]}`
+	GetCatalog().AddCourse(course)
+
+	return s, store
+}
+
+func TestGetAccessDenied(t *testing.T) {
+	s, store := newPaidTestServer()
+	ctx := context.Background()
+
+	expired := time.Now().Add(-time.Hour)
+	store.GrantEntitlement(ctx, OptionsEntitlement{UserId: 1, CourseId: "cpp"})
+	store.GrantEntitlement(ctx, OptionsEntitlement{UserId: 3, CourseId: "cpp", DtExpire: &expired})
+
+	tests := []struct {
+		userId   string
+		courseId string
+		itemId   string
+		denied   bool
+	}{
+		// Free course
+		{"2", "rust", "rust_chapter_0011", false},
+		{"", "rust", "rust_chapter_0011", false},
+		// Paid course, entitled
+		{"1", "cpp", "cpp_chapter_0020", false},
+		// Paid course, not entitled
+		{"2", "cpp", "cpp_chapter_0020", true},
+		{"", "cpp", "cpp_chapter_0020", true},
+		{"3", "cpp", "cpp_chapter_0020", true},
+		// Trial chapter of paid course
+		{"2", "cpp", "cpp_chapter_0010", false},
+		{"", "cpp", "cpp_chapter_0010", false},
+	}
+
+	for _, test := range tests {
+		denied, err := s.GetAccessDenied(ctx, test.userId, test.courseId, test.itemId)
+		if err != nil {
+			t.Fatalf(`Couldn't check access: %v`, err)
+		}
+
+		if (denied != nil) != test.denied {
+			t.Fatalf(`Wrong access of user %v to %v. Plan: %v Fact: %v`, test.userId, test.itemId, test.denied, denied)
+		}
+
+		if denied != nil && (denied.Status != "not_entitled" || denied.CourseId != test.courseId || denied.ItemId != test.itemId) {
+			t.Fatalf(`Wrong denial. Plan: %v Fact: %v`, NewAccessDenied(test.courseId, test.itemId), *denied)
+		}
+	}
+
+	if _, err := s.GetAccessDenied(ctx, "1", "unknown", "unknown_chapter_0010"); err == nil {
+		t.Fatalf(`Wrong access to unknown course. Plan: %v Fact: %v`, "error", err)
+	}
+}
+
+func TestHandleGrantRevokeAccess(t *testing.T) {
+	s, _ := newPaidTestServer()
+	ctx := context.Background()
+
+	tests := []struct {
+		handler http.HandlerFunc
+		url     string
+		body    string
+		status  float64
+		denied  bool
+	}{
+		{s.HandleGrantAccess, "/grant_access", `{"user_id": 5, "course_id": "cpp", "source": "stripe"}`, 0, false},
+		{s.HandleRevokeAccess, "/revoke_access", `{"user_id": 5, "course_id": "cpp"}`, 0, true},
+		{s.HandleGrantAccess, "/grant_access", `{"user_id": 5, "course_id": "cpp", "dt_expire": "2000-01-01T00:00:00Z"}`, 0, true},
+		{s.HandleGrantAccess, "/grant_access", `{"user_id": 5, "course_id": "cpp", "dt_expire": "2100-01-01T00:00:00Z"}`, 0, false},
+	}
+
+	for _, test := range tests {
+		var response map[string]interface{}
+		if err := callHandler(test.handler, test.url, test.body, &response); err != nil {
+			t.Fatalf(`Couldn't call handler: %v`, err)
+		}
+
+		if response["status"] != test.status {
+			t.Fatalf(`Wrong status of %v %v. Plan: %v Fact: %v`, test.url, test.body, test.status, response)
+		}
+
+		denied, err := s.GetAccessDenied(ctx, "5", "cpp", "cpp_chapter_0020")
+		if err != nil || (denied != nil) != test.denied {
+			t.Fatalf(`Wrong access after %v %v. Plan: %v Fact: %v %v`, test.url, test.body, test.denied, denied, err)
+		}
+	}
+
+	// Invalid requests
+	for _, body := range []string{`{"course_id": "cpp"}`, `{"user_id": 5}`, `{`} {
+		var response map[string]interface{}
+		if err := callHandler(s.HandleGrantAccess, "/grant_access", body, &response); err != nil {
+			t.Fatalf(`Couldn't call handler: %v`, err)
+		}
+
+		if response["error"] == nil {
+			t.Fatalf(`Wrong response to invalid request %v. Plan: %v Fact: %v`, body, "error", response)
+		}
+	}
+}
@@ -0,0 +1,1778 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// --------------- METRICS
+
+// /run_task
+var countRunTaskTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_task_total",
+})
+
+var countRunTaskOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_task_ok",
+})
+
+var countRunTaskErrClient = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_task_errors_client",
+})
+
+var countRunTaskErrServer = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_task_errors_server",
+})
+
+// /run_practice
+var countRunPracticeTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_practice_total",
+})
+
+var countRunPracticeOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_practice_ok",
+})
+
+var countRunPracticeErrClient = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_practice_errors_client",
+})
+
+var countRunPracticeErrServer = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_practice_errors_server",
+})
+
+// /run_code
+var countRunCodeTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_code_total",
+})
+
+var countRunCodeOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_code_ok",
+})
+
+var countRunCodeErrClient = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_code_errors_client",
+})
+
+var countRunCodeErrServer = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_run_code_errors_server",
+})
+
+// /get_courses
+var countGetCoursesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_courses_total",
+})
+
+var countGetCoursesAnonym = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_courses_anonym",
+})
+
+var countGetCoursesAuthorized = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_courses_authorized",
+})
+
+var countGetCoursesErrClient = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_courses_err_client",
+})
+
+var countGetCoursesErrServer = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_courses_err_server",
+})
+
+// update_course_progress
+var countUpdateCourseProgressTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_total",
+})
+
+var countUpdateCourseProgressServerError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_err_server",
+})
+
+var countUpdateCourseProgressStatusError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_err_status",
+})
+
+var countUpdateCourseProgressClientError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_err_client",
+})
+
+var countUpdateCourseProgressOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_ok",
+})
+
+var countUpdateCourseProgressOkCompleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_ok_completed",
+})
+
+var countUpdateCourseProgressNoAction = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_course_progress_no_action",
+})
+
+// /update_chapter_progress
+var countUpdateChapterProgressTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_total",
+})
+
+var countUpdateChapterProgressNoAction = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_no_action",
+})
+
+var countUpdateChapterProgressServerError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_err_server",
+})
+
+var countUpdateChapterProgressStatusError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_err_status",
+})
+
+var countUpdateChapterProgressClientError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_err_client",
+})
+
+var countUpdateChapterProgressOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_ok",
+})
+
+var countUpdateChapterProgressOkCompleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_update_chapter_progress_ok_completed",
+})
+
+// /get_chapter
+var countGetChapterTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_chapter_total",
+})
+
+// /get_chapter
+var countGetChapterAnonymous = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_chapter_anonymous",
+})
+
+var countGetChapterServerError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_chapter_err_server",
+})
+
+var countGetChapterClientError = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_chapter_err_client",
+})
+
+var countGetChapterOk = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "handyman_get_chapter_ok",
+})
+
+func (s *Server) HandleGetCourses(w http.ResponseWriter, r *http.Request) {
+	countGetCoursesTotal.Inc()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+
+	if err != nil {
+		countGetCoursesErrClient.Inc()
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"status":  opts.Status,
+			"error":   err.Error(),
+		}).Warning("/get_courses: couldn't parse request")
+		return
+	}
+
+	var courses []CourseForUser
+
+	// Case when user is not authorized
+	if len(opts.userId) == 0 {
+		countGetCoursesAnonym.Inc()
+		courses = GetCourses()
+	} else {
+		countGetCoursesAuthorized.Inc()
+		if opts.Status == "all" {
+			courses = s.GetCoursesForUser(r.Context(), opts.userId)
+		} else {
+			courses = s.GetCoursesForUserByStatus(r.Context(), opts.userId, opts.Status)
+		}
+	}
+
+	courses = LocalizeCourses(courses, opts.locale)
+
+	if len(courses) == 0 {
+		countGetCoursesErrServer.Inc()
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":               opts.userId,
+		"status":                opts.Status,
+		"retrieved_courses_len": len(courses),
+		"locale":                opts.locale,
+	}).Info("/get_courses: completed")
+
+	json.NewEncoder(w).Encode(courses)
+}
+
+func (s *Server) HandleUpdateCourseProgress(w http.ResponseWriter, r *http.Request) {
+	countUpdateCourseProgressTotal.Inc()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		countUpdateCourseProgressClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"status":    opts.Status,
+			"error":     err.Error(),
+		}).Warning("/update_course_progress: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.Status) == 0 || len(opts.CourseId) == 0 {
+		countUpdateCourseProgressClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Required fields are not set in request",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"status":    opts.Status,
+			"error":     err.Error(),
+		}).Warning("/update_course_progress: required fields not set in request")
+		return
+	}
+
+	curStatus, err := s.GetCourseProgressForUser(r.Context(), opts.CourseId, opts.userId)
+	if err != nil {
+		countUpdateCourseProgressServerError.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user progress on course",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"status":    opts.Status,
+			"error":     err.Error(),
+		}).Warning("/update_course_progress: couldn't get progress on course for user")
+		return
+	}
+
+	if !IsNewStatusValid(curStatus, opts.Status) {
+		if opts.Status == "in_progress" && (curStatus == "in_progress" || curStatus == "completed") {
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "no_action",
+			})
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":        opts.userId,
+				"course_id":      opts.CourseId,
+				"current_status": curStatus,
+				"new_status":     opts.Status,
+			}).Info("/update_course_progress: no action")
+
+			countUpdateCourseProgressNoAction.Inc()
+			return
+
+		}
+		countUpdateCourseProgressStatusError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":          "Couldn't change status",
+			"current_status": curStatus,
+			"new_status":     opts.Status,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":        opts.userId,
+			"course_id":      opts.CourseId,
+			"current_status": curStatus,
+			"new_status":     opts.Status,
+		}).Info("/update_course_progress: invalid course status transmission for user")
+		return
+	}
+
+	if opts.Status == "completed" {
+		isCourseCompleted, _ := s.AreAllChaptersInCourseCompleted(r.Context(), opts.userId, opts.CourseId)
+
+		if isCourseCompleted {
+			countUpdateCourseProgressOkCompleted.Inc()
+		} else {
+			setErrorClass(w, errorClassClient)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Not all materials in course are completed",
+			})
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":        opts.userId,
+				"course_id":      opts.CourseId,
+				"current_status": curStatus,
+				"new_status":     opts.Status,
+			}).Info("/update_course_progress: not all materials in course are completed")
+			return
+		}
+	}
+
+	err = s.UpdateCourseProgressForUser(r.Context(), opts.CourseId, opts.Status, opts.userId)
+	if err != nil {
+		countUpdateCourseProgressServerError.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't update user progress on course",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":        opts.userId,
+			"course_id":      opts.CourseId,
+			"current_status": curStatus,
+			"new_status":     opts.Status,
+			"error":          err.Error(),
+		}).Error("/update_course_progress: couldn't update user progress on course")
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":    opts.userId,
+		"course_id":  opts.CourseId,
+		"old_status": curStatus,
+		"new_status": opts.Status,
+	}).Info("/update_course_progress: completed")
+
+	countUpdateCourseProgressOk.Inc()
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+func (s *Server) HandleUpdateChapterProgress(w http.ResponseWriter, r *http.Request) {
+	countUpdateChapterProgressTotal.Inc()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		countUpdateChapterProgressClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"status":     opts.Status,
+			"error":      err.Error(),
+		}).Warning("/update_chapter_progress: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.ChapterId) == 0 || len(opts.Status) == 0 {
+		countUpdateChapterProgressClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user_id, chapter_id or status",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"status":     opts.Status,
+		}).Warning("/update_chapter_progress: required fields not set in request")
+		return
+	}
+
+	if opts.Status != "blocked" {
+		lock, err := s.GetMaterialLock(r.Context(), opts.userId, opts.CourseId, opts.ChapterId)
+		if err != nil {
+			countUpdateChapterProgressServerError.Inc()
+
+			setErrorClass(w, errorClassServer)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Couldn't check unlock rules",
+			})
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"chapter_id": opts.ChapterId,
+				"error":      err.Error(),
+			}).Error("/update_chapter_progress: couldn't check unlock rules")
+			return
+		}
+
+		if lock != nil {
+			countUpdateChapterProgressStatusError.Inc()
+
+			json.NewEncoder(w).Encode(lock)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":      opts.userId,
+				"chapter_id":   opts.ChapterId,
+				"new_status":   opts.Status,
+				"required_ids": lock.RequiredIds,
+			}).Info("/update_chapter_progress: chapter is locked for user")
+			return
+		}
+	}
+
+	curStatus, err := s.store.GetChapterProgress(r.Context(), opts.userId, opts.ChapterId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			countUpdateChapterProgressServerError.Inc()
+
+			setErrorClass(w, errorClassServer)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Couldn't get user progress on chapter",
+			})
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"chapter_id": opts.ChapterId,
+				"error":      err.Error(),
+			}).Warning("/update_chapter_progress: couldn't get user progress on chapter")
+			return
+		}
+
+		curStatus = "not_started"
+	}
+
+	if !IsNewStatusValid(curStatus, opts.Status) {
+		if opts.Status == "in_progress" && (curStatus == "in_progress" || curStatus == "completed") {
+			chapters, err := s.GetChaptersForUserWithRules(r.Context(), opts.userId, opts.CourseId)
+			if err != nil {
+				countUpdateChapterProgressServerError.Inc()
+
+				setErrorClass(w, errorClassServer)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Couldn't get chapters for user",
+				})
+
+				Logger.WithContext(r.Context()).WithFields(log.Fields{
+					"user_id":   opts.userId,
+					"course_id": opts.CourseId,
+					"error":     err.Error(),
+				}).Error("/update_chapter_progress: couldn't get chapters for user")
+				return
+			}
+
+			ret_chapter_id := opts.ChapterId
+
+			for i := 0; i < len(chapters); i++ {
+				if chapters[i].Status == "in_progress" || chapters[i].Status == "not_started" {
+					ret_chapter_id = chapters[i].ChapterId
+					break
+				}
+			}
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":           opts.userId,
+				"chapter_id":        opts.ChapterId,
+				"status":            opts.Status,
+				"action":            "not_changed",
+				"return_chapter_id": ret_chapter_id,
+			}).Info("/update_chapter_progress: no action")
+
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":     "no_action",
+				"chapter_id": ret_chapter_id,
+				"course_id":  opts.CourseId,
+			})
+
+			countUpdateChapterProgressNoAction.Inc()
+			return
+		}
+
+		countUpdateChapterProgressStatusError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":          "Couldn't change status",
+			"current_status": curStatus,
+			"new_status":     opts.Status,
+			"chapter_id":     opts.ChapterId,
+			"course_id":      opts.CourseId,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":        opts.userId,
+			"chapter_id":     opts.ChapterId,
+			"current_status": curStatus,
+			"new_status":     opts.Status,
+		}).Info("/update_chapter_progress: invalid chapter status transmission for user")
+		return
+	}
+
+	if opts.Status == "completed" {
+		tasks := s.GetTasks(r.Context(), opts.ChapterId, opts.userId)
+
+		for _, task := range tasks {
+			if task.Status != "completed" {
+				setErrorClass(w, errorClassClient)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Not all tasks in chapter are completed",
+				})
+
+				Logger.WithContext(r.Context()).WithFields(log.Fields{
+					"user_id":    opts.userId,
+					"chapter_id": opts.ChapterId,
+					"new_status": opts.Status,
+					"task_id":    task.TaskId,
+					"status":     task.Status,
+				}).Info("/update_chapter_progress: do nothing because not all tasks are completed")
+				return
+			}
+		}
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":    opts.userId,
+		"new_status": opts.Status,
+		"chapter_id": opts.ChapterId,
+	}).Info("/update_chapter_progress: BEFORE UPDATING IN DB")
+
+	err = s.store.UpdateChapterProgress(r.Context(), opts.userId, opts.ChapterId, opts.Status)
+
+	if err != nil {
+		countUpdateChapterProgressServerError.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":      "Couldn't update chapter status for user",
+			"chapter_id": opts.ChapterId,
+			"course_id":  opts.CourseId,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"old_status": curStatus,
+			"new_status": opts.Status,
+			"error":      err.Error(),
+		}).Error("/update_chapter_progress: couldn't update chapter status for user")
+		return
+	}
+
+	s.TryStartCourse(r.Context(), opts.userId, opts.CourseId)
+
+	if opts.Status == "completed" {
+		countUpdateChapterProgressOkCompleted.Inc()
+	}
+
+	countUpdateChapterProgressOk.Inc()
+	s.activity.Record(opts.userId, opts.ChapterId, ActionChangeStatus)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":    opts.userId,
+		"old_status": curStatus,
+		"new_status": opts.Status,
+		"chapter_id": opts.ChapterId,
+	}).Info("/update_chapter_progress: completed")
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     "ok",
+		"chapter_id": opts.ChapterId,
+		"course_id":  opts.CourseId,
+	})
+}
+
+func (s *Server) HandleGetCourseDescription(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_course_description: couldn't parse request")
+		return
+	}
+
+	if len(opts.CourseId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get course_id in get_course_description",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+		}).Warning("/get_course_description: required fields not set in request")
+		return
+	}
+	path, err := GetCoursePathOnDisk(opts.CourseId)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_course_description: couldn't get path to description")
+		return
+	}
+
+	descr, _ := ReadLocalizedText(filepath.Join(path, "description.md"), opts.locale)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":   opts.userId,
+		"course_id": opts.CourseId,
+		"locale":    opts.locale,
+	}).Info("/get_course_description: completed")
+
+	body, _ := json.Marshal(map[string]string{
+		"description": descr,
+	})
+	w.Write(body)
+}
+
+func (s *Server) HandleGetChapters(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_chapters: couldn't parse request")
+		return
+	}
+
+	if len(opts.CourseId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get course_id in get_chapters",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+		}).Warning("/get_chapters: required fields not set in request")
+		return
+	}
+
+	var chapters []ChapterForUser
+
+	// Not authorized user has no progress: materials with prerequisites are blocked as in /get_chapter
+	if len(opts.userId) == 0 {
+		chapters = GetChapters(opts.CourseId)
+		err = ApplyUnlockRules(opts.CourseId, chapters)
+	} else {
+		chapters, err = s.GetChaptersForUserWithRules(r.Context(), opts.userId, opts.CourseId)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get chapters",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Error("/get_chapters: couldn't get chapters with unlock rules")
+		return
+	}
+
+	tree := BuildChaptersTree(LocalizeChapters(chapters, opts.locale))
+
+	if len(opts.userId) == 0 {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+		}).Info("/get_chapters: completed for not authorized user")
+
+		json.NewEncoder(w).Encode(tree)
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":   opts.userId,
+		"course_id": opts.CourseId,
+		"locale":    opts.locale,
+	}).Info("/get_chapters: completed")
+
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (s *Server) HandleGetCourseInfo(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_course_info: couldn't parse request")
+		return
+	}
+
+	if len(opts.CourseId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get required request params",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+		}).Warning("/get_course_info: required fields not set in request")
+		return
+	}
+
+	tags_str, err := GetCourseInfo(opts.CourseId)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Couldn't get course info",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_course_info: couldn't get course info")
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"tags": tags_str,
+	})
+	w.Write(body)
+}
+
+func (s *Server) HandlePracticeCode(w http.ResponseWriter, r *http.Request) {
+	countRunPracticeTotal.Inc()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	var opts PracticeReq
+	opts.userId = GetUserId(r)
+
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err == nil {
+		err = opts.validateProgramInput()
+	}
+
+	if err != nil {
+		countRunPracticeErrClient.Inc()
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"project_id": opts.ProjectId,
+			"course_id":  opts.CourseId,
+			"action":     opts.Action,
+			"error":      err.Error(),
+		}).Warning("/handle_practice_code: couldn't parse request")
+
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":    opts.userId,
+		"project_id": opts.ProjectId,
+		"course_id":  opts.CourseId,
+		"action":     opts.Action,
+	}).Warning("/handle_practice_code: parsed request")
+
+	if len(opts.userId) == 0 || len(opts.ProjectId) == 0 || len(opts.CourseId) == 0 {
+		countRunPracticeErrClient.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get some fields",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"project_id": opts.ProjectId,
+			"course_id":  opts.CourseId,
+			"action":     opts.Action,
+		}).Warning("/handle_practice_code: couldn't get required fields")
+		return
+	}
+
+	if !s.checkAccess(r.Context(), w, "/handle_practice_code", opts.userId, opts.CourseId, opts.ProjectId) {
+		countRunPracticeErrClient.Inc()
+		return
+	}
+
+	lock, err := s.GetMaterialLock(r.Context(), opts.userId, opts.CourseId, opts.ProjectId)
+	if err != nil {
+		countRunPracticeErrServer.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't check unlock rules",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"project_id": opts.ProjectId,
+			"error":      err.Error(),
+		}).Error("/handle_practice_code: couldn't check unlock rules")
+		return
+	}
+
+	if lock != nil {
+		countRunPracticeErrClient.Inc()
+
+		json.NewEncoder(w).Encode(lock)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":      opts.userId,
+			"project_id":   opts.ProjectId,
+			"required_ids": lock.RequiredIds,
+		}).Info("/handle_practice_code: project is locked for user")
+		return
+	}
+
+	res := new(RunTaskResult)
+
+	if opts.Action == "save" {
+		if s.SavePractice(r.Context(), opts.userId, opts.ProjectId, opts.CourseId, opts.ProjectContents) {
+			countRunPracticeOk.Inc()
+		} else {
+			countRunPracticeErrServer.Inc()
+			setErrorClass(w, errorClassServer)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Couldn't save project",
+			})
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"project_id": opts.ProjectId,
+				"course_id":  opts.CourseId,
+				"action":     opts.Action,
+			}).Warning("/handle_practice_code: couldn't save project")
+			return
+		}
+	} else {
+		containerType := GetContainerType(opts.CourseId)
+		container, ok := GetCatalog().Containers.Get(containerType)
+		if !ok {
+			countRunPracticeErrClient.Inc()
+			setErrorClass(w, errorClassClient)
+			body, _ := json.Marshal(map[string]string{
+				"error": "Unknown course",
+			})
+			w.Write(body)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":        opts.userId,
+				"project_id":     opts.ProjectId,
+				"course_id":      opts.CourseId,
+				"container_type": containerType,
+			}).Warning("/handle_practice_code: unknown container type")
+			return
+		}
+
+		// Client can't choose limit of run
+		opts.TimeoutSec = container.TimeoutSec
+		bodyReq, err := json.Marshal(opts)
+
+		if err != nil {
+			countRunPracticeErrServer.Inc()
+
+			setErrorClass(w, errorClassServer)
+			body, _ := json.Marshal(map[string]string{
+				"error": "Couldn't communicate with tasks runner",
+			})
+			w.Write(body)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"project_id": opts.ProjectId,
+				"action":     opts.Action,
+				"error":      err.Error(),
+			}).Error("/handle_practice_code: error creating json for watchman")
+			return
+		}
+
+		bodyResp, err := sendRequestToWatchman(r.Context(), addrWatchmanPractice, containerType, &bodyReq)
+
+		if err != nil {
+			countRunPracticeErrServer.Inc()
+
+			setErrorClass(w, errorClassServer)
+			body, _ := json.Marshal(map[string]string{
+				"error": "Couldn't communicate with tasks runner",
+			})
+			w.Write(body)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"project_id": opts.ProjectId,
+				"action":     opts.Action,
+				"error":      err.Error(),
+			}).Error("/handle_practice_code: error communicating watchman")
+			return
+		}
+
+		err = json.Unmarshal(bodyResp, &res)
+
+		if err != nil {
+			countRunPracticeErrServer.Inc()
+
+			setErrorClass(w, errorClassServer)
+			body, _ := json.Marshal(map[string]string{
+				"error": "Couldn't communicate with tasks runner",
+			})
+			w.Write(body)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"project_id": opts.ProjectId,
+				"action":     opts.Action,
+				"error":      err.Error(),
+			}).Error("/handle_practice_code: error extracting json from watchman resp")
+			return
+		}
+
+		if s.UpdateStatusPractice(r.Context(), opts.userId, opts.ProjectId, opts.CourseId,
+			opts.Action == "test" && res.StatusCode == 0, opts.ProjectContents) {
+			countRunPracticeOk.Inc()
+		} else {
+			countRunPracticeErrServer.Inc()
+		}
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":     opts.userId,
+		"project_id":  opts.ProjectId,
+		"course_id":   opts.CourseId,
+		"action":      opts.Action,
+		"status_code": res.StatusCode,
+	}).Info("/handle_practice_code: completed")
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) HandleGetPractice(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	var opts Options
+	opts.userId = GetUserId(r)
+
+	err := json.NewDecoder(r.Body).Decode(&opts)
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"task_id":   opts.TaskId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_practice: couldn't parse request")
+		return
+	}
+
+	if len(opts.CourseId) == 0 || len(opts.TaskId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get required request params",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"task_id":   opts.TaskId,
+			"course_id": opts.CourseId,
+		}).Warning("/get_practice: required fields not set in request")
+		return
+	}
+
+	if !s.checkAccess(r.Context(), w, "/get_practice", opts.userId, opts.CourseId, opts.TaskId) {
+		return
+	}
+
+	opts.locale = GetLocale(r, opts.Lang)
+
+	var practice Practice
+
+	if len(opts.userId) > 0 {
+		practice, err = s.GetPracticeForUser(r.Context(), opts)
+	} else {
+		practice, err = GetPractice(opts)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Couldn't get practice for user",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"task_id":   opts.TaskId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Error("/get_practice: couldn't get practice for user")
+		return
+	}
+
+	practice.NextChapterId, _ = GetNextChapterId(opts.CourseId, practice.ChapterId, false)
+	practice.Title = GetCatalog().GetTitle(opts.TaskId, opts.locale, practice.Title)
+
+	pathToText := filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "text.md")
+	practice.ProjectDescription, err = ReadLocalizedText(pathToText, opts.locale)
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Couldn't get practice for user",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"task_id":   opts.TaskId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Error("/get_practice: couldn't get practice text for user")
+		return
+	}
+
+	pathToHint := filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "hint.md")
+	practice.ProjectHint, err = ReadLocalizedText(pathToHint, opts.locale)
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Couldn't get practice hint for user",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"task_id":   opts.TaskId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Error("/get_practice: couldn't get practice hint for user")
+		return
+	}
+
+	if opts.Format == "html" {
+		description, err := RenderCatalogText(opts.CourseId, opts.TaskId, pathToText, opts.locale)
+		if err == nil {
+			var hint RenderedText
+			hint, err = RenderCatalogText(opts.CourseId, opts.TaskId, pathToHint, opts.locale)
+			practice.ProjectHintHtml = hint.Html
+		}
+
+		if err != nil {
+			setErrorClass(w, errorClassServer)
+			body, _ := json.Marshal(map[string]string{
+				"error": "Couldn't render practice for user",
+			})
+			w.Write(body)
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":   opts.userId,
+				"task_id":   opts.TaskId,
+				"course_id": opts.CourseId,
+				"error":     err.Error(),
+			}).Error("/get_practice: couldn't render practice text for user")
+			return
+		}
+
+		practice.ProjectDescriptionHtml = description.Html
+		practice.Toc = description.Toc
+	}
+
+	practice.ProjectPath = filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "project")
+
+	practice.Tags, err = GetCourseInfo(opts.CourseId)
+	practice.Tags = GetCatalog().GetTags(opts.CourseId, opts.locale, practice.Tags)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Couldn't get course info",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_practice: couldn't get course info")
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":         opts.userId,
+		"course_id":       opts.CourseId,
+		"task_id":         opts.TaskId,
+		"next_chapter_id": practice.NextChapterId,
+		"locale":          opts.locale,
+	}).Info("/get_practice: completed")
+
+	json.NewEncoder(w).Encode(practice)
+
+}
+
+func (s *Server) HandleGetChapter(w http.ResponseWriter, r *http.Request) {
+	countGetChapterTotal.Inc()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		countGetChapterClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"course_id":  opts.CourseId,
+			"error":      err.Error(),
+		}).Warning("/get_chapter: couldn't parse request")
+		return
+	}
+
+	if len(opts.CourseId) == 0 && len(opts.ChapterId) == 0 {
+		countGetChapterClientError.Inc()
+
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get required request params",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"course_id":  opts.CourseId,
+		}).Warning("/get_chapter: required fields not set in request")
+		return
+	}
+
+	chapter, err := s.GetChapterForUser(r.Context(), opts)
+
+	if err != nil {
+		countGetChapterServerError.Inc()
+
+		setErrorClass(w, errorClassServer)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Couldn't get %s chapter for user %s (chapter %s): %s",
+				opts.CourseId, opts.userId, opts.ChapterId, err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"course_id":  opts.CourseId,
+			"error":      err.Error(),
+		}).Error("/get_chapter: couldn't get chapter for user")
+		return
+	}
+
+	if !s.checkAccess(r.Context(), w, "/get_chapter", opts.userId, opts.CourseId, chapter.ChapterId) {
+		countGetChapterClientError.Inc()
+		return
+	}
+
+	// Anonymous user has no progress, so locked chapters stay locked
+	lock, err := s.GetMaterialLock(r.Context(), opts.userId, opts.CourseId, chapter.ChapterId)
+	if err != nil {
+		countGetChapterServerError.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't check unlock rules",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": chapter.ChapterId,
+			"error":      err.Error(),
+		}).Error("/get_chapter: couldn't check unlock rules")
+		return
+	}
+
+	if lock != nil {
+		countGetChapterClientError.Inc()
+
+		json.NewEncoder(w).Encode(lock)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":      opts.userId,
+			"chapter_id":   chapter.ChapterId,
+			"required_ids": lock.RequiredIds,
+		}).Info("/get_chapter: chapter is locked for user")
+		return
+	}
+
+	chapter.NextChapterId, _ = GetNextChapterId(opts.CourseId, chapter.ChapterId, true)
+	if len(opts.userId) == 0 {
+		countGetChapterAnonymous.Inc()
+		chapter.CourseStatus = "not_started"
+	} else {
+		chapter.CourseStatus, _ = s.GetCourseProgressForUser(r.Context(), opts.CourseId, opts.userId)
+	}
+
+	countGetChapterOk.Inc()
+	s.activity.Record(opts.userId, chapter.ChapterId, ActionView)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":         opts.userId,
+		"course_id":       opts.CourseId,
+		"chapter_id":      opts.ChapterId,
+		"next_chapter_id": chapter.NextChapterId,
+		"locale":          opts.locale,
+	}).Info("/get_chapter: completed")
+
+	json.NewEncoder(w).Encode(chapter)
+}
+
+func (s *Server) HandleGetProgress(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"error":      err.Error(),
+		}).Warning("/get_progress: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.ChapterId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get required request params",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+		}).Warning("/get_progress: required fields not set in request")
+		return
+	}
+
+	chapterStatus, err := s.store.GetChapterProgress(r.Context(), opts.userId, opts.ChapterId)
+
+	if err != nil && err != sql.ErrNoRows {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get chapter progress",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"chapter_id": opts.ChapterId,
+			"error":      err.Error(),
+		}).Warning("/get_progress: couldn't get chapter progress (sql query)")
+		return
+	}
+
+	var userProgress UserProgress
+
+	tasks := s.GetTasks(r.Context(), opts.ChapterId, opts.userId)
+
+	for _, task := range tasks {
+		if task.Status != "completed" {
+			userProgress.NotCompletedTaskIds = append(userProgress.NotCompletedTaskIds, task.TaskId)
+		}
+	}
+
+	if len(userProgress.NotCompletedTaskIds) > 0 && chapterStatus != "completed" {
+		userProgress.StatusOnChapter = "chapter_not_completed"
+	} else {
+		userProgress.StatusOnChapter = "chapter_completed"
+	}
+
+	nextChapterId, err := GetNextChapterId(opts.CourseId, opts.ChapterId, true)
+	if err != nil && err != sql.ErrNoRows {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":    opts.userId,
+			"course_id":  opts.CourseId,
+			"chapter_id": opts.ChapterId,
+			"error":      err.Error(),
+		}).Error("/get_progress: couldn't get user progress on chapter")
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get progress",
+		})
+		return
+	}
+
+	userProgress.NextChapterId = nextChapterId
+	userProgress.CourseId = opts.CourseId
+
+	if userProgress.StatusOnChapter == "chapter_completed" {
+		userProgress.IsCourseCompleted, err = s.AreAllChaptersInCourseCompleted(r.Context(), opts.userId, opts.CourseId)
+
+		if err != nil {
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":    opts.userId,
+				"course_id":  opts.CourseId,
+				"chapter_id": opts.ChapterId,
+				"error":      err.Error(),
+			}).Error("/get_progress: couldn't check if all chapters are completed")
+
+			setErrorClass(w, errorClassClient)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Couldn't get progress",
+			})
+			return
+		}
+
+		if userProgress.IsCourseCompleted {
+			userProgress.PracticeProjects = s.GetPracticeProjects(r.Context(), opts.userId, opts.CourseId)
+		}
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":             opts.userId,
+		"chapter_id":          opts.ChapterId,
+		"course_id":           opts.CourseId,
+		"chapter_status":      userProgress.StatusOnChapter,
+		"next_chapter_id":     userProgress.NextChapterId,
+		"is_course_completed": userProgress.IsCourseCompleted,
+		"not_completed_tasks": userProgress.NotCompletedTaskIds,
+	}).Info("/get_progress: completed")
+
+	json.NewEncoder(w).Encode(userProgress)
+}
+
+func (s *Server) HandleCoursesStats(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"error":   err.Error(),
+		}).Warning("/courses_stats: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user_id",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+		}).Warning("/courses_stats: required fields not set in request")
+		return
+	}
+
+	courseStatuses := s.GetCourseStatuses(r.Context(), opts.userId)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":             opts.userId,
+		"course_statuses_len": len(courseStatuses),
+	}).Info("/courses_stats: completed")
+
+	json.NewEncoder(w).Encode(courseStatuses)
+}
+
+func (s *Server) HandleCourseStats(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"error":   err.Error(),
+		}).Warning("/course_stats: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.CourseId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user_id or course_id",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+		}).Warning("/course_stats: required fields not set in request")
+		return
+	}
+
+	courseStatus := s.GetCourseStatus(r.Context(), opts.userId, opts.CourseId)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id": opts.userId,
+	}).Info("/course_stats: completed")
+
+	json.NewEncoder(w).Encode(courseStatus)
+}
+
+func (s *Server) HandleMergeUsers(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptionsTg(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_old": opts.UserIdOld,
+			"error":       err.Error(),
+		}).Warning("/merge_users: couldn't parse request")
+		return
+	}
+
+	status := 0
+
+	err = s.store.MergeUsers(r.Context(), opts.UserIdCur, opts.UserIdOld)
+	if err != nil {
+		status = -1
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_old": opts.UserIdOld,
+			"db_error":    err.Error(),
+		}).Error("/merge_users: couldn't merge users")
+	} else {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_old": opts.UserIdOld,
+		}).Info("/merge_users: completed")
+	}
+
+	body, _ := json.Marshal(map[string]int{
+		"status": status,
+	})
+
+	w.Write(body)
+}
+
+func (s *Server) HandleSplitUsers(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptionsTg(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_new": opts.UserIdNew,
+			"error":       err.Error(),
+		}).Warning("/split_users: couldn't parse request")
+		return
+	}
+
+	status := 0
+
+	err = s.store.SplitUsers(r.Context(), opts.UserIdCur, opts.UserIdNew)
+	if err != nil {
+		status = -1
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_new": opts.UserIdNew,
+			"db_error":    err.Error(),
+		}).Error("/split_users: couldn't split users")
+	} else {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id_cur": opts.UserIdCur,
+			"user_id_new": opts.UserIdNew,
+		}).Info("/split_users: completed")
+	}
+
+	body, _ := json.Marshal(map[string]int{
+		"status": status,
+	})
+
+	w.Write(body)
+}
+
+func (s *Server) HandleGetTask(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"task_id": opts.TaskId,
+			"error":   err.Error(),
+		}).Warning("/get_task: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.TaskId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user_id or task_id",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"task_id": opts.TaskId,
+		}).Warning("/get_task: required fields not set in request")
+		return
+	}
+
+	task, err := s.GetTaskForUser(r.Context(), opts.userId, opts.TaskId)
+	if err != nil {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"task_id": opts.TaskId,
+			"error":   err.Error(),
+		}).Error("/get_task: couldn't get task details")
+
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Couldn't get task details for: %s", opts.TaskId),
+		})
+		w.Write(body)
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id": opts.userId,
+		"task_id": opts.TaskId,
+	}).Warning("/get_task: completed")
+
+	json.NewEncoder(w).Encode(task)
+}
+
+func (s *Server) HandleGetActiveChapter(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := ParseOptions(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_active_chapter: couldn't parse request")
+		return
+	}
+
+	if len(opts.userId) == 0 || len(opts.CourseId) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get user_id or course_id",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+		}).Warning("/get_active_chapter: required fields not set in request")
+		return
+	}
+
+	courses := s.GetCoursesForUserByStatus(r.Context(), opts.userId, "in_progress")
+	hasAccess := false
+	for i := 0; i < len(courses); i++ {
+		if courses[i].CourseId == opts.CourseId {
+			hasAccess = true
+			break
+		}
+	}
+
+	if !hasAccess {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": "Course is not in state 'in_progress' for user",
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+		}).Warning("/get_active_chapter: course is not in state 'in_progress' for user")
+		return
+	}
+
+	chapters, err := s.GetChaptersForUserWithRules(r.Context(), opts.userId, opts.CourseId)
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get chapters for user",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":   opts.userId,
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Error("/get_active_chapter: couldn't get chapters for user")
+		return
+	}
+
+	for i := 0; i < len(chapters); i++ {
+		if chapters[i].Status == "in_progress" || chapters[i].Status == "not_started" {
+			opts.ChapterId = chapters[i].ChapterId
+
+			var chapter ChapterContent
+			chapter.IsPractice = false
+
+			// Chapter is practice: it is not in format coursename_NNNN
+			if IsPracticeId(opts.ChapterId) {
+				chapter.ChapterId = opts.ChapterId
+				chapter.IsPractice = true
+			}
+
+			if !s.checkAccess(r.Context(), w, "/get_active_chapter", opts.userId, opts.CourseId, opts.ChapterId) {
+				return
+			}
+
+			if !chapter.IsPractice {
+				chapter, err = s.GetChapterForUser(r.Context(), opts)
+				if err != nil {
+					setErrorClass(w, errorClassServer)
+					body, _ := json.Marshal(map[string]string{
+						"error": fmt.Sprintf("Couldn't get chapter for user: %s", err),
+					})
+					w.Write(body)
+
+					Logger.WithContext(r.Context()).WithFields(log.Fields{
+						"user_id":    opts.userId,
+						"course_id":  opts.CourseId,
+						"chapter_id": opts.ChapterId,
+						"error":      err.Error(),
+					}).Error("/get_active_chapter: couldn't get chapter for user")
+					return
+				}
+			}
+
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
+				"user_id":             opts.userId,
+				"course_id":           opts.CourseId,
+				"chapter_id":          opts.ChapterId,
+				"returned_chapter_id": chapter.ChapterId,
+				"is_practice":         chapter.IsPractice,
+			}).Info("/get_active_chapter: completed")
+
+			json.NewEncoder(w).Encode(chapter)
+			return
+		}
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":   opts.userId,
+		"course_id": opts.CourseId,
+	}).Info("/get_active_chapter: completed with no active chapter for user")
+
+	body, _ := json.Marshal(map[string]string{
+		"error": "No active chapter for user",
+	})
+	w.Write(body)
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestServer() (*Server, *MemoryStore) {
+	Logger = log.New()
+	Logger.Out = io.Discard
+
+	store := NewMemoryStore(setTestCatalog())
+	return NewServer(store, nil, nil), store
+}
+
+func callHandler(handler http.HandlerFunc, url string, body string, response interface{}) error {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(body)))
+	return json.NewDecoder(w.Body).Decode(response)
+}
+
+func TestHandleGetProgress(t *testing.T) {
+	s, _ := newTestServer()
+	ctx := context.Background()
+
+	s.UpdateStatus(ctx, "42", "rust_chapter_0011_task_0010", "rust_chapter_0011", "rust", true, "fn main() {}")
+
+	var progress UserProgress
+	err := callHandler(s.HandleGetProgress, "/get_progress?user_id=42", `{"chapter_id": "rust_chapter_0011"}`, &progress)
+	if err != nil {
+		t.Fatalf(`Couldn't decode response: %v`, err)
+	}
+
+	if progress.StatusOnChapter != "chapter_not_completed" || len(progress.NotCompletedTaskIds) != 1 ||
+		progress.NotCompletedTaskIds[0] != "rust_chapter_0011_task_0020" {
+		t.Fatalf(`Wrong progress on chapter: %v`, progress)
+	}
+
+	s.UpdateStatus(ctx, "42", "rust_chapter_0011_task_0020", "rust_chapter_0011", "rust", true, "fn main() {}")
+
+	progress = UserProgress{}
+	err = callHandler(s.HandleGetProgress, "/get_progress?user_id=42", `{"chapter_id": "rust_chapter_0011"}`, &progress)
+	if err != nil {
+		t.Fatalf(`Couldn't decode response: %v`, err)
+	}
+
+	if progress.StatusOnChapter != "chapter_completed" || progress.IsCourseCompleted ||
+		progress.NextChapterId != "rust_chapter_0011_project" {
+		t.Fatalf(`Wrong progress on completed chapter: %v`, progress)
+	}
+
+	var courses []CourseForUser
+	err = callHandler(s.HandleGetCourses, "/get_courses?user_id=42", `{"status": "in_progress"}`, &courses)
+	if err != nil || len(courses) != 1 || courses[0].CourseId != "rust" {
+		t.Fatalf(`Wrong courses in progress: %v %v`, courses, err)
+	}
+}
+
+func TestHandleGetChapters(t *testing.T) {
+	s, store := newTestServer()
+	ctx := context.Background()
+
+	store.UpdateTaskStatus(ctx, "42", "rust_chapter_0011_task_0010", "completed", "", "")
+	store.UpdateChapterProgress(ctx, "42", "rust_chapter_0011", "completed")
+	store.UpdatePracticeStatus(ctx, "42", "rust_chapter_0011_project", "in_progress", "")
+
+	var chapters []ChapterForUser
+	err := callHandler(s.HandleGetChapters, "/get_chapters?user_id=42", `{"course_id": "rust"}`, &chapters)
+	if err != nil {
+		t.Fatalf(`Couldn't decode response: %v`, err)
+	}
+
+	if len(chapters) != 2 || len(chapters[0].Chapters) != 2 {
+		t.Fatalf(`Wrong chapters tree: %v`, chapters)
+	}
+
+	chapter, project := chapters[0].Chapters[0], chapters[0].Chapters[1]
+	if chapter.Status != "completed" || chapter.TasksCompleted != 1 || project.Status != "in_progress" {
+		t.Fatalf(`Wrong progress on chapters: %v %v`, chapter, project)
+	}
+}
+
+func TestHandleMergeUsers(t *testing.T) {
+	s, store := newTestServer()
+	ctx := context.Background()
+
+	store.UpdateTaskStatus(ctx, "1", "rust_chapter_0011_task_0010", "in_progress", "draft", "")
+	store.UpdateTaskStatus(ctx, "2", "rust_chapter_0011_task_0010", "completed", "solution", "")
+	store.UpdateTaskStatus(ctx, "2", "rust_chapter_0011_task_0020", "in_progress", "draft", "")
+	store.UpdateChapterProgress(ctx, "2", "rust_chapter_0011", "in_progress")
+
+	var response map[string]int
+	err := callHandler(s.HandleMergeUsers, "/merge_users", `{"cur_user_id": 1, "old_user_id": 2}`, &response)
+	if err != nil || response["status"] != 0 {
+		t.Fatalf(`Couldn't merge users: %v %v`, response, err)
+	}
+
+	tasks := s.GetTasks(ctx, "rust_chapter_0011", "1")
+	if tasks[0].Status != "completed" || tasks[0].UserCode != "solution" || tasks[1].Status != "in_progress" {
+		t.Fatalf(`Wrong merged tasks: %v`, tasks)
+	}
+
+	if status, err := store.GetChapterProgress(ctx, "1", "rust_chapter_0011"); err != nil || status != "in_progress" {
+		t.Fatalf(`Wrong merged chapter: %v %v`, status, err)
+	}
+
+	if tasks := s.GetTasks(ctx, "rust_chapter_0011", "2"); tasks[0].Status != "not_started" {
+		t.Fatalf(`Progress of old user is not deleted: %v`, tasks)
+	}
+}
+
+func TestHandleCoursesStats(t *testing.T) {
+	s, store := newTestServer()
+	ctx := context.Background()
+
+	store.TryStartCourse(ctx, "42", "rust")
+	store.UpdateTaskStatus(ctx, "42", "rust_chapter_0011_task_0010", "completed", "", "")
+	store.UpdateChapterProgress(ctx, "42", "rust_chapter_0010", "completed")
+	store.UpdatePracticeStatus(ctx, "42", "rust_chapter_0011_project", "completed", "")
+
+	var stats []CourseStatus
+	err := callHandler(s.HandleCoursesStats, "/courses_stats?user_id=42", `{}`, &stats)
+	if err != nil || len(stats) != 1 {
+		t.Fatalf(`Wrong courses stats: %v %v`, stats, err)
+	}
+
+	plan := CourseStatus{
+		CourseId: "rust", Title: "Rust", Status: "in_progress",
+		TotalChapters: 3, FinishedChapters: 1,
+		TotalTasks: 2, FinishedTasks: 1,
+		TotalProjects: 1, FinishedProjects: 1,
+	}
+	fact := stats[0]
+	if fact.DtLastActivity == nil {
+		t.Fatalf(`Last activity on course is not set`)
+	}
+
+	fact.DtLastActivity = nil
+	if fact != plan {
+		t.Fatalf(`Wrong course stats. Plan: %v Fact: %v`, plan, fact)
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of all handlers labelled by route. Handlers mostly reply 200 with error in json,
+// so they set class of error explicitly: setErrorClass(w, errorClassClient).
+// Hand-written counters of handlers (handyman_run_task_total, ...) are kept as is.
+
+const (
+	errorClassNone = "none"
+	// Invalid request: bad params, wrong status transition, ...
+	errorClassClient = "client"
+	// Failure of handyman, DB or watchman
+	errorClassServer = "server"
+	// Access is denied: course is not paid or chapter is locked
+	errorClassDenied = "denied"
+	// Error in response without class
+	errorClassUnknown = "unknown"
+)
+
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "handyman_http_request_duration_seconds",
+	Help:    "Duration of HTTP requests labelled by route",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"route"})
+
+var httpRequestsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "handyman_http_requests_in_flight",
+	Help: "HTTP requests in progress labelled by route",
+}, []string{"route"})
+
+var httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "handyman_http_requests_total",
+	Help: "HTTP requests labelled by route and class of error: none, client, server, denied, unknown",
+}, []string{"route", "error_class"})
+
+var watchmanRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "handyman_watchman_request_duration_seconds",
+	Help:    "Duration of requests to watchman labelled by container type and endpoint",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+}, []string{"container_type", "endpoint"})
+
+// observeWatchman records duration of request to watchman: defer observeWatchman(api, "python")()
+func observeWatchman(api string, containerType string) func() {
+	start := time.Now()
+	return func() {
+		watchmanRequestDuration.WithLabelValues(containerType, path.Base(api)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Responses of this size or less are checked for error in json
+const maxErrorResponseSize = 4096
+
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status     int
+	errorClass string
+	written    bool
+}
+
+func (w *metricsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsResponseWriter) Write(body []byte) (int, error) {
+	if !w.written && len(w.errorClass) == 0 {
+		w.errorClass = getResponseErrorClass(body)
+	}
+
+	w.written = true
+	return w.ResponseWriter.Write(body)
+}
+
+// getResponseErrorClass looks for error in the first chunk of response
+func getResponseErrorClass(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) > maxErrorResponseSize || !bytes.HasPrefix(body, []byte("{")) {
+		return ""
+	}
+
+	var response map[string]interface{}
+	if json.Unmarshal(body, &response) != nil {
+		return ""
+	}
+
+	if status, ok := response["status"].(string); ok && (status == AccessDeniedStatus || status == MaterialLockStatus) {
+		return errorClassDenied
+	}
+
+	if _, ok := response["error"]; ok {
+		return errorClassUnknown
+	}
+
+	return ""
+}
+
+// setErrorClass marks response of handler as failed. The first class set wins.
+func setErrorClass(w http.ResponseWriter, class string) {
+	if mw, ok := w.(*metricsResponseWriter); ok && len(mw.errorClass) == 0 {
+		mw.errorClass = class
+	}
+}
+
+func (w *metricsResponseWriter) getErrorClass() string {
+	if len(w.errorClass) > 0 {
+		return w.errorClass
+	}
+
+	if w.status >= http.StatusInternalServerError {
+		return errorClassServer
+	}
+
+	if w.status >= http.StatusBadRequest {
+		return errorClassClient
+	}
+
+	return errorClassNone
+}
+
+// getRoute returns path template of route: /assets/{course_id}/{item_id}/{path:.+}
+func getRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return "unknown"
+}
+
+// MetricsMiddleware records duration, in-flight count and outcome of requests.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := getRoute(r)
+		start := time.Now()
+
+		inFlight := httpRequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			inFlight.Dec()
+			httpRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+
+			errorClass := mw.getErrorClass()
+			if p := recover(); p != nil {
+				httpRequestsTotal.WithLabelValues(route, errorClassServer).Inc()
+				setSpanErrorClass(r.Context(), errorClassServer)
+				panic(p)
+			}
+
+			setSpanErrorClass(r.Context(), errorClass)
+			httpRequestsTotal.WithLabelValues(route, errorClass).Inc()
+		}()
+
+		next.ServeHTTP(mw, r)
+	})
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/ok/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	})
+	r.HandleFunc("/client", func(w http.ResponseWriter, r *http.Request) {
+		setErrorClass(w, errorClassClient)
+		w.Write([]byte(`{"error": "Invalid request"}`))
+	})
+	r.HandleFunc("/denied", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(NewAccessDenied("cpp", "cpp_chapter_0030"))
+	})
+	r.HandleFunc("/blocked", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(NewMaterialLock("python_chapter_0050", []string{"python_chapter_0040"}))
+	})
+	r.HandleFunc("/unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": "Something went wrong"}`))
+	})
+	r.HandleFunc("/not_found", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.HandleFunc("/server", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	r.Use(MetricsMiddleware)
+
+	tests := []struct {
+		url        string
+		route      string
+		errorClass string
+	}{
+		{"/ok/1", "/ok/{id}", errorClassNone},
+		{"/ok/2", "/ok/{id}", errorClassNone},
+		{"/client", "/client", errorClassClient},
+		{"/denied", "/denied", errorClassDenied},
+		{"/blocked", "/blocked", errorClassDenied},
+		{"/unknown", "/unknown", errorClassUnknown},
+		{"/not_found", "/not_found", errorClassClient},
+		{"/server", "/server", errorClassServer},
+	}
+
+	before := map[string]float64{}
+	for _, test := range tests {
+		counter := httpRequestsTotal.WithLabelValues(test.route, test.errorClass)
+		before[test.route+test.errorClass] = testutil.ToFloat64(counter)
+	}
+
+	for _, test := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, test.url, nil))
+	}
+
+	plan := map[string]float64{}
+	for _, test := range tests {
+		plan[test.route+test.errorClass]++
+	}
+
+	for _, test := range tests {
+		key := test.route + test.errorClass
+		fact := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(test.route, test.errorClass)) - before[key]
+		if fact != plan[key] {
+			t.Fatalf("Wrong count of requests to %v with error class %v. Plan: %v Fact: %v", test.route, test.errorClass, plan[key], fact)
+		}
+
+		if inFlight := testutil.ToFloat64(httpRequestsInFlight.WithLabelValues(test.route)); inFlight != 0 {
+			t.Fatalf("Wrong count of requests in flight to %v. Plan: %v Fact: %v", test.route, 0, inFlight)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Course content may be translated to other locales. Translated texts are stored next to
+// texts of default locale: text.en.md, hint.en.md, description.en.md. Translated titles and
+// tags are stored in translations table. Locale is selected by "lang" option or Accept-Language
+// header. If translation is missing, content of default locale is returned.
+
+const DefaultLocale = "ru"
+
+type Translation struct {
+	Title string
+	Tags  string
+}
+
+// GetLocalizedPath returns path to translation of markdown file: text.md -> text.en.md
+func GetLocalizedPath(path string, locale string) string {
+	if len(locale) == 0 || locale == DefaultLocale {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + locale + ext
+}
+
+// NormalizeLocale makes locale from language tag: "en-US" -> "en"
+func NormalizeLocale(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+
+	return lang
+}
+
+// ParseAcceptLanguage returns locales from Accept-Language header ordered by preference:
+// "en-US,en;q=0.9,ru;q=0.8" -> [en, ru]
+func ParseAcceptLanguage(header string) []string {
+	type weightedLocale struct {
+		locale string
+		q      float64
+	}
+
+	weighted := []weightedLocale{}
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := NormalizeLocale(fields[0])
+		if len(locale) == 0 || locale == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if q > 0 {
+			weighted = append(weighted, weightedLocale{locale: locale, q: q})
+		}
+	}
+
+	sort.SliceStable(weighted, func(i, j int) bool {
+		return weighted[i].q > weighted[j].q
+	})
+
+	locales := []string{}
+	seen := make(map[string]bool)
+	for _, w := range weighted {
+		if !seen[w.locale] {
+			seen[w.locale] = true
+			locales = append(locales, w.locale)
+		}
+	}
+
+	return locales
+}
+
+// NegotiateLocale selects locale available in catalog: "lang" option has priority over Accept-Language.
+func (c *Catalog) NegotiateLocale(lang string, acceptLanguage string) string {
+	if locale := NormalizeLocale(lang); c.Locales[locale] {
+		return locale
+	}
+
+	for _, locale := range ParseAcceptLanguage(acceptLanguage) {
+		if c.Locales[locale] {
+			return locale
+		}
+	}
+
+	return DefaultLocale
+}
+
+func GetLocale(r *http.Request, lang string) string {
+	return GetCatalog().NegotiateLocale(lang, r.Header.Get("Accept-Language"))
+}
+
+func (c *Catalog) AddTranslation(itemId string, locale string, translation Translation) {
+	if _, ok := c.Translations[itemId]; !ok {
+		c.Translations[itemId] = make(map[string]Translation)
+	}
+
+	c.Translations[itemId][locale] = translation
+	c.Locales[locale] = true
+}
+
+// GetTitle returns translated title of course, chapter or practice project or title of default locale.
+func (c *Catalog) GetTitle(itemId string, locale string, title string) string {
+	if t, ok := c.Translations[itemId][locale]; ok && len(t.Title) > 0 {
+		return t.Title
+	}
+
+	return title
+}
+
+// GetTags returns translated tags of course or tags of default locale.
+func (c *Catalog) GetTags(courseId string, locale string, tags string) string {
+	if t, ok := c.Translations[courseId][locale]; ok && len(t.Tags) > 0 {
+		return t.Tags
+	}
+
+	return tags
+}
+
+// ReadLocalizedText returns translation of markdown file or file of default locale.
+func (c *Catalog) ReadLocalizedText(path string, locale string) (string, error) {
+	if content, ok := c.Texts[GetLocalizedPath(path, locale)]; ok {
+		return content, nil
+	}
+
+	return c.ReadText(path)
+}
+
+func ReadLocalizedText(path string, locale string) (string, error) {
+	return GetCatalog().ReadLocalizedText(path, locale)
+}
+
+// LocalizeChapters replaces titles of chapters and practice projects with translated ones.
+func LocalizeChapters(chapters []ChapterForUser, locale string) []ChapterForUser {
+	c := GetCatalog()
+
+	for i := range chapters {
+		chapters[i].Title = c.GetTitle(chapters[i].ChapterId, locale, chapters[i].Title)
+		if len(chapters[i].ParentChapterTitle) > 0 {
+			chapters[i].ParentChapterTitle = c.GetTitle(chapters[i].ParentChapterId, locale, chapters[i].ParentChapterTitle)
+		}
+	}
+
+	return chapters
+}
+
+// LocalizeCourses replaces titles and tags of courses with translated ones and reads translated descriptions.
+func LocalizeCourses(courses []CourseForUser, locale string) []CourseForUser {
+	c := GetCatalog()
+
+	for i := range courses {
+		courses[i].Title = c.GetTitle(courses[i].CourseId, locale, courses[i].Title)
+		courses[i].Tags = c.GetTags(courses[i].CourseId, locale, courses[i].Tags)
+		courses[i].Description, _ = c.ReadLocalizedText(filepath.Join(courses[i].Path, "description.md"), locale)
+	}
+
+	return courses
+}
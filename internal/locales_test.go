@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	plan := []string{"de", "en", "ru"}
+	fact := ParseAcceptLanguage("en-US;q=0.9, ru;q=0.5, de, en;q=0.3, fr;q=0, *;q=0.1")
+
+	if len(plan) != len(fact) {
+		t.Fatalf(`Wrong locales count. Plan: %v Fact: %v`, plan, fact)
+	}
+
+	for i := range plan {
+		if plan[i] != fact[i] {
+			t.Fatalf(`Wrong locale at position %v. Plan: %v Fact: %v`, i, plan[i], fact[i])
+		}
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	c := setTestCatalog()
+	c.AddTranslation("rust_chapter_0010", "en", Translation{Title: "Basics (en)"})
+
+	cases := [][]string{
+		// lang, Accept-Language, locale
+		{"", "", DefaultLocale},
+		{"en", "", "en"},
+		{"EN-us", "ru", "en"},
+		{"de", "de, en;q=0.5", "en"},
+		{"", "fr, de", DefaultLocale},
+	}
+
+	for _, tc := range cases {
+		if fact := c.NegotiateLocale(tc[0], tc[1]); fact != tc[2] {
+			t.Fatalf(`Wrong locale for %v. Plan: %v Fact: %v`, tc, tc[2], fact)
+		}
+	}
+}
+
+func TestLocalizedContent(t *testing.T) {
+	c := setTestCatalog()
+	c.AddTranslation("rust_chapter_0010", "en", Translation{Title: "Basics (en)"})
+	c.AddTranslation("rust", "en", Translation{Title: "Rust (en)", Tags: `{"title": "Rust (en)"}`})
+
+	contentPath, _ := GetPathToChapterText("rust", "rust_chapter_0010")
+	c.Texts[contentPath] = "# Основы"
+	c.Texts[GetLocalizedPath(contentPath, "en")] = "# Basics"
+
+	if GetLocalizedPath(contentPath, "en") != "/data/courses/rust/rust_chapter_0010/text.en.md" {
+		t.Fatalf(`Wrong localized path: %v`, GetLocalizedPath(contentPath, "en"))
+	}
+
+	if text, _ := c.ReadLocalizedText(contentPath, "en"); text != "# Basics" {
+		t.Fatalf(`Wrong translated text: %v`, text)
+	}
+
+	// Fallback to default locale
+	if text, _ := c.ReadLocalizedText(contentPath, "de"); text != "# Основы" {
+		t.Fatalf(`Wrong text without translation: %v`, text)
+	}
+
+	chapters := LocalizeChapters(GetChapters("rust"), "en")
+	if chapters[0].Title != "Basics (en)" || chapters[1].Title != "Variables" {
+		t.Fatalf(`Wrong translated titles: %v %v`, chapters[0].Title, chapters[1].Title)
+	}
+
+	if c.GetTags("rust", "en", "") != `{"title": "Rust (en)"}` || c.GetTitle("rust", DefaultLocale, "Rust") != "Rust" {
+		t.Fatalf(`Wrong course translation`)
+	}
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+)
+
+// Every request gets id: it's taken from X-Request-Id header or generated.
+// Id is added to logs of request and forwarded to watchman, so logs of one /run_task
+// can be found in handyman, watchman and DB errors.
+// Call sites pass context of request to logger: Logger.WithContext(r.Context()).WithFields(...)
+
+const HeaderRequestId = "X-Request-Id"
+
+// Longer ids and ids with non-printable chars are replaced by generated ones
+const maxRequestIdLen = 128
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+type requestIdKey struct{}
+
+// GetRequestId returns id of request or empty string if context is not bound to request
+func GetRequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+func generateRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func isValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIdLen {
+		return false
+	}
+
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestIdMiddleware binds id to request and returns it in X-Request-Id header of response.
+func RequestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestId)
+		if !isValidRequestId(id) {
+			id = generateRequestId()
+		}
+
+		w.Header().Set(HeaderRequestId, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestId(r.Context(), id)))
+	})
+}
+
+// RequestIdHook adds request_id field to entries logged with context of request.
+type RequestIdHook struct{}
+
+func (h RequestIdHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h RequestIdHook) Fire(entry *log.Entry) error {
+	if id := GetRequestId(entry.Context); len(id) > 0 {
+		entry.Data["request_id"] = id
+	}
+
+	return nil
+}
+
+// NewLogger creates logger writing to stderr in format "text" or "json".
+func NewLogger(format string, level log.Level) *log.Logger {
+	var formatter log.Formatter = &prefixed.TextFormatter{
+		DisableColors:   true,
+		TimestampFormat: "2006-01-02 15:04:05.000",
+		FullTimestamp:   true,
+		ForceFormatting: true,
+	}
+
+	if format == LogFormatJson {
+		formatter = &log.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		}
+	}
+
+	logger := &log.Logger{
+		Out:       os.Stderr,
+		Level:     level,
+		Formatter: formatter,
+		Hooks:     make(log.LevelHooks),
+	}
+
+	logger.AddHook(RequestIdHook{})
+	return logger
+}
+
+// HandleLogLevel returns level of logger. If level is passed, it is changed without restart.
+func (s *Server) HandleLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	var opts struct {
+		Level string `json:"level"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			setErrorClass(w, errorClassClient)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Invalid request",
+			})
+			return
+		}
+	}
+
+	if len(opts.Level) > 0 {
+		level, err := log.ParseLevel(opts.Level)
+		if err != nil {
+			setErrorClass(w, errorClassClient)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Invalid level. Use one of: trace, debug, info, warning, error, fatal, panic",
+			})
+			return
+		}
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"old_level": Logger.GetLevel().String(),
+			"new_level": level.String(),
+		}).Warning("/log_level: changed level of logs")
+
+		Logger.SetLevel(level)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"level": Logger.GetLevel().String(),
+	})
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	var requestId string
+	handler := RequestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId = GetRequestId(r.Context())
+	}))
+
+	tests := []struct {
+		header    string
+		generated bool
+	}{
+		{"", true},
+		{"abc-123", false},
+		{"bad id", true},
+		{strings.Repeat("a", maxRequestIdLen+1), true},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/run_task", nil)
+		if len(test.header) > 0 {
+			r.Header.Set(HeaderRequestId, test.header)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if len(requestId) == 0 || w.Header().Get(HeaderRequestId) != requestId {
+			t.Fatalf("Wrong request id in response. Plan: %v Fact: %v", requestId, w.Header().Get(HeaderRequestId))
+		}
+
+		if test.generated == (requestId == test.header) {
+			t.Fatalf("Wrong request id for header %v. Plan: generated=%v Fact: %v", test.header, test.generated, requestId)
+		}
+	}
+}
+
+func TestRequestIdHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger(LogFormatJson, log.InfoLevel)
+	logger.Out = &out
+
+	ctx := WithRequestId(httptest.NewRequest(http.MethodPost, "/run_task", nil).Context(), "abc-123")
+	logger.WithContext(ctx).WithFields(log.Fields{"user_id": "1"}).Info("/run_task: started")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Wrong format of log. Plan: json Fact: %v", out.String())
+	}
+
+	if entry["request_id"] != "abc-123" || entry["user_id"] != "1" {
+		t.Fatalf("Wrong fields of log. Plan: %v Fact: %v", "request_id=abc-123 user_id=1", entry)
+	}
+}
+
+func TestHandleLogLevel(t *testing.T) {
+	s, _ := newTestServer()
+	Logger.SetLevel(log.DebugLevel)
+
+	var response map[string]string
+	if err := callHandler(s.HandleLogLevel, "/log_level", `{"level": "warning"}`, &response); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if response["level"] != "warning" || Logger.GetLevel() != log.WarnLevel {
+		t.Fatalf("Wrong level of logs. Plan: %v Fact: %v", "warning", response)
+	}
+
+	response = map[string]string{}
+	if err := callHandler(s.HandleLogLevel, "/log_level", `{"level": "verbose"}`, &response); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if len(response["error"]) == 0 || Logger.GetLevel() != log.WarnLevel {
+		t.Fatalf("Wrong response to invalid level. Plan: %v Fact: %v", "error", response)
+	}
+}
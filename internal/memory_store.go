@@ -0,0 +1,664 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in memory. It is used in tests of handlers
+// and follows the same rules of status transitions as postgres queries.
+type MemoryStore struct {
+	mutex sync.Mutex
+
+	catalog *Catalog
+
+	// User id -> course, chapter, task or project id -> progress
+	courses  map[string]map[string]string
+	chapters map[string]map[string]string
+	tasks    map[string]map[string]TaskProgress
+	practice map[string]map[string]TaskProgress
+
+	// User id -> course id -> last change of progress
+	activity map[string]map[string]time.Time
+
+	playgrounds  map[string]Playground
+	entitlements map[string]map[string]OptionsEntitlement
+	interactions map[string]map[string]string
+	taskChanges  []TaskChange
+}
+
+func NewMemoryStore(c *Catalog) *MemoryStore {
+	return &MemoryStore{
+		catalog:      c,
+		courses:      make(map[string]map[string]string),
+		chapters:     make(map[string]map[string]string),
+		tasks:        make(map[string]map[string]TaskProgress),
+		practice:     make(map[string]map[string]TaskProgress),
+		activity:     make(map[string]map[string]time.Time),
+		playgrounds:  make(map[string]Playground),
+		entitlements: make(map[string]map[string]OptionsEntitlement),
+		interactions: make(map[string]map[string]string),
+		taskChanges:  []TaskChange{},
+	}
+}
+
+// Analogue of max_edu_status() in postgres
+func maxEduStatus(s1 string, s2 string) string {
+	if s1 == "completed" || s2 == "completed" {
+		return "completed"
+	}
+
+	if s1 == "blocked" || s2 == "blocked" {
+		return "blocked"
+	}
+
+	return "in_progress"
+}
+
+// Analogue of best_solution() in postgres
+func bestSolution(s1 string, t1 string, s2 string, t2 string) string {
+	if s1 == "completed" {
+		return t1
+	}
+
+	if s2 == "completed" {
+		return t2
+	}
+
+	if len(t1) > 0 {
+		return t1
+	}
+
+	return t2
+}
+
+func setStatus(statuses map[string]map[string]string, userId string, itemId string, status string) {
+	if _, ok := statuses[userId]; !ok {
+		statuses[userId] = make(map[string]string)
+	}
+
+	statuses[userId][itemId] = status
+}
+
+func setProgress(progress map[string]map[string]TaskProgress, userId string, p TaskProgress) {
+	if _, ok := progress[userId]; !ok {
+		progress[userId] = make(map[string]TaskProgress)
+	}
+
+	progress[userId][p.TaskId] = p
+}
+
+func (s *MemoryStore) LoadCatalog(ctx context.Context) (*Catalog, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.catalog, nil
+}
+
+func (s *MemoryStore) TryStartCourse(ctx context.Context, userId string, courseId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.courses[userId][courseId]; !ok {
+		setStatus(s.courses, userId, courseId, "in_progress")
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetCourseStatuses(ctx context.Context, userId string) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make(map[string]string)
+	for courseId, status := range s.courses[userId] {
+		statuses[courseId] = status
+	}
+
+	return statuses, nil
+}
+
+func (s *MemoryStore) GetCourseProgress(ctx context.Context, userId string, courseId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.courses[userId][courseId], nil
+}
+
+func (s *MemoryStore) UpdateCourseProgress(ctx context.Context, userId string, courseId string, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	setStatus(s.courses, userId, courseId, status)
+	return nil
+}
+
+// touchCourse records last change of progress on course of chapter, task or project
+func (s *MemoryStore) touchCourse(userId string, itemId string) {
+	courseId := ""
+	if chapterId, ok := s.catalog.Tasks[itemId]; ok {
+		itemId = chapterId
+	}
+
+	if chapter, ok := s.catalog.Chapters[itemId]; ok {
+		courseId = chapter.CourseId
+	} else if p, ok := s.catalog.Practice[itemId]; ok {
+		courseId = p.CourseId
+	} else {
+		return
+	}
+
+	if _, ok := s.activity[userId]; !ok {
+		s.activity[userId] = make(map[string]time.Time)
+	}
+
+	s.activity[userId][courseId] = time.Now()
+}
+
+// GetCourseSummaries recounts progress: memory store keeps no counters.
+func (s *MemoryStore) GetCourseSummaries(ctx context.Context, userId string, courseId string) ([]CourseSummary, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	summaries := []CourseSummary{}
+
+	for _, id := range s.catalog.CourseIds {
+		status := s.courses[userId][id]
+		if (status != "in_progress" && status != "completed") || (len(courseId) > 0 && id != courseId) {
+			continue
+		}
+
+		summary := CourseSummary{CourseId: id, Status: status}
+		if dt, ok := s.activity[userId][id]; ok {
+			summary.DtLastActivity = &dt
+		}
+
+		for _, chapterId := range s.catalog.Courses[id].ChapterIds {
+			if s.chapters[userId][chapterId] == "completed" {
+				summary.ChaptersCompleted++
+			}
+
+			for _, taskId := range s.catalog.Chapters[chapterId].TaskIds {
+				if s.tasks[userId][taskId].Status == "completed" {
+					summary.TasksCompleted++
+				}
+			}
+		}
+
+		for projectId, p := range s.catalog.Practice {
+			if p.CourseId == id && s.practice[userId][projectId].Status == "completed" {
+				summary.ProjectsCompleted++
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+func (s *MemoryStore) RebuildCourseSummaries(ctx context.Context, userId int) (int, error) {
+	return 0, nil
+}
+
+func (s *MemoryStore) CheckCourseSummaries(ctx context.Context, userId int, limit int) ([]SummaryMismatch, error) {
+	return []SummaryMismatch{}, nil
+}
+
+func (s *MemoryStore) GetChapterStatuses(ctx context.Context, userId string) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make(map[string]string)
+	for chapterId, status := range s.chapters[userId] {
+		statuses[chapterId] = status
+	}
+
+	for projectId, p := range s.practice[userId] {
+		statuses[projectId] = p.Status
+	}
+
+	return statuses, nil
+}
+
+func (s *MemoryStore) GetChapterProgress(ctx context.Context, userId string, chapterId string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status, ok := s.chapters[userId][chapterId]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return status, nil
+}
+
+func (s *MemoryStore) UpdateChapterProgress(ctx context.Context, userId string, chapterId string, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	setStatus(s.chapters, userId, chapterId, status)
+	s.touchCourse(userId, chapterId)
+	return nil
+}
+
+func (s *MemoryStore) GetCompletedTaskIds(ctx context.Context, userId string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	taskIds := []string{}
+	for taskId, p := range s.tasks[userId] {
+		if p.Status == "completed" {
+			taskIds = append(taskIds, taskId)
+		}
+	}
+
+	sort.Strings(taskIds)
+	return taskIds, nil
+}
+
+func (s *MemoryStore) GetTaskProgress(ctx context.Context, userId string, taskIds []string) ([]TaskProgress, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tasks := []TaskProgress{}
+	for _, taskId := range taskIds {
+		if p, ok := s.tasks[userId][taskId]; ok {
+			tasks = append(tasks, p)
+		}
+	}
+
+	return tasks, nil
+}
+
+func (s *MemoryStore) SaveTask(ctx context.Context, userId string, taskId string, solutionText string, revision string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.tasks[userId][taskId]
+	if !ok {
+		p = TaskProgress{TaskId: taskId, Status: "in_progress", Revision: revision}
+	}
+
+	p.SolutionText = solutionText
+	setProgress(s.tasks, userId, p)
+	return nil
+}
+
+func (s *MemoryStore) UpdateTaskStatus(ctx context.Context, userId string, taskId string, status string, solutionText string, revision string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	setProgress(s.tasks, userId, TaskProgress{TaskId: taskId, Status: status, SolutionText: solutionText, Revision: revision})
+	s.touchCourse(userId, taskId)
+	return nil
+}
+
+func (s *MemoryStore) MergeUsers(ctx context.Context, userIdCur int, userIdOld int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cur, old := strconv.Itoa(userIdCur), strconv.Itoa(userIdOld)
+
+	for _, statuses := range []map[string]map[string]string{s.courses, s.chapters} {
+		for itemId, status := range statuses[old] {
+			if curStatus, ok := statuses[cur][itemId]; ok {
+				status = maxEduStatus(status, curStatus)
+			}
+
+			setStatus(statuses, cur, itemId, status)
+		}
+
+		delete(statuses, old)
+	}
+
+	for taskId, p := range s.tasks[old] {
+		if curP, ok := s.tasks[cur][taskId]; ok {
+			revision := p.Revision
+			if curP.Status == "completed" && p.Status != "completed" {
+				revision = curP.Revision
+			}
+
+			p = TaskProgress{
+				TaskId:       taskId,
+				Status:       maxEduStatus(p.Status, curP.Status),
+				SolutionText: bestSolution(p.Status, p.SolutionText, curP.Status, curP.SolutionText),
+				Revision:     revision,
+			}
+		}
+
+		setProgress(s.tasks, cur, p)
+	}
+
+	delete(s.tasks, old)
+	return nil
+}
+
+func (s *MemoryStore) SplitUsers(ctx context.Context, userIdCur int, userIdNew int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cur, next := strconv.Itoa(userIdCur), strconv.Itoa(userIdNew)
+
+	for _, statuses := range []map[string]map[string]string{s.courses, s.chapters} {
+		for itemId, status := range statuses[cur] {
+			setStatus(statuses, next, itemId, status)
+		}
+	}
+
+	for _, p := range s.tasks[cur] {
+		setProgress(s.tasks, next, p)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) AddUserInteraction(ctx context.Context, userId string, k string, v string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	setStatus(s.interactions, userId, k, v)
+	return nil
+}
+
+// AddTaskChange registers change of task made by courses import
+func (s *MemoryStore) ApplyDeferredWrites(ctx context.Context, writes []DeferredWrite) error {
+	for _, w := range writes {
+		if w.Kind != DeferredStartCourse && w.Kind != DeferredUserInteraction {
+			return fmt.Errorf("unknown kind of deferred write: %s", w.Kind)
+		}
+	}
+
+	for _, w := range writes {
+		if w.Kind == DeferredStartCourse {
+			s.TryStartCourse(ctx, w.UserId, w.Key)
+		} else {
+			s.AddUserInteraction(ctx, w.UserId, w.Key, w.Value)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) AddTaskChange(change TaskChange) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(change.Policy) == 0 {
+		change.Policy = RevisionPolicyPending
+	}
+
+	if change.DtChange.IsZero() {
+		change.DtChange = time.Now()
+	}
+
+	s.taskChanges = append(s.taskChanges, change)
+}
+
+func (s *MemoryStore) getPendingTaskChanges(courseId string, taskId string) []TaskChange {
+	changes := []TaskChange{}
+
+	for _, change := range s.taskChanges {
+		chapter, ok := s.catalog.Chapters[s.catalog.Tasks[change.TaskId]]
+		if !ok || chapter.CourseId != courseId || change.Policy != RevisionPolicyPending {
+			continue
+		}
+
+		if (len(taskId) > 0 && change.TaskId != taskId) || s.catalog.TaskRevisions[change.TaskId] != change.NewRevision {
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].TaskId < changes[j].TaskId
+	})
+
+	return changes
+}
+
+func (s *MemoryStore) GetPendingTaskChanges(ctx context.Context, courseId string, taskId string) ([]TaskChange, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.getPendingTaskChanges(courseId, taskId), nil
+}
+
+func (s *MemoryStore) ApplyTaskChanges(ctx context.Context, opts OptionsTaskChanges) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changes := s.getPendingTaskChanges(opts.CourseId, opts.TaskId)
+
+	for _, change := range changes {
+		chapterId := s.catalog.Tasks[change.TaskId]
+
+		for userId, tasks := range s.tasks {
+			p, ok := tasks[change.TaskId]
+			if !ok || len(p.Revision) == 0 || p.Revision == change.NewRevision {
+				continue
+			}
+
+			switch opts.Policy {
+			case RevisionPolicyMigrate:
+				p.Revision = change.NewRevision
+
+			case RevisionPolicyInvalidate:
+				if p.Status != "completed" {
+					continue
+				}
+
+				p.Status = "in_progress"
+				if s.chapters[userId][chapterId] == "completed" {
+					s.chapters[userId][chapterId] = "in_progress"
+				}
+				if s.courses[userId][opts.CourseId] == "completed" {
+					s.courses[userId][opts.CourseId] = "in_progress"
+				}
+			}
+
+			tasks[change.TaskId] = p
+		}
+
+		for i := range s.taskChanges {
+			if s.taskChanges[i].TaskId == change.TaskId && s.taskChanges[i].Policy == RevisionPolicyPending {
+				s.taskChanges[i].Policy = opts.Policy
+			}
+		}
+	}
+
+	return len(changes), nil
+}
+
+func (s *MemoryStore) GetPracticeProgress(ctx context.Context, userId string, projectId string) (string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.practice[userId][projectId]
+	if !ok {
+		return "", "", sql.ErrNoRows
+	}
+
+	return p.Status, p.SolutionText, nil
+}
+
+func (s *MemoryStore) SavePractice(ctx context.Context, userId string, projectId string, project string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.practice[userId][projectId]
+	if !ok {
+		p = TaskProgress{TaskId: projectId, Status: "in_progress"}
+	}
+
+	p.SolutionText = project
+	setProgress(s.practice, userId, p)
+	return nil
+}
+
+func (s *MemoryStore) UpdatePracticeStatus(ctx context.Context, userId string, projectId string, status string, project string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	setProgress(s.practice, userId, TaskProgress{TaskId: projectId, Status: status, SolutionText: project})
+	s.touchCourse(userId, projectId)
+	return nil
+}
+
+func (s *MemoryStore) CreatePlayground(ctx context.Context, playground Playground) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	playground.DtCreate, playground.DtLastRequest = now, now
+	defaultVisibility := VisibilityUnlisted
+
+	if len(playground.UserId) > 0 {
+		playground.EditToken = ""
+	}
+
+	// Owner, parent and edit token are set only on creation
+	saved, ok := s.playgrounds[playground.PlaygroundId]
+	if ok {
+		if !canWritePlayground(saved, playground.UserId, playground.EditToken) {
+			return false, ErrPlaygroundOwner
+		}
+
+		playground.UserId, playground.ParentId, playground.DtCreate = saved.UserId, saved.ParentId, saved.DtCreate
+		playground.EditToken = saved.EditToken
+		defaultVisibility = saved.Visibility
+	}
+
+	// Anonymous playground has no owner who could open private one: its visibility is set only on creation
+	if len(playground.Visibility) == 0 || (ok && len(playground.UserId) == 0) {
+		playground.Visibility = defaultVisibility
+	}
+
+	s.playgrounds[playground.PlaygroundId] = playground
+	return !ok, nil
+}
+
+func (s *MemoryStore) GetPlayground(ctx context.Context, playgroundId string) (Playground, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	playground, ok := s.playgrounds[playgroundId]
+	if !ok {
+		return Playground{}, sql.ErrNoRows
+	}
+
+	return playground, nil
+}
+
+func (s *MemoryStore) TouchPlayground(ctx context.Context, playgroundId string, dtTouched time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p, ok := s.playgrounds[playgroundId]; ok && p.DtLastRequest.Before(dtTouched) {
+		p.DtLastRequest = time.Now()
+		s.playgrounds[playgroundId] = p
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetUserPlaygrounds(ctx context.Context, userId string, visibility string, limit int) ([]Playground, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	playgrounds := []Playground{}
+	for _, p := range s.playgrounds {
+		if p.UserId != userId || (len(visibility) > 0 && p.Visibility != visibility) {
+			continue
+		}
+
+		p.UserCode, p.Project = "", ""
+		playgrounds = append(playgrounds, p)
+	}
+
+	sort.Slice(playgrounds, func(i, j int) bool {
+		return playgrounds[i].DtLastRequest.After(playgrounds[j].DtLastRequest)
+	})
+
+	if len(playgrounds) > limit {
+		playgrounds = playgrounds[:limit]
+	}
+
+	return playgrounds, nil
+}
+
+func (s *MemoryStore) isExpiredPlayground(p Playground, rule RetentionRule, dtExpire time.Time) bool {
+	return (len(p.UserId) > 0) == rule.Owned && p.Visibility == rule.Visibility && p.DtLastRequest.Before(dtExpire)
+}
+
+func (s *MemoryStore) CountExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, p := range s.playgrounds {
+		if s.isExpiredPlayground(p, rule, dtExpire) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *MemoryStore) DeleteExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time, limit int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := 0
+	for id, p := range s.playgrounds {
+		if deleted == limit {
+			break
+		}
+
+		if s.isExpiredPlayground(p, rule, dtExpire) {
+			delete(s.playgrounds, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStore) HasEntitlement(ctx context.Context, userId string, courseId string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entitlements[userId][courseId]
+	if !ok {
+		return false, nil
+	}
+
+	return e.DtExpire == nil || e.DtExpire.After(time.Now()), nil
+}
+
+func (s *MemoryStore) GrantEntitlement(ctx context.Context, opts OptionsEntitlement) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	userId := strconv.Itoa(opts.UserId)
+	if _, ok := s.entitlements[userId]; !ok {
+		s.entitlements[userId] = make(map[string]OptionsEntitlement)
+	}
+
+	s.entitlements[userId][opts.CourseId] = opts
+	return nil
+}
+
+func (s *MemoryStore) RevokeEntitlement(ctx context.Context, opts OptionsEntitlement) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entitlements[strconv.Itoa(opts.UserId)], opts.CourseId)
+	return nil
+}
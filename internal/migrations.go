@@ -0,0 +1,446 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Migrations of DB schema are embedded into binary from etc/postgres_migrations and applied
+// by "handyman migrate up". Applied versions are recorded to schema_migrations table.
+// Each migration is applied in transaction. Directives in the first lines of sql file:
+// "-- migrate: manual" - migration is applied by hand, handyman only records it as applied;
+// "-- migrate: no-transaction" - migration is executed without transaction, so that CREATE INDEX CONCURRENTLY
+// works on big tables. Such migration has the only statement: it can't be applied partially.
+// CONCURRENTLY in other migrations is an error.
+
+// Key of postgres advisory lock: parallel instances of handyman don't apply migrations simultaneously
+const migrationsLockKey = 4385720211
+
+const directiveManual = "-- migrate: manual"
+const directiveNoTransaction = "-- migrate: no-transaction"
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+?)(\.down)?\.sql$`)
+var dollarTagRe = regexp.MustCompile(`^\$([A-Za-z_]\w*)?\$`)
+
+// CONCURRENTLY outside of line comments
+var concurrentlyRe = regexp.MustCompile(`(?im)^(?:[^-\n]|-[^-\n])*\bCONCURRENTLY\b`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	IsApplied bool
+	DtApply   time.Time
+}
+
+func hasDirective(script string, directive string) bool {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+
+		if line == directive {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Migration) IsManual() bool {
+	return hasDirective(m.Up, directiveManual)
+}
+
+// checkScript returns error if script can't be executed: CONCURRENTLY fails in transaction,
+// and script of several statements without transaction may be applied partially
+func checkScript(script string) error {
+	if hasDirective(script, directiveManual) {
+		return nil
+	}
+
+	if hasDirective(script, directiveNoTransaction) {
+		if n := len(SplitSqlStatements(script)); n != 1 {
+			return fmt.Errorf("migration without transaction must have one statement, found %d", n)
+		}
+		return nil
+	}
+
+	if concurrentlyRe.MatchString(script) {
+		return fmt.Errorf("CONCURRENTLY can't be executed in transaction, add %q", directiveNoTransaction)
+	}
+
+	return nil
+}
+
+// LoadMigrations reads migrations from fsys ordered by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		match := migrationFileRe.FindStringSubmatch(file)
+		if match == nil {
+			return nil, fmt.Errorf("wrong name of migration file: %s", file)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("different migrations with version %d: %s, %s", version, m.Name, match[2])
+		}
+
+		script, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkScript(string(script)); err != nil {
+			return nil, fmt.Errorf("migration %s: %w", file, err)
+		}
+
+		if len(match[3]) > 0 {
+			m.Down = string(script)
+		} else {
+			m.Up = string(script)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if len(m.Up) == 0 {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// skipToken returns length of s up to the end of token searched after offset or length of s.
+func skipToken(s string, token string, offset int) int {
+	if end := strings.Index(s[offset:], token); end >= 0 {
+		return offset + end + len(token)
+	}
+
+	return len(s)
+}
+
+// SplitSqlStatements splits script to statements by semicolons outside of
+// quotes, comments and dollar-quoted function bodies.
+func SplitSqlStatements(script string) []string {
+	statements := []string{}
+	var cur strings.Builder
+
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); len(stmt) > 0 {
+			statements = append(statements, stmt)
+		}
+		cur.Reset()
+	}
+
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		n := 1
+
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			n = skipToken(rest, "\n", 2)
+		case strings.HasPrefix(rest, "/*"):
+			n = skipToken(rest, "*/", 2)
+		case rest[0] == '\'' || rest[0] == '"':
+			n = skipToken(rest, rest[:1], 1)
+		case rest[0] == '$':
+			if tag := dollarTagRe.FindString(rest); len(tag) > 0 {
+				n = skipToken(rest, tag, len(tag))
+			}
+		case rest[0] == ';':
+			flush()
+			i++
+			continue
+		}
+
+		cur.WriteString(rest[:n])
+		i += n
+	}
+
+	flush()
+	return statements
+}
+
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+}
+
+func NewMigrator(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// withLock runs f on single connection holding advisory lock.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationsLockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationsLockKey)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer NOT NULL PRIMARY KEY,
+			name varchar NOT NULL,
+			dt_apply TIMESTAMPTZ NOT NULL DEFAULT Now()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+type queryer interface {
+	Query(ctx context.Context, query string, args ...any) (pgx.Rows, error)
+}
+
+func getAppliedMigrations(ctx context.Context, q queryer) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	rows, err := q.Query(ctx, `SELECT version, dt_apply FROM schema_migrations`)
+	if err != nil {
+		return applied, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var dtApply time.Time
+		if err := rows.Scan(&version, &dtApply); err != nil {
+			return applied, err
+		}
+
+		applied[version] = dtApply
+	}
+
+	return applied, rows.Err()
+}
+
+// runScript executes script of migration and records the result with query.
+func runScript(ctx context.Context, conn *pgxpool.Conn, script string, query string, args ...any) error {
+	if hasDirective(script, directiveManual) {
+		_, err := conn.Exec(ctx, query, args...)
+		return err
+	}
+
+	// The only statement: if it fails, nothing is applied and migration is not recorded
+	if hasDirective(script, directiveNoTransaction) {
+		if _, err := conn.Exec(ctx, script); err != nil {
+			return err
+		}
+
+		_, err := conn.Exec(ctx, query, args...)
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, script)
+	if err == nil {
+		_, err = tx.Exec(ctx, query, args...)
+	}
+
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Up applies all not applied migrations. Returns count of applied migrations.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err = runScript(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations(version, name) VALUES($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("couldn't apply migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			Logger.WithFields(log.Fields{
+				"version":   migration.Version,
+				"name":      migration.Name,
+				"is_manual": migration.IsManual(),
+			}).Info("Applied migration")
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Down rolls back the latest applied migrations. Returns count of rolled back migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if len(migration.Down) == 0 {
+				return fmt.Errorf("migration %04d_%s has no down script", migration.Version, migration.Name)
+			}
+
+			err = runScript(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("couldn't roll back migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			Logger.WithFields(log.Fields{
+				"version": migration.Version,
+				"name":    migration.Name,
+			}).Info("Rolled back migration")
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Baseline records migrations up to version as applied without running them.
+// It is needed once for DB to which migrations were applied by hand.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+
+			_, err := conn.Exec(ctx, `
+				INSERT INTO schema_migrations(version, name) VALUES($1, $2) ON CONFLICT DO NOTHING
+			`, migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	statuses := []MigrationStatus{}
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			dtApply, ok := applied[migration.Version]
+			statuses = append(statuses, MigrationStatus{Migration: migration, IsApplied: ok, DtApply: dtApply})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// CheckSchema returns error if some migrations known to binary are not applied to DB.
+func (m *Migrator) CheckSchema(ctx context.Context) error {
+	applied, err := getAppliedMigrations(ctx, m.db)
+	if err != nil {
+		return fmt.Errorf("couldn't get applied migrations: %w", err)
+	}
+
+	missing := []string{}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			missing = append(missing, fmt.Sprintf("%04d_%s", migration.Version, migration.Name))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("schema is outdated, not applied migrations: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
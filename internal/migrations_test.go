@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "senjun.ru/handyman/etc/postgres_migrations"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_column.sql":      {Data: []byte("ALTER TABLE t ADD COLUMN c int;")},
+		"0001_create_table.sql":    {Data: []byte("-- migrate: manual\nCREATE TABLE t();")},
+		"0002_add_column.down.sql": {Data: []byte("ALTER TABLE t DROP COLUMN c;")},
+	}
+
+	loaded, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatalf(`Couldn't load migrations: %v`, err)
+	}
+
+	if len(loaded) != 2 || loaded[0].Version != 1 || loaded[1].Name != "add_column" {
+		t.Fatalf(`Wrong migrations. Plan: [1 2] Fact: %v`, loaded)
+	}
+
+	if !loaded[0].IsManual() || loaded[1].IsManual() || len(loaded[0].Down) != 0 || len(loaded[1].Down) == 0 {
+		t.Fatalf(`Wrong scripts of migrations: %v`, loaded)
+	}
+
+	fsys["0002_drop_table.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE t;")}
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Fatalf(`Migrations with the same version are loaded`)
+	}
+}
+
+func TestLoadMigrationsConcurrently(t *testing.T) {
+	tests := []struct {
+		script string
+		valid  bool
+	}{
+		{"-- migrate: no-transaction\nCREATE INDEX CONCURRENTLY i ON t(c);", true},
+		// Migration without transaction can't be applied partially
+		{"-- migrate: no-transaction\nALTER TABLE t ADD COLUMN c int;\nCREATE INDEX CONCURRENTLY i ON t(c);", false},
+		{"-- migrate: manual\nCREATE INDEX CONCURRENTLY i ON t(c);", true},
+		{"-- Index is built without CONCURRENTLY: table is small\nCREATE INDEX i ON t(c);", true},
+		{"CREATE INDEX CONCURRENTLY i ON t(c);", false},
+		{"-- Index of t\ncreate index concurrently i on t(c);", false},
+	}
+
+	for _, test := range tests {
+		fsys := fstest.MapFS{"0001_add_index.sql": {Data: []byte(test.script)}}
+		if _, err := LoadMigrations(fsys); (err == nil) != test.valid {
+			t.Fatalf(`Wrong check of script %q. Plan: %v Fact: %v`, test.script, test.valid, err)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf(`Couldn't load embedded migrations: %v`, err)
+	}
+
+	for i, m := range loaded {
+		if m.Version != i+1 {
+			t.Fatalf(`Wrong version of migration %s. Plan: %v Fact: %v`, m.Name, i+1, m.Version)
+		}
+
+		if len(m.Down) == 0 {
+			t.Fatalf(`Migration %04d_%s has no down script`, m.Version, m.Name)
+		}
+
+		// Only migrations of metrics DB are applied by hand
+		if m.IsManual() != strings.Contains(m.Name, "metrics") {
+			t.Fatalf(`Wrong manual directive in migration %04d_%s`, m.Version, m.Name)
+		}
+	}
+}
+
+func TestSplitSqlStatements(t *testing.T) {
+	script := `-- comment; with semicolon
+CREATE FUNCTION f() RETURNS integer AS $BODY$
+BEGIN
+	RETURN 1;
+END;
+$BODY$ LANGUAGE plpgsql;
+INSERT INTO t VALUES('a;b'); /* c; */
+CREATE INDEX CONCURRENTLY i ON t(c)`
+
+	statements := SplitSqlStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf(`Wrong count of statements. Plan: 3 Fact: %v %v`, len(statements), statements)
+	}
+
+	if statements[1] != `INSERT INTO t VALUES('a;b')` || statements[2] != "/* c; */\nCREATE INDEX CONCURRENTLY i ON t(c)" {
+		t.Fatalf(`Wrong statements: %q`, statements)
+	}
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Garbage collection of playgrounds. Playground expires if it wasn't saved, run or opened during retention
+// of its rule. Rules are set per ownership (anonymous or owned by user) and visibility.
+// Expired playgrounds are deleted in small batches: each batch is a short statement
+// which skips rows locked by concurrent saves, so the table is never locked for long.
+
+type RetentionRule struct {
+	// Playgrounds of signed in users if set, anonymous ones otherwise
+	Owned      bool
+	Visibility string
+	// Zero retention keeps playgrounds forever
+	Retention time.Duration
+}
+
+func (r RetentionRule) Name() string {
+	if r.Owned {
+		return "owned_" + r.Visibility
+	}
+
+	return "anonymous_" + r.Visibility
+}
+
+// Anonymous playgrounds are kept for 90 days after the last use, playgrounds of users are kept forever
+const defaultAnonymousRetention = 90 * 24 * time.Hour
+
+const playgroundGcBatchSize = 1000
+
+// Pause between batches: replicas and autovacuum keep up with deletes
+const playgroundGcBatchPause = 100 * time.Millisecond
+
+const playgroundGcTimeout = 30 * time.Minute
+
+var playgroundsDeletedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "handyman_playgrounds_deleted_total",
+	Help: "Expired playgrounds deleted by garbage collection labelled by retention rule",
+}, []string{"rule"})
+
+var playgroundsExpired = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "handyman_playgrounds_expired",
+	Help: "Expired playgrounds found by the last run of garbage collection labelled by retention rule",
+}, []string{"rule"})
+
+var playgroundGcDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "handyman_playgrounds_gc_duration_seconds",
+	Help:    "Duration of garbage collection of playgrounds",
+	Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+})
+
+// DefaultRetentionRules returns rules for all combinations of ownership and visibility
+func DefaultRetentionRules() []RetentionRule {
+	rules := []RetentionRule{}
+	for _, owned := range []bool{false, true} {
+		for _, visibility := range []string{VisibilityPrivate, VisibilityUnlisted, VisibilityPublic} {
+			rule := RetentionRule{Owned: owned, Visibility: visibility}
+			if !owned {
+				rule.Retention = defaultAnonymousRetention
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// parseRetention parses duration with suffix "d" for days in addition to time.ParseDuration units
+func parseRetention(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid retention: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention: %s", s)
+	}
+
+	return d, nil
+}
+
+// ParseRetentionRules overrides default rules: "anonymous_unlisted=30d,owned_private=365d".
+// Retention "0" keeps playgrounds forever.
+func ParseRetentionRules(s string) ([]RetentionRule, error) {
+	rules := DefaultRetentionRules()
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid retention rule: %s", item)
+		}
+
+		retention, err := parseRetention(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for i := range rules {
+			if rules[i].Name() == strings.TrimSpace(parts[0]) {
+				rules[i].Retention = retention
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown retention rule: %s", parts[0])
+		}
+	}
+
+	return rules, nil
+}
+
+type PlaygroundGcReport struct {
+	Rule      string
+	Retention time.Duration
+	// Expired playgrounds. For dry run it's count of playgrounds which would be deleted
+	Expired int
+	Deleted int
+}
+
+// PlaygroundJanitor runs garbage collection of playgrounds periodically.
+type PlaygroundJanitor struct {
+	repo      PlaygroundRepo
+	rules     []RetentionRule
+	batchSize int
+	pause     time.Duration
+
+	started bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewPlaygroundJanitor creates janitor: Start runs it periodically, Collect runs it once.
+func NewPlaygroundJanitor(repo PlaygroundRepo, rules []RetentionRule) *PlaygroundJanitor {
+	return &PlaygroundJanitor{
+		repo:      repo,
+		rules:     rules,
+		batchSize: playgroundGcBatchSize,
+		pause:     playgroundGcBatchPause,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Start runs garbage collection once in interval until Close.
+func (j *PlaygroundJanitor) Start(interval time.Duration, dryRun bool) {
+	j.started = true
+	go j.run(interval, dryRun)
+}
+
+// Collect deletes expired playgrounds. With dry run it only counts them.
+func (j *PlaygroundJanitor) Collect(ctx context.Context, dryRun bool) ([]PlaygroundGcReport, error) {
+	defer prometheus.NewTimer(playgroundGcDuration).ObserveDuration()
+	reports := []PlaygroundGcReport{}
+
+	for _, rule := range j.rules {
+		if rule.Retention == 0 {
+			continue
+		}
+
+		report := PlaygroundGcReport{Rule: rule.Name(), Retention: rule.Retention}
+		dtExpire := time.Now().Add(-rule.Retention)
+
+		expired, err := j.repo.CountExpiredPlaygrounds(ctx, rule, dtExpire)
+		if err != nil {
+			return reports, err
+		}
+
+		report.Expired = expired
+		playgroundsExpired.WithLabelValues(rule.Name()).Set(float64(expired))
+
+		for !dryRun && expired > 0 {
+			deleted, err := j.repo.DeleteExpiredPlaygrounds(ctx, rule, dtExpire, j.batchSize)
+			if err != nil {
+				reports = append(reports, report)
+				return reports, err
+			}
+
+			report.Deleted += deleted
+			playgroundsDeletedTotal.WithLabelValues(rule.Name()).Add(float64(deleted))
+
+			if deleted < j.batchSize {
+				break
+			}
+
+			select {
+			case <-time.After(j.pause):
+			case <-ctx.Done():
+				reports = append(reports, report)
+				return reports, ctx.Err()
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func (j *PlaygroundJanitor) run(interval time.Duration, dryRun bool) {
+	defer close(j.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), playgroundGcTimeout)
+			go func() {
+				// Long run is interrupted by Close
+				select {
+				case <-j.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			reports, err := j.Collect(ctx, dryRun)
+			cancel()
+
+			for _, report := range reports {
+				Logger.WithFields(log.Fields{
+					"rule":      report.Rule,
+					"retention": report.Retention.String(),
+					"expired":   report.Expired,
+					"deleted":   report.Deleted,
+					"dry_run":   dryRun,
+				}).Info("[playgrounds gc] collected expired playgrounds")
+			}
+
+			if err != nil {
+				Logger.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Warning("[playgrounds gc] couldn't collect expired playgrounds")
+			}
+
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Close stops garbage collection. Nil janitor is not running.
+func (j *PlaygroundJanitor) Close() {
+	if j == nil || !j.started {
+		return
+	}
+
+	close(j.stop)
+	<-j.stopped
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	rules, err := ParseRetentionRules("anonymous_unlisted=30d, owned_private=12h,anonymous_public=0")
+	if err != nil {
+		t.Fatalf("Couldn't parse retention rules: %v", err)
+	}
+
+	plan := map[string]time.Duration{
+		"anonymous_private":  defaultAnonymousRetention,
+		"anonymous_unlisted": 30 * 24 * time.Hour,
+		"anonymous_public":   0,
+		"owned_private":      12 * time.Hour,
+		"owned_unlisted":     0,
+		"owned_public":       0,
+	}
+
+	if len(rules) != len(plan) {
+		t.Fatalf("Wrong count of rules. Plan: %v Fact: %v", len(plan), len(rules))
+	}
+
+	for _, rule := range rules {
+		if rule.Retention != plan[rule.Name()] {
+			t.Fatalf("Wrong retention of %v. Plan: %v Fact: %v", rule.Name(), plan[rule.Name()], rule.Retention)
+		}
+	}
+
+	for _, s := range []string{"anonymous=30d", "owned_public", "owned_public=-1d", "owned_public=month"} {
+		if _, err := ParseRetentionRules(s); err == nil {
+			t.Fatalf("Wrong parsing of invalid rules %v. Plan: %v Fact: %v", s, "error", err)
+		}
+	}
+}
+
+func TestPlaygroundJanitor(t *testing.T) {
+	_, store := newTestServer()
+
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprint("anonymous_old_", i)
+		store.playgrounds[id] = Playground{PlaygroundId: id, Visibility: VisibilityUnlisted, DtLastRequest: old}
+	}
+
+	store.playgrounds["anonymous_new"] = Playground{PlaygroundId: "anonymous_new", Visibility: VisibilityUnlisted, DtLastRequest: time.Now()}
+	store.playgrounds["owned_old"] = Playground{PlaygroundId: "owned_old", UserId: "1", Visibility: VisibilityUnlisted, DtLastRequest: old}
+
+	janitor := NewPlaygroundJanitor(store, DefaultRetentionRules())
+	janitor.batchSize = 2
+	janitor.pause = 0
+
+	reports, err := janitor.Collect(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Couldn't collect playgrounds: %v", err)
+	}
+
+	expired, deleted := 0, 0
+	for _, report := range reports {
+		expired += report.Expired
+		deleted += report.Deleted
+	}
+
+	if expired != 5 || deleted != 0 || len(store.playgrounds) != 7 {
+		t.Fatalf("Wrong dry run. Plan: %v Fact: %v", "5 expired, 0 deleted", reports)
+	}
+
+	reports, err = janitor.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Couldn't collect playgrounds: %v", err)
+	}
+
+	deleted = 0
+	for _, report := range reports {
+		deleted += report.Deleted
+	}
+
+	if deleted != 5 || len(store.playgrounds) != 2 {
+		t.Fatalf("Wrong count of deleted playgrounds. Plan: %v Fact: %v", 5, reports)
+	}
+
+	for _, id := range []string{"anonymous_new", "owned_old"} {
+		if _, ok := store.playgrounds[id]; !ok {
+			t.Fatalf("Wrong deleted playground. Plan: %v Fact: %v", "kept "+id, "deleted")
+		}
+	}
+}
+
+func TestPlaygroundJanitorKeepsOpened(t *testing.T) {
+	s, store := newTestServer()
+
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	store.playgrounds["opened"] = Playground{PlaygroundId: "opened", UserCode: "print(42)", Visibility: VisibilityUnlisted, DtLastRequest: old}
+	store.playgrounds["forgotten"] = Playground{PlaygroundId: "forgotten", UserCode: "print(42)", Visibility: VisibilityUnlisted, DtLastRequest: old}
+
+	// Playground is read by shared link but never saved again
+	var playground Playground
+	if err := callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "opened"}`, &playground); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if playground.UserCode != "print(42)" {
+		t.Fatalf("Wrong opened playground. Plan: %v Fact: %v", "print(42)", playground)
+	}
+
+	janitor := NewPlaygroundJanitor(store, DefaultRetentionRules())
+	janitor.pause = 0
+
+	if _, err := janitor.Collect(context.Background(), false); err != nil {
+		t.Fatalf("Couldn't collect playgrounds: %v", err)
+	}
+
+	if _, ok := store.playgrounds["opened"]; !ok {
+		t.Fatalf("Wrong deleted playground. Plan: %v Fact: %v", "kept opened", "deleted")
+	}
+
+	if _, ok := store.playgrounds["forgotten"]; ok {
+		t.Fatalf("Wrong kept playground. Plan: %v Fact: %v", "deleted forgotten", "kept")
+	}
+
+	// Reads are throttled: recently used playground isn't updated
+	dtLastRequest := store.playgrounds["opened"].DtLastRequest
+	callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "opened"}`, &playground)
+	if store.playgrounds["opened"].DtLastRequest != dtLastRequest {
+		t.Fatalf("Wrong time of the last use. Plan: %v Fact: %v", dtLastRequest, store.playgrounds["opened"].DtLastRequest)
+	}
+}
@@ -0,0 +1,563 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Playgrounds are saved by /run_code and /save_playground. If request has no playground_id,
+// handyman generates random id: playground can be opened only by those who got its link.
+// Playground is updated only by its owner. Anonymous playground has no owner: it is updated by those
+// who have its edit_token returned on creation. Save of playground which user can't update creates fork
+// with new id, unless the code is not changed.
+// Visibility restricts who opens playground: private - owner, unlisted - anyone with link,
+// public - anyone, and it is listed for other users.
+
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+type Playground struct {
+	PlaygroundId string `json:"playground_id"`
+	LangId       string `json:"lang_id"`
+	// Owner. Empty for anonymous user
+	UserId   string `json:"-"`
+	UserCode string `json:"user_code"`
+	// Files of multi-file project
+	Project    string `json:"project,omitempty"`
+	Visibility string `json:"visibility"`
+	// Playground which this one is forked from
+	ParentId string `json:"parent_id,omitempty"`
+	// Hash of edit token of anonymous playground. Empty for playground of user
+	EditToken     string    `json:"-"`
+	DtCreate      time.Time `json:"dt_create"`
+	DtLastRequest time.Time `json:"dt_last_request"`
+}
+
+var ErrPlaygroundOwner = errors.New("playground belongs to another user")
+
+// Playgrounds of user returned by /get_playgrounds
+const maxPlaygroundsList = 100
+
+// Time of the last use is updated by reads at most once in this interval: reads are much more frequent than saves
+const playgroundTouchInterval = time.Hour
+
+// canReadPlayground checks if user can open playground
+func canReadPlayground(p Playground, userId string) bool {
+	return p.Visibility != VisibilityPrivate || (len(p.UserId) > 0 && p.UserId == userId)
+}
+
+// canWritePlayground checks if user can update playground. Anonymous playground is updated
+// with its edit token: editTokenHash is hash of token passed by user
+func canWritePlayground(p Playground, userId string, editTokenHash string) bool {
+	if len(p.UserId) > 0 {
+		return p.UserId == userId
+	}
+
+	return len(p.EditToken) > 0 && p.EditToken == editTokenHash
+}
+
+func hashEditToken(token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Random bytes of generated id: 22 chars in base64
+const playgroundIdSize = 16
+
+// Ids passed by client: letters, digits, '-' and '_'
+const maxPlaygroundIdLen = 64
+
+func generatePlaygroundId() (string, error) {
+	id := make([]byte, playgroundIdSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+func isValidPlaygroundId(id string) bool {
+	if len(id) == 0 || len(id) > maxPlaygroundIdLen {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validatePlayground checks options of playground which is going to be saved
+func validatePlayground(opts *OptionsPlayground) error {
+	if len(opts.LangId) == 0 {
+		return errors.New("unknown lang_id")
+	}
+
+	if len(opts.UserCode) == 0 && len(opts.Project) == 0 {
+		return errors.New("user_code or project is required")
+	}
+
+	if len(opts.PlaygroundId) > 0 && !isValidPlaygroundId(opts.PlaygroundId) {
+		return fmt.Errorf("invalid playground_id: up to %d letters, digits, '-' and '_' are allowed", maxPlaygroundIdLen)
+	}
+
+	if err := validateVisibility(opts.Visibility, opts.userId); err != nil {
+		return err
+	}
+
+	return validateUserId(opts.userId)
+}
+
+func validateVisibility(visibility string, userId string) error {
+	switch visibility {
+	case "", VisibilityUnlisted, VisibilityPublic:
+		return nil
+	case VisibilityPrivate:
+		if len(userId) == 0 {
+			return errors.New("anonymous playground can't be private")
+		}
+		return nil
+	}
+
+	return errors.New("invalid visibility: use private, unlisted or public")
+}
+
+// validateUserId checks optional id of user. It's bigint in DB
+func validateUserId(userId string) error {
+	if len(userId) == 0 {
+		return nil
+	}
+
+	if _, err := strconv.ParseInt(userId, 10, 64); err != nil {
+		return errors.New("invalid user_id")
+	}
+
+	return nil
+}
+
+// SavePlayground creates or updates playground. Id is generated if it is not set in options.
+// If user can't update playground, it is saved as fork: new id and parent are set in options.
+// Unchanged code is not forked. Edit token of created anonymous playground is set in options.
+// Returns ErrPlaygroundOwner if user can't open playground either.
+func (s *Server) SavePlayground(ctx context.Context, opts *OptionsPlayground) error {
+	if len(opts.PlaygroundId) == 0 {
+		id, err := generatePlaygroundId()
+		if err != nil {
+			return err
+		}
+		opts.PlaygroundId = id
+	}
+
+	playground := Playground{
+		PlaygroundId: opts.PlaygroundId,
+		LangId:       opts.LangId,
+		UserId:       opts.userId,
+		UserCode:     opts.UserCode,
+		Project:      opts.Project,
+		Visibility:   opts.Visibility,
+		EditToken:    hashEditToken(opts.EditToken),
+	}
+
+	// Token of new anonymous playground: it's returned only if playground is created
+	newToken := ""
+	if len(opts.userId) == 0 {
+		token, err := generatePlaygroundId()
+		if err != nil {
+			return err
+		}
+
+		newToken = token
+		if len(playground.EditToken) == 0 {
+			playground.EditToken = hashEditToken(newToken)
+		}
+	}
+
+	created, err := s.store.CreatePlayground(ctx, playground)
+	if err != ErrPlaygroundOwner {
+		if created && len(opts.EditToken) == 0 {
+			opts.newEditToken = newToken
+		}
+		return err
+	}
+
+	parent, err := s.GetPlayground(ctx, opts.PlaygroundId, opts.userId)
+	if err == sql.ErrNoRows {
+		return ErrPlaygroundOwner
+	}
+	if err != nil {
+		return err
+	}
+
+	// Run of shared playground without changes doesn't create copies of it
+	if parent.LangId == playground.LangId && parent.UserCode == playground.UserCode && parent.Project == playground.Project {
+		return nil
+	}
+
+	id, err := generatePlaygroundId()
+	if err != nil {
+		return err
+	}
+
+	playground.PlaygroundId, playground.ParentId = id, parent.PlaygroundId
+	if len(newToken) > 0 {
+		playground.EditToken = hashEditToken(newToken)
+	}
+
+	if _, err := s.store.CreatePlayground(ctx, playground); err != nil {
+		return err
+	}
+
+	opts.PlaygroundId, opts.parentId, opts.newEditToken = id, parent.PlaygroundId, newToken
+	return nil
+}
+
+// GetPlayground returns playground if user can open it. Private playground of another user is not found.
+// Opened playground is used: it doesn't expire even if it isn't saved.
+func (s *Server) GetPlayground(ctx context.Context, playgroundId string, userId string) (Playground, error) {
+	playground, err := s.store.GetPlayground(ctx, playgroundId)
+	if err != nil {
+		return Playground{}, err
+	}
+
+	if !canReadPlayground(playground, userId) {
+		return Playground{}, sql.ErrNoRows
+	}
+
+	if err := s.store.TouchPlayground(ctx, playgroundId, time.Now().Add(-playgroundTouchInterval)); err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"playground_id": playgroundId,
+			"error":         err.Error(),
+		}).Warning("Couldn't update time of the last use of playground")
+	}
+
+	return playground, nil
+}
+
+// ForkPlayground copies playground to account of user. Fork is unlisted until owner changes visibility.
+func (s *Server) ForkPlayground(ctx context.Context, playgroundId string, userId string) (Playground, error) {
+	parent, err := s.GetPlayground(ctx, playgroundId, userId)
+	if err != nil {
+		return Playground{}, err
+	}
+
+	id, err := generatePlaygroundId()
+	if err != nil {
+		return Playground{}, err
+	}
+
+	fork := Playground{
+		PlaygroundId: id,
+		LangId:       parent.LangId,
+		UserId:       userId,
+		UserCode:     parent.UserCode,
+		Project:      parent.Project,
+		Visibility:   VisibilityUnlisted,
+		ParentId:     parent.PlaygroundId,
+	}
+
+	_, err = s.store.CreatePlayground(ctx, fork)
+	return fork, err
+}
+
+// HandleSavePlayground saves code of playground without running it. Changes of playground which user can't update
+// are saved to fork: response has its playground_id and parent_id.
+// status_code: 0 - ok, 1 - invalid request, 2 - private playground of another user, 3 - couldn't save.
+func (s *Server) HandleSavePlayground(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := extractOptionsPlayground(r)
+	if err == nil {
+		err = applyTemplate(&opts)
+	}
+	if err == nil {
+		err = validatePlayground(&opts)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status_code": 1,
+			"error":       fmt.Sprintf("Invalid request: %s", err),
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"lang_id":       opts.LangId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Warning("/save_playground: couldn't parse request")
+		return
+	}
+
+	err = s.SavePlayground(r.Context(), &opts)
+	if err == ErrPlaygroundOwner {
+		setErrorClass(w, errorClassDenied)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 2,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+		}).Warning("/save_playground: playground belongs to another user")
+		return
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 3,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Error("/save_playground: couldn't save playground")
+		return
+	}
+
+	response := map[string]interface{}{
+		"status_code":   0,
+		"playground_id": opts.PlaygroundId,
+	}
+	if len(opts.parentId) > 0 {
+		response["parent_id"] = opts.parentId
+	}
+	if len(opts.newEditToken) > 0 {
+		response["edit_token"] = opts.newEditToken
+	}
+	json.NewEncoder(w).Encode(response)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":       opts.userId,
+		"lang_id":       opts.LangId,
+		"playground_id": opts.PlaygroundId,
+		"parent_id":     opts.parentId,
+	}).Info("/save_playground: completed")
+}
+
+// HandleForkPlayground copies playground to account of user.
+// status_code: 0 - ok, 1 - invalid request, 2 - playground not found, 3 - couldn't fork.
+func (s *Server) HandleForkPlayground(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := extractOptionsPlayground(r)
+	if err == nil && (len(opts.userId) == 0 || len(opts.PlaygroundId) == 0) {
+		err = errors.New("user_id and playground_id are required")
+	}
+	if err == nil {
+		err = validateUserId(opts.userId)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status_code": 1,
+			"error":       fmt.Sprintf("Invalid request: %s", err),
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Warning("/fork_playground: couldn't parse request")
+		return
+	}
+
+	fork, err := s.ForkPlayground(r.Context(), opts.PlaygroundId, opts.userId)
+	if err == sql.ErrNoRows {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 2,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+		}).Info("/fork_playground: no playground found")
+		return
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 3,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Error("/fork_playground: couldn't fork playground")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status_code":   0,
+		"playground_id": fork.PlaygroundId,
+		"parent_id":     fork.ParentId,
+	})
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":       opts.userId,
+		"playground_id": fork.PlaygroundId,
+		"parent_id":     fork.ParentId,
+	}).Info("/fork_playground: completed")
+}
+
+// HandleGetPlaygrounds lists playgrounds of user without code. If owner_id is another user, only public ones are listed.
+func (s *Server) HandleGetPlaygrounds(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := extractOptionsPlayground(r)
+	if err == nil && len(opts.OwnerId) == 0 {
+		opts.OwnerId = opts.userId
+	}
+	if err == nil && len(opts.OwnerId) == 0 {
+		err = errors.New("user_id or owner_id is required")
+	}
+	if err == nil {
+		err = validateUserId(opts.OwnerId)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":  opts.userId,
+			"owner_id": opts.OwnerId,
+			"error":    err.Error(),
+		}).Warning("/get_playgrounds: couldn't parse request")
+		return
+	}
+
+	visibility := ""
+	if opts.OwnerId != opts.userId {
+		visibility = VisibilityPublic
+	}
+
+	playgrounds, err := s.store.GetUserPlaygrounds(r.Context(), opts.OwnerId, visibility, maxPlaygroundsList)
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get playgrounds",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":  opts.userId,
+			"owner_id": opts.OwnerId,
+			"error":    err.Error(),
+		}).Error("/get_playgrounds: couldn't select playgrounds")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"playgrounds": playgrounds,
+	})
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":  opts.userId,
+		"owner_id": opts.OwnerId,
+		"count":    len(playgrounds),
+	}).Info("/get_playgrounds: completed")
+}
+
+// HandleGetPlaygroundSnapshot returns playground for shared link. read_only is set if user can't update it:
+// to change it user forks playground.
+// status_code: 1 - invalid request, 2 - playground not found, 3 - couldn't get playground.
+func (s *Server) HandleGetPlaygroundSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := extractOptionsPlayground(r)
+	if err == nil && len(opts.PlaygroundId) == 0 {
+		err = errors.New("playground_id is required")
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status_code": 1,
+			"error":       fmt.Sprintf("Invalid request: %s", err),
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Warning("/get_playground_snapshot: couldn't parse request")
+		return
+	}
+
+	playground, err := s.GetPlayground(r.Context(), opts.PlaygroundId, opts.userId)
+	if err == sql.ErrNoRows {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 2,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+		}).Info("/get_playground_snapshot: no playground found")
+		return
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]int{
+			"status_code": 3,
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Error("/get_playground_snapshot: couldn't select playground")
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Playground
+		IsOwner  bool `json:"is_owner"`
+		ReadOnly bool `json:"read_only"`
+	}{
+		Playground: playground,
+		IsOwner:    len(playground.UserId) > 0 && playground.UserId == opts.userId,
+		ReadOnly:   !canWritePlayground(playground, opts.userId, hashEditToken(opts.EditToken)),
+	})
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":       opts.userId,
+		"playground_id": opts.PlaygroundId,
+	}).Info("/get_playground_snapshot: completed")
+}
@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestHandleSavePlayground(t *testing.T) {
+	s, _ := newTestServer()
+
+	// print(42)
+	var saved map[string]interface{}
+	err := callHandler(s.HandleSavePlayground, "/save_playground?user_id=1",
+		`{"lang_id": "python", "user_code": "cHJpbnQoNDIp", "project": "{\"main.py\": \"print(42)\"}"}`, &saved)
+	if err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	playgroundId, _ := saved["playground_id"].(string)
+	if saved["status_code"] != float64(0) || len(playgroundId) != 22 || !isValidPlaygroundId(playgroundId) {
+		t.Fatalf("Wrong response of saving playground. Plan: %v Fact: %v", "generated playground_id", saved)
+	}
+
+	var playground Playground
+	err = callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "`+playgroundId+`"}`, &playground)
+	if err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	plan := Playground{PlaygroundId: playgroundId, LangId: "python", UserCode: "print(42)", Project: `{"main.py": "print(42)"}`,
+		Visibility: VisibilityUnlisted}
+	playground.DtCreate, playground.DtLastRequest = plan.DtCreate, plan.DtLastRequest
+	if playground != plan {
+		t.Fatalf("Wrong saved playground. Plan: %v Fact: %v", plan, playground)
+	}
+
+	tests := []struct {
+		url        string
+		body       string
+		statusCode float64
+		// Changes are saved to fork of this playground
+		parentId string
+	}{
+		// Owner updates playground
+		{"/save_playground?user_id=1", `{"playground_id": "` + playgroundId + `", "lang_id": "python", "user_code": "cHJpbnQoNDMp"}`, 0, ""},
+		// Playground of another user is forked
+		{"/save_playground?user_id=2", `{"playground_id": "` + playgroundId + `", "lang_id": "python", "user_code": "cHJpbnQoNDQp"}`, 0, playgroundId},
+		{"/save_playground", `{"playground_id": "` + playgroundId + `", "lang_id": "python", "user_code": "cHJpbnQoNDQp"}`, 0, playgroundId},
+		// Anonymous playground with id set by client is created once and then forked
+		{"/save_playground", `{"playground_id": "my-playground", "lang_id": "python", "user_code": "cHJpbnQoNDMp"}`, 0, ""},
+		{"/save_playground", `{"playground_id": "my-playground", "lang_id": "python", "user_code": "cHJpbnQoNDQp"}`, 0, "my-playground"},
+		{"/save_playground?user_id=2", `{"playground_id": "my-playground", "lang_id": "python", "user_code": "cHJpbnQoNDQp"}`, 0, "my-playground"},
+		// Invalid requests
+		{"/save_playground", `{"playground_id": "../etc", "lang_id": "python", "user_code": "cHJpbnQoNDMp"}`, 1, ""},
+		{"/save_playground", `{"lang_id": "cobol", "user_code": "cHJpbnQoNDMp"}`, 1, ""},
+		{"/save_playground", `{"lang_id": "python"}`, 1, ""},
+		{"/save_playground?user_id=abc", `{"lang_id": "python", "user_code": "cHJpbnQoNDMp"}`, 1, ""},
+	}
+
+	for _, test := range tests {
+		var response map[string]interface{}
+		if err := callHandler(s.HandleSavePlayground, test.url, test.body, &response); err != nil {
+			t.Fatalf("Couldn't call handler: %v", err)
+		}
+
+		if response["status_code"] != test.statusCode {
+			t.Fatalf("Wrong status code of %v %v. Plan: %v Fact: %v", test.url, test.body, test.statusCode, response)
+		}
+
+		if parentId, _ := response["parent_id"].(string); parentId != test.parentId ||
+			(len(test.parentId) > 0 && response["playground_id"] == test.parentId) {
+			t.Fatalf("Wrong fork of %v %v. Plan: %v Fact: %v", test.url, test.body, test.parentId, response)
+		}
+	}
+
+	playground = Playground{}
+	callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "my-playground"}`, &playground)
+	if playground.UserCode != "print(43)" {
+		t.Fatalf("Wrong code of anonymous playground. Plan: %v Fact: %v", "print(43)", playground.UserCode)
+	}
+
+	playground = Playground{}
+	callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "`+playgroundId+`"}`, &playground)
+	if playground.UserCode != "print(43)" {
+		t.Fatalf("Wrong code of updated playground. Plan: %v Fact: %v", "print(43)", playground.UserCode)
+	}
+}
+
+func savePlayground(t *testing.T, s *Server, userId string, body string) string {
+	var response map[string]interface{}
+	if err := callHandler(s.HandleSavePlayground, "/save_playground?user_id="+userId, body, &response); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	playgroundId, _ := response["playground_id"].(string)
+	if response["status_code"] != float64(0) || len(playgroundId) == 0 {
+		t.Fatalf("Wrong response of saving playground. Plan: %v Fact: %v", "status_code 0", response)
+	}
+
+	return playgroundId
+}
+
+func TestPlaygroundSharing(t *testing.T) {
+	s, _ := newTestServer()
+
+	privateId := savePlayground(t, s, "1", `{"lang_id": "python", "user_code": "cHJpbnQoNDIp", "visibility": "private"}`)
+	publicId := savePlayground(t, s, "1", `{"lang_id": "python", "user_code": "cHJpbnQoNDIp", "visibility": "public"}`)
+	unlistedId := savePlayground(t, s, "1", `{"lang_id": "python", "user_code": "cHJpbnQoNDIp"}`)
+
+	// Private playground is opened only by owner
+	snapshots := []struct {
+		userId       string
+		playgroundId string
+		statusCode   float64
+		readOnly     bool
+	}{
+		{"1", privateId, 0, false},
+		{"2", privateId, 2, false},
+		{"", privateId, 2, false},
+		{"2", publicId, 0, true},
+		{"", unlistedId, 0, true},
+	}
+
+	for _, test := range snapshots {
+		var response map[string]interface{}
+		err := callHandler(s.HandleGetPlaygroundSnapshot, "/get_playground_snapshot?user_id="+test.userId,
+			`{"playground_id": "`+test.playgroundId+`"}`, &response)
+		if err != nil {
+			t.Fatalf("Couldn't call handler: %v", err)
+		}
+
+		statusCode, ok := response["status_code"]
+		if !ok {
+			statusCode = float64(0)
+		}
+
+		if statusCode != test.statusCode || (test.statusCode == 0 && response["read_only"] != test.readOnly) {
+			t.Fatalf("Wrong snapshot of %v for user %v. Plan: %v %v Fact: %v", test.playgroundId, test.userId, test.statusCode, test.readOnly, response)
+		}
+	}
+
+	// Fork of private playground of another user is not found
+	var response map[string]interface{}
+	callHandler(s.HandleForkPlayground, "/fork_playground?user_id=2", `{"playground_id": "`+privateId+`"}`, &response)
+	if response["status_code"] != float64(2) {
+		t.Fatalf("Wrong fork of private playground. Plan: %v Fact: %v", 2, response)
+	}
+
+	response = map[string]interface{}{}
+	callHandler(s.HandleForkPlayground, "/fork_playground?user_id=2", `{"playground_id": "`+publicId+`"}`, &response)
+	forkId, _ := response["playground_id"].(string)
+	if response["status_code"] != float64(0) || response["parent_id"] != publicId || len(forkId) == 0 {
+		t.Fatalf("Wrong fork of public playground. Plan: %v Fact: %v", publicId, response)
+	}
+
+	// Fork is updated by new owner only
+	if id := savePlayground(t, s, "2", `{"playground_id": "`+forkId+`", "lang_id": "python", "user_code": "cHJpbnQoNDMp"}`); id != forkId {
+		t.Fatalf("Wrong update of fork by owner. Plan: %v Fact: %v", forkId, id)
+	}
+
+	response = map[string]interface{}{}
+	callHandler(s.HandleSavePlayground, "/save_playground?user_id=2", `{"playground_id": "`+privateId+`", "lang_id": "python", "user_code": "cHJpbnQoNDMp"}`, &response)
+	if response["status_code"] != float64(2) {
+		t.Fatalf("Wrong update of private playground by another user. Plan: %v Fact: %v", 2, response)
+	}
+
+	lists := []struct {
+		url   string
+		body  string
+		count int
+	}{
+		{"/get_playgrounds?user_id=1", `{}`, 3},
+		{"/get_playgrounds?user_id=2", `{}`, 1},
+		{"/get_playgrounds?user_id=2", `{"owner_id": "1"}`, 1},
+		{"/get_playgrounds", `{"owner_id": "1"}`, 1},
+	}
+
+	for _, test := range lists {
+		var list struct {
+			Playgrounds []Playground `json:"playgrounds"`
+		}
+		if err := callHandler(s.HandleGetPlaygrounds, test.url, test.body, &list); err != nil {
+			t.Fatalf("Couldn't call handler: %v", err)
+		}
+
+		if len(list.Playgrounds) != test.count {
+			t.Fatalf("Wrong count of playgrounds %v %v. Plan: %v Fact: %v", test.url, test.body, test.count, len(list.Playgrounds))
+		}
+
+		for _, p := range list.Playgrounds {
+			if len(p.UserCode) > 0 {
+				t.Fatalf("Wrong playground in list. Plan: %v Fact: %v", "no code", p.UserCode)
+			}
+		}
+	}
+}
+
+func TestSaveAnonymousSharedPlayground(t *testing.T) {
+	s, store := newTestServer()
+
+	var created map[string]interface{}
+	if err := callHandler(s.HandleSavePlayground, "/save_playground", `{"lang_id": "python", "user_code": "cHJpbnQoNDIp"}`, &created); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	sharedId, _ := created["playground_id"].(string)
+	editToken, _ := created["edit_token"].(string)
+	if created["status_code"] != float64(0) || len(sharedId) == 0 || len(editToken) == 0 {
+		t.Fatalf("Wrong response of saving anonymous playground. Plan: %v Fact: %v", "playground_id and edit_token", created)
+	}
+
+	// Anonymous playground is read-only for everyone except those who have its edit token
+	snapshots := []struct {
+		userId    string
+		editToken string
+		readOnly  bool
+	}{
+		{"", "", true},
+		{"2", "", true},
+		{"", "wrong", true},
+		{"", editToken, false},
+	}
+
+	for _, test := range snapshots {
+		var snapshot map[string]interface{}
+		callHandler(s.HandleGetPlaygroundSnapshot, "/get_playground_snapshot?user_id="+test.userId,
+			`{"playground_id": "`+sharedId+`", "edit_token": "`+test.editToken+`"}`, &snapshot)
+		if snapshot["read_only"] != test.readOnly || snapshot["is_owner"] != false {
+			t.Fatalf("Wrong snapshot of anonymous playground for user %v. Plan: %v Fact: %v", test.userId, test.readOnly, snapshot)
+		}
+	}
+
+	// Second user saves over shared playground: changes go to fork
+	var response map[string]interface{}
+	err := callHandler(s.HandleSavePlayground, "/save_playground?user_id=2",
+		`{"playground_id": "`+sharedId+`", "lang_id": "python", "user_code": "cHJpbnQoNDMp", "visibility": "private"}`, &response)
+	if err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	forkId, _ := response["playground_id"].(string)
+	if response["status_code"] != float64(0) || response["parent_id"] != sharedId || len(forkId) == 0 || forkId == sharedId ||
+		response["edit_token"] != nil {
+		t.Fatalf("Wrong save over anonymous playground. Plan: %v Fact: %v", "fork", response)
+	}
+
+	var shared, fork Playground
+	callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "`+sharedId+`"}`, &shared)
+	if shared.UserCode != "print(42)" || shared.Visibility != VisibilityUnlisted {
+		t.Fatalf("Wrong shared playground after save by another user. Plan: %v Fact: %v", "print(42)", shared)
+	}
+
+	callHandler(s.HandleGetPlaygroundCode, "/get_playground_code?user_id=2", `{"playground_id": "`+forkId+`"}`, &fork)
+	if fork.UserCode != "print(43)" || fork.ParentId != sharedId || fork.Visibility != VisibilityPrivate {
+		t.Fatalf("Wrong fork of shared playground. Plan: %v Fact: %v", "print(43)", fork)
+	}
+
+	// Repeated saves of anonymous user don't create new playgrounds
+	count := len(store.playgrounds)
+	saves := []struct {
+		body     string
+		parentId string
+		userCode string
+	}{
+		// Author updates playground with edit token
+		{`{"playground_id": "` + sharedId + `", "edit_token": "` + editToken + `", "lang_id": "python", "user_code": "cHJpbnQoNDQp"}`, "", "print(44)"},
+		{`{"playground_id": "` + sharedId + `", "edit_token": "` + editToken + `", "lang_id": "python", "user_code": "cHJpbnQoNDUp"}`, "", "print(45)"},
+		// Unchanged code of shared playground is not forked
+		{`{"playground_id": "` + sharedId + `", "lang_id": "python", "user_code": "cHJpbnQoNDUp"}`, "", "print(45)"},
+		{`{"playground_id": "` + sharedId + `", "edit_token": "wrong", "lang_id": "python", "user_code": "cHJpbnQoNDUp"}`, "", "print(45)"},
+	}
+
+	for _, test := range saves {
+		response = map[string]interface{}{}
+		callHandler(s.HandleSavePlayground, "/save_playground", test.body, &response)
+		if response["status_code"] != float64(0) || response["playground_id"] != sharedId || response["parent_id"] != nil {
+			t.Fatalf("Wrong save of anonymous playground %v. Plan: %v Fact: %v", test.body, sharedId, response)
+		}
+
+		if len(store.playgrounds) != count || store.playgrounds[sharedId].UserCode != test.userCode {
+			t.Fatalf("Wrong anonymous playground after save %v. Plan: %v Fact: %v", test.body, test.userCode, store.playgrounds[sharedId])
+		}
+	}
+
+	// Changed code without edit token goes to anonymous fork with its own token
+	response = map[string]interface{}{}
+	callHandler(s.HandleSavePlayground, "/save_playground", `{"playground_id": "`+sharedId+`", "lang_id": "python", "user_code": "cHJpbnQoNDYp"}`, &response)
+	if response["parent_id"] != sharedId || response["edit_token"] == nil || response["edit_token"] == editToken {
+		t.Fatalf("Wrong anonymous fork. Plan: %v Fact: %v", "fork with new edit_token", response)
+	}
+}
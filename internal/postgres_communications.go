@@ -3,200 +3,180 @@ package internal
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/gammazero/workerpool"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 )
 
-// An exported global variable to hold the database connection pool
-// Completely thread-safe and ok. Fear not, my friend
-var DB *sql.DB
+var Logger *log.Logger
 
-var WP *workerpool.WorkerPool
+var dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "handyman_db_query_duration_seconds",
+	Help:    "Duration of DB queries labelled by query name",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"query"})
 
-var Logger *log.Logger
+// observeQuery records duration of query to histogram: defer observeQuery("get_chapter_progress")()
+func observeQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Settings of connection pool which are used if they are not set in connection string.
+// Other settings (pool_max_conn_lifetime, statement_cache_capacity, ...) have pgx defaults.
+// Statements are prepared and cached per connection: default_query_exec_mode=cache_statement.
+const defaultMaxConns = 32
+const defaultMinConns = 2
+const defaultMaxConnIdleTime = 5 * time.Minute
+
+func NewPoolConfig(connStr string) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
 
-// --------------- METRICS
+	if !strings.Contains(connStr, "pool_max_conns=") {
+		config.MaxConns = defaultMaxConns
+	}
+
+	if !strings.Contains(connStr, "pool_min_conns=") {
+		config.MinConns = defaultMinConns
+	}
+
+	if !strings.Contains(connStr, "pool_max_conn_idle_time=") {
+		config.MaxConnIdleTime = defaultMaxConnIdleTime
+	}
+
+	// Span for every query
+	config.ConnConfig.Tracer = dbTracer{}
 
-// /run_task
-var countRunTaskTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_task_total",
-})
-
-var countRunTaskOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_task_ok",
-})
-
-var countRunTaskErrClient = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_task_errors_client",
-})
-
-var countRunTaskErrServer = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_task_errors_server",
-})
-
-// /run_practice
-var countRunPracticeTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_practice_total",
-})
+	return config, nil
+}
 
-var countRunPracticeOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_practice_ok",
-})
+func ConnectDb(connStr string) *pgxpool.Pool {
+	config, err := NewPoolConfig(connStr)
+	if err != nil {
+		Logger.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Couldn't parse connection string for db")
+	}
 
-var countRunPracticeErrClient = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_practice_errors_client",
-})
+	ctx := context.Background()
 
-var countRunPracticeErrServer = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_practice_errors_server",
-})
-
-// /run_code
-var countRunCodeTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_code_total",
-})
-
-var countRunCodeOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_code_ok",
-})
-
-var countRunCodeErrClient = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_code_errors_client",
-})
-
-var countRunCodeErrServer = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_run_code_errors_server",
-})
-
-// /get_courses
-var countGetCoursesTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_courses_total",
-})
-
-var countGetCoursesAnonym = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_courses_anonym",
-})
-
-var countGetCoursesAuthorized = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_courses_authorized",
-})
-
-var countGetCoursesErrClient = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_courses_err_client",
-})
-
-var countGetCoursesErrServer = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_courses_err_server",
-})
-
-// update_course_progress
-var countUpdateCourseProgressTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_total",
-})
-
-var countUpdateCourseProgressServerError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_err_server",
-})
-
-var countUpdateCourseProgressStatusError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_err_status",
-})
-
-var countUpdateCourseProgressClientError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_err_client",
-})
-
-var countUpdateCourseProgressOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_ok",
-})
-
-var countUpdateCourseProgressOkCompleted = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_ok_completed",
-})
-
-var countUpdateCourseProgressNoAction = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_course_progress_no_action",
-})
-
-// /update_chapter_progress
-var countUpdateChapterProgressTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_total",
-})
-
-var countUpdateChapterProgressNoAction = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_no_action",
-})
-
-var countUpdateChapterProgressServerError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_err_server",
-})
-
-var countUpdateChapterProgressStatusError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_err_status",
-})
-
-var countUpdateChapterProgressClientError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_err_client",
-})
-
-var countUpdateChapterProgressOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_ok",
-})
-
-var countUpdateChapterProgressOkCompleted = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_update_chapter_progress_ok_completed",
-})
-
-// /get_chapter
-var countGetChapterTotal = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_chapter_total",
-})
-
-// /get_chapter
-var countGetChapterAnonymous = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_chapter_anonymous",
-})
-
-var countGetChapterServerError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_chapter_err_server",
-})
-
-var countGetChapterClientError = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_chapter_err_client",
-})
-
-var countGetChapterOk = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "handyman_get_chapter_ok",
-})
-
-func ConnectDb(connStr string) *sql.DB {
-	db, err := sql.Open("postgres", connStr)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		Logger.WithFields(log.Fields{
 			"error": err,
-		}).Fatal("Couldn't call Open() for db")
+		}).Fatal("Couldn't create connection pool for db")
 	}
 
-	err = db.Ping()
+	err = pool.Ping(ctx)
 
 	if err != nil {
 		Logger.WithFields(log.Fields{
-			"connStr": connStr,
-			"error":   err,
+			"error": err,
 		}).Fatal("Couldn't communicate db")
 	}
 
-	return db
+	Logger.WithFields(log.Fields{
+		"max_conns":                config.MaxConns,
+		"min_conns":                config.MinConns,
+		"max_conn_idle_time":       config.MaxConnIdleTime.String(),
+		"statement_cache_capacity": config.ConnConfig.StatementCacheCapacity,
+	}).Info("Created connection pool for db")
+
+	return pool
+}
+
+// Replica may lag behind primary. Users progress is read from primary during this time
+// after user changed it: user sees his own writes.
+const replicaLagWindow = 10 * time.Second
+
+// PostgresStore implements Store over postgres connection pool.
+// Connection pool is completely thread-safe and ok. Fear not, my friend
+type PostgresStore struct {
+	db *pgxpool.Pool
+
+	// Optional read replica for read-only queries of users progress. Nil if there is no replica
+	replica *pgxpool.Pool
+
+	// User id -> time of the last change of user progress
+	writes      map[string]time.Time
+	writesMutex sync.Mutex
+	dtPrune     time.Time
+}
+
+func NewPostgresStore(db *pgxpool.Pool, replica *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{
+		db:      db,
+		replica: replica,
+		writes:  make(map[string]time.Time),
+		dtPrune: time.Now(),
+	}
+}
+
+// markWrite remembers that progress of users is changed.
+func (s *PostgresStore) markWrite(userIds ...string) {
+	if s.replica == nil {
+		return
+	}
+
+	s.writesMutex.Lock()
+	defer s.writesMutex.Unlock()
+
+	now := time.Now()
+	for _, userId := range userIds {
+		s.writes[userId] = now
+	}
+
+	if now.Sub(s.dtPrune) < replicaLagWindow {
+		return
+	}
+
+	for userId, dtWrite := range s.writes {
+		if now.Sub(dtWrite) >= replicaLagWindow {
+			delete(s.writes, userId)
+		}
+	}
+	s.dtPrune = now
+}
+
+// reader returns pool for display-only query of user progress: replica if user didn't
+// change progress recently and primary otherwise. Reads which decide on writes or access go to primary:
+// writes of other instances are not tracked.
+func (s *PostgresStore) reader(userId string) *pgxpool.Pool {
+	if s.replica == nil {
+		return s.db
+	}
+
+	s.writesMutex.Lock()
+	defer s.writesMutex.Unlock()
+
+	if dtWrite, ok := s.writes[userId]; ok && time.Since(dtWrite) < replicaLagWindow {
+		return s.db
+	}
+
+	return s.replica
+}
+
+// noRows keeps sql.ErrNoRows as "not found" error of Store
+func noRows(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sql.ErrNoRows
+	}
+
+	return err
 }
 
 // Returns postgres TYPE edu_material_status
@@ -208,877 +188,809 @@ func getEduMaterialStatus(isSolved bool) string {
 	return "in_progress"
 }
 
-func TryStartCourse(userId string, courseId string) {
+func (s *PostgresStore) LoadCatalog(ctx context.Context) (*Catalog, error) {
+	defer observeQuery("load_catalog")()
+	c := NewCatalog()
+
 	query := `
-	INSERT INTO 
+		SELECT course_id, path_on_disk, type, title, tags, revision FROM courses ORDER BY course_id
+	`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var course CatalogCourse
+		if err := rows.Scan(&course.CourseId, &course.Path, &course.CourseType, &course.Title, &course.Tags, &course.Revision); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		c.AddCourse(course)
+	}
+	rows.Close()
+
+	query = `
+		SELECT chapter_id, course_id, title, COALESCE(parent_chapter_id, '') FROM chapters ORDER BY chapter_id
+	`
+	rows, err = s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var chapter CatalogChapter
+		if err := rows.Scan(&chapter.ChapterId, &chapter.CourseId, &chapter.Title, &chapter.ParentChapterId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		c.AddChapter(chapter)
+	}
+	rows.Close()
+
+	query = `
+		SELECT task_id, chapter_id, revision FROM tasks ORDER BY task_id
+	`
+	rows, err = s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var taskId, chapterId, revision string
+		if err := rows.Scan(&taskId, &chapterId, &revision); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		c.AddTask(taskId, chapterId)
+		c.TaskRevisions[taskId] = revision
+	}
+	rows.Close()
+
+	query = `
+		SELECT project_id, course_id, chapter_id, title, main_file, default_cmd_line_args
+		FROM practice ORDER BY chapter_id, project_id
+	`
+	rows, err = s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var p CatalogPractice
+		if err := rows.Scan(&p.ProjectId, &p.CourseId, &p.ChapterId, &p.Title, &p.MainFile, &p.DefaultCmdLineArgs); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		c.AddPractice(p)
+	}
+	rows.Close()
+
+	query = `
+		SELECT item_id, locale, title, COALESCE(tags::text, '') FROM translations
+	`
+	rows, err = s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var itemId, locale string
+		var t Translation
+		if err := rows.Scan(&itemId, &locale, &t.Title, &t.Tags); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		c.AddTranslation(itemId, locale, t)
+	}
+	rows.Close()
+
+	c.Build()
+	c.LoadTexts()
+	c.Search = NewSearchIndex(c)
+	c.DtLoad = time.Now()
+
+	return c, nil
+}
+
+const startCourseQuery = `
+	INSERT INTO
 	course_progress(user_id, course_id, status)
 	VALUES($1, $2, 'in_progress')
 	ON CONFLICT ON CONSTRAINT unique_user_course_id
 	DO NOTHING
 `
 
-	_, err := DB.Exec(query, userId, courseId)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":   userId,
-			"course_id": courseId,
-			"error":     err.Error(),
-		}).Error("update course status: couldn't update course status for user")
-		return
-	}
+func (s *PostgresStore) TryStartCourse(ctx context.Context, userId string, courseId string) error {
+	defer observeQuery("try_start_course")()
+	defer s.markWrite(userId)
+
+	_, err := s.db.Exec(ctx, startCourseQuery, userId, courseId)
+	return err
 }
 
-func createPlayground(playgroundId string, langId string, userId string, userCode string) error {
-	if len(userId) > 0 {
-		query := `
-	INSERT INTO 
-	playgrounds(playground_id, lang_id, user_id, user_code)
-	VALUES($1, $2, $3, $4)
+func (s *PostgresStore) CreatePlayground(ctx context.Context, playground Playground) (bool, error) {
+	defer observeQuery("create_playground")()
+	defer s.markWrite(playground.UserId)
+	// Playground is updated only by its owner. Anonymous playground has no owner and is updated with its edit token.
+	// Owner, parent and edit token are set only on creation. Empty visibility keeps the saved one.
+	// Visibility of anonymous playground is not changed: it has no owner who could open private one.
+	// xmax of inserted row is zero
+	query := `
+	INSERT INTO
+	playgrounds(playground_id, lang_id, user_id, user_code, project, visibility, parent_id, edit_token)
+	VALUES($1, $2, NULLIF($3, '')::bigint, $4, $5, COALESCE(NULLIF($6, '')::playground_visibility, 'unlisted'), NULLIF($7, ''),
+		CASE WHEN $3 = '' THEN NULLIF($8, '') END)
 	ON CONFLICT ON CONSTRAINT unique_playground_id
-	DO UPDATE SET 
-	user_code = EXCLUDED.user_code, 
+	DO UPDATE SET
+	lang_id = EXCLUDED.lang_id,
+	user_code = EXCLUDED.user_code,
+	project = EXCLUDED.project,
+	visibility = CASE WHEN playgrounds.user_id IS NULL THEN playgrounds.visibility
+		ELSE COALESCE(NULLIF($6, '')::playground_visibility, playgrounds.visibility) END,
 	dt_last_request = Now()
+	WHERE playgrounds.user_id = EXCLUDED.user_id OR (playgrounds.user_id IS NULL AND playgrounds.edit_token = NULLIF($8, ''))
+	RETURNING xmax = 0
 `
-		_, err := DB.Exec(query, playgroundId, langId, userId, userCode)
-		return err
+	var created bool
+	err := s.db.QueryRow(ctx, query, playground.PlaygroundId, playground.LangId, playground.UserId,
+		playground.UserCode, playground.Project, playground.Visibility, playground.ParentId, playground.EditToken).Scan(&created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrPlaygroundOwner
 	}
 
+	return created, err
+}
+
+func (s *PostgresStore) GetPlayground(ctx context.Context, playgroundId string) (Playground, error) {
+	defer observeQuery("get_playground")()
 	query := `
-	INSERT INTO 
-	playgrounds(playground_id, lang_id, user_code)
-	VALUES($1, $2, $3)
-	ON CONFLICT ON CONSTRAINT unique_playground_id
-	DO UPDATE SET 
-	user_code = EXCLUDED.user_code, 
-	dt_last_request = Now()
+	SELECT lang_id, COALESCE(user_id::text, ''), COALESCE(user_code, ''), COALESCE(project, ''),
+	visibility, COALESCE(parent_id, ''), COALESCE(edit_token, ''), dt_create, dt_last_request
+	FROM playgrounds
+	WHERE playground_id = $1
+`
+	playground := Playground{PlaygroundId: playgroundId}
+	err := s.db.QueryRow(ctx, query, playgroundId).Scan(&playground.LangId, &playground.UserId,
+		&playground.UserCode, &playground.Project, &playground.Visibility, &playground.ParentId,
+		&playground.EditToken, &playground.DtCreate, &playground.DtLastRequest)
+	return playground, noRows(err)
+}
+
+func (s *PostgresStore) TouchPlayground(ctx context.Context, playgroundId string, dtTouched time.Time) error {
+	defer observeQuery("touch_playground")()
+	query := `
+	UPDATE playgrounds SET dt_last_request = Now()
+	WHERE playground_id = $1 AND dt_last_request < $2
 `
-	_, err := DB.Exec(query, playgroundId, langId, userCode)
+	_, err := s.db.Exec(ctx, query, playgroundId, dtTouched)
 	return err
+}
+
+func (s *PostgresStore) GetUserPlaygrounds(ctx context.Context, userId string, visibility string, limit int) ([]Playground, error) {
+	defer observeQuery("get_user_playgrounds")()
+	query := `
+	SELECT playground_id, lang_id, visibility, COALESCE(parent_id, ''), dt_create, dt_last_request
+	FROM playgrounds
+	WHERE user_id = $1::bigint AND ($2 = '' OR visibility = NULLIF($2, '')::playground_visibility)
+	ORDER BY dt_last_request DESC
+	LIMIT $3
+`
+	rows, err := s.reader(userId).Query(ctx, query, userId, visibility, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playgrounds := []Playground{}
+	for rows.Next() {
+		p := Playground{UserId: userId}
+		if err := rows.Scan(&p.PlaygroundId, &p.LangId, &p.Visibility, &p.ParentId, &p.DtCreate, &p.DtLastRequest); err != nil {
+			return nil, err
+		}
+		playgrounds = append(playgrounds, p)
+	}
+
+	return playgrounds, rows.Err()
+}
 
+func (s *PostgresStore) CountExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time) (int, error) {
+	defer observeQuery("count_expired_playgrounds")()
+	query := `
+	SELECT count(*) FROM playgrounds
+	WHERE (user_id IS NOT NULL) = $1 AND visibility = $2::playground_visibility AND dt_last_request < $3
+`
+	var count int
+	err := s.db.QueryRow(ctx, query, rule.Owned, rule.Visibility, dtExpire).Scan(&count)
+	return count, err
 }
 
-func GetPlaygroundCode(playroundId string) (string, error) {
+func (s *PostgresStore) DeleteExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time, limit int) (int, error) {
+	defer observeQuery("delete_expired_playgrounds")()
+	// Rows locked by concurrent saves are skipped: they are used right now
 	query := `
-SELECT user_code FROM playgrounds where playground_id=$1
+	DELETE FROM playgrounds
+	WHERE playground_id IN (
+		SELECT playground_id FROM playgrounds
+		WHERE (user_id IS NOT NULL) = $1 AND visibility = $2::playground_visibility AND dt_last_request < $3
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	)
 `
-	var userCode string
-	row := DB.QueryRow(query, playroundId)
-	err := row.Scan(&userCode)
-	return userCode, err
+	tag, err := s.db.Exec(ctx, query, rule.Owned, rule.Visibility, dtExpire, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
 }
 
-func SaveTask(userId string, taskId string, chapterId string, courseId string, solutionText string) bool {
+func (s *PostgresStore) SaveTask(ctx context.Context, userId string, taskId string, solutionText string, revision string) error {
+	defer observeQuery("save_task")()
+	defer s.markWrite(userId)
 	const taskStatus = "in_progress"
 	const attemptsCount = 0
 
 	query := `
-		INSERT INTO 
-		task_progress(user_id, task_id, status, solution_text, attempts_count)
-		VALUES($1, $2, $3, $4, $5)
+		INSERT INTO
+		task_progress(user_id, task_id, status, solution_text, attempts_count, revision)
+		VALUES($1, $2, $3, $4, $5, $6)
 		ON CONFLICT ON CONSTRAINT unique_user_task_id
-		DO UPDATE SET 
-		status = task_progress.status, 
+		DO UPDATE SET
+		status = task_progress.status,
 		solution_text = EXCLUDED.solution_text,
 		attempts_count = task_progress.attempts_count
 	`
-	_, err := DB.Exec(query, userId, taskId, taskStatus, solutionText, attemptsCount)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id": userId,
-			"task_id": taskId,
-			"error":   err.Error(),
-		}).Error("/save_task update task in DB: couldn't update task solution for user")
-		return false
-	}
+	_, err := s.db.Exec(ctx, query, userId, taskId, taskStatus, solutionText, attemptsCount, revision)
+	return err
+}
 
-	return true
+// withSummaryDelta wraps upsert of progress on chapter, task or practice project (RETURNING status):
+// the change of its completion is added to counter of user_course_summary by the same statement.
+// oldQuery selects status of the item before upsert, courseQuery selects course of the item.
+// Query is executed by execSummaryDelta: concurrent changes of the same item must not see the same old status.
+func withSummaryDelta(counter string, oldQuery string, upsertQuery string, courseQuery string) string {
+	return fmt.Sprintf(`
+		WITH old AS (%[2]s), progress AS (%[3]s RETURNING status)
+		INSERT INTO user_course_summary(user_id, course_id, %[1]s, dt_last_activity)
+		SELECT $1, course_id,
+		(SELECT count(*) FROM progress WHERE status = 'completed') - (SELECT count(*) FROM old WHERE status = 'completed'),
+		Now()
+		FROM (%[4]s) AS item
+		ON CONFLICT ON CONSTRAINT user_course_summary_pkey DO UPDATE SET
+		%[1]s = user_course_summary.%[1]s + EXCLUDED.%[1]s,
+		dt_last_activity = EXCLUDED.dt_last_activity
+	`, counter, oldQuery, upsertQuery, courseQuery)
 }
 
-func UpdateStatus(userId string, taskId string, chapterId string, courseId string, isSolved bool, solutionText string) bool {
-	taskStatus := getEduMaterialStatus(isSolved)
-	const attemptsCount = 1
+// execSummaryDelta executes query of withSummaryDelta under transaction lock of the item of user.
+// Concurrent changes of the same item (double click on run) wait for each other, and the next one
+// selects old status committed by the previous one: statement takes snapshot after the lock.
+// Row lock is not enough: progress row may not exist yet.
+func (s *PostgresStore) execSummaryDelta(ctx context.Context, table string, userId string, itemId string, query string, args ...any) error {
+	batch := &pgx.Batch{}
+	batch.Queue(`SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2 || ':' || $3))`, table, userId, itemId)
+	batch.Queue(query, args...)
 
-	query := `
-		INSERT INTO 
-		task_progress(user_id, task_id, status, solution_text, attempts_count)
-		VALUES($1, $2, $3, $4, $5)
-		ON CONFLICT ON CONSTRAINT unique_user_task_id
-		DO UPDATE SET 
-		status = EXCLUDED.status, 
-		solution_text = EXCLUDED.solution_text,
-		attempts_count = task_progress.attempts_count + EXCLUDED.attempts_count
-	`
-	_, err := DB.Exec(query, userId, taskId, taskStatus, solutionText, attemptsCount)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id": userId,
-			"task_id": taskId,
-			"error":   err.Error(),
-		}).Error("/run_task [worker pool] update task status: couldn't update task status for user")
-		return false
+		return err
 	}
 
-	err = UpdateChapterStatus(userId, chapterId, "in_progress")
-
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":    userId,
-			"chapter_id": chapterId,
-			"error":      err.Error(),
-		}).Error("/run_task [worker pool] update chapter status: couldn't update chapter status for user")
-		return false
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		tx.Rollback(ctx)
+		return err
 	}
 
-	TryStartCourse(userId, courseId)
+	return tx.Commit(ctx)
+}
 
-	Logger.WithFields(log.Fields{
-		"user_id": userId,
-		"task_id": taskId,
-		"status":  taskStatus,
-	}).Info("/run_task [worker pool] update task status: completed")
+func (s *PostgresStore) UpdateTaskStatus(ctx context.Context, userId string, taskId string, status string, solutionText string, revision string) error {
+	defer observeQuery("update_task_status")()
+	defer s.markWrite(userId)
+	const attemptsCount = 1
 
-	return true
+	// Progress is earned against the current revision of task
+	query := withSummaryDelta("tasks_completed", `
+		SELECT status FROM task_progress WHERE user_id = $1 AND task_id = $2
+	`, `
+		INSERT INTO
+		task_progress(user_id, task_id, status, solution_text, attempts_count, revision)
+		VALUES($1, $2, $3, $4, $5, $6)
+		ON CONFLICT ON CONSTRAINT unique_user_task_id
+		DO UPDATE SET
+		status = EXCLUDED.status,
+		solution_text = EXCLUDED.solution_text,
+		attempts_count = task_progress.attempts_count + EXCLUDED.attempts_count,
+		revision = EXCLUDED.revision
+	`, `
+		SELECT chapters.course_id FROM tasks INNER JOIN chapters ON chapters.chapter_id = tasks.chapter_id
+		WHERE tasks.task_id = $2
+	`)
+	return s.execSummaryDelta(ctx, "task_progress", userId, taskId, query, userId, taskId, status, solutionText, attemptsCount, revision)
 }
 
-func UpdateStatusPractice(userId string, projectId string, courseId string, isSolved bool, project string) bool {
-	taskStatus := getEduMaterialStatus(isSolved)
+func (s *PostgresStore) UpdatePracticeStatus(ctx context.Context, userId string, projectId string, status string, project string) error {
+	defer observeQuery("update_practice_status")()
+	defer s.markWrite(userId)
 	const attemptsCount = 1
 
-	query := `
-		INSERT INTO 
+	query := withSummaryDelta("projects_completed", `
+		SELECT status FROM practice_progress WHERE user_id = $1 AND project_id = $2
+	`, `
+		INSERT INTO
 		practice_progress(user_id, project_id, status, solution_text, attempts_count)
 		VALUES($1, $2, $3, $4, $5)
 		ON CONFLICT ON CONSTRAINT unique_user_practice_id
-		DO UPDATE SET 
-		status = EXCLUDED.status, 
+		DO UPDATE SET
+		status = EXCLUDED.status,
 		solution_text = EXCLUDED.solution_text,
 		attempts_count = practice_progress.attempts_count + EXCLUDED.attempts_count
-	`
-	_, err := DB.Exec(query, userId, projectId, taskStatus, project, attemptsCount)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":    userId,
-			"project_id": projectId,
-			"error":      err.Error(),
-		}).Error("update practice status: couldn't update project status for user")
-		return false
-	}
-
-	TryStartCourse(userId, courseId)
-
-	Logger.WithFields(log.Fields{
-		"user_id":    userId,
-		"project_id": projectId,
-		"status":     taskStatus,
-	}).Info("update practice status: completed")
-
-	return true
+	`, `
+		SELECT course_id FROM practice WHERE project_id = $2
+	`)
+	return s.execSummaryDelta(ctx, "practice_progress", userId, projectId, query, userId, projectId, status, project, attemptsCount)
 }
 
-func SavePractice(userId string, projectId string, courseId string, project string) bool {
+func (s *PostgresStore) SavePractice(ctx context.Context, userId string, projectId string, project string) error {
+	defer observeQuery("save_practice")()
+	defer s.markWrite(userId)
 	query := `
-		INSERT INTO 
+		INSERT INTO
 		practice_progress(user_id, project_id, status, solution_text, attempts_count)
 		VALUES($1, $2, 'in_progress', $3, 1)
 		ON CONFLICT ON CONSTRAINT unique_user_practice_id
-		DO UPDATE SET 
-		status = practice_progress.status, 
+		DO UPDATE SET
+		status = practice_progress.status,
 		solution_text = EXCLUDED.solution_text,
 		attempts_count = practice_progress.attempts_count
 	`
-	_, err := DB.Exec(query, userId, projectId, project)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":    userId,
-			"project_id": projectId,
-			"error":      err.Error(),
-		}).Error("save practice: couldn't update project for user")
-		return false
-	}
-
-	TryStartCourse(userId, courseId)
+	_, err := s.db.Exec(ctx, query, userId, projectId, project)
+	return err
+}
 
-	Logger.WithFields(log.Fields{
-		"user_id":    userId,
-		"project_id": projectId,
-	}).Info("save practice: completed")
+func (s *PostgresStore) GetPracticeProgress(ctx context.Context, userId string, projectId string) (string, string, error) {
+	defer observeQuery("get_practice_progress")()
+	query := `
+	SELECT status, solution_text FROM practice_progress WHERE user_id=$1 AND project_id=$2
+`
+	var status, project string
 
-	return true
+	// Status is checked before its change: read from primary
+	row := s.db.QueryRow(ctx, query, userId, projectId)
+	err := row.Scan(&status, &project)
+	return status, project, noRows(err)
 }
 
-func GetCourses() []CourseForUser {
+func (s *PostgresStore) GetCourseStatuses(ctx context.Context, userId string) (map[string]string, error) {
+	defer observeQuery("get_course_statuses")()
 	query := `
-		SELECT courses.course_id, courses.path_on_disk, courses.type, courses.title, courses.tags
-		FROM courses
+		SELECT course_id, status FROM course_progress WHERE user_id=$1
 	`
+	statuses := make(map[string]string)
 
-	rows, err := DB.Query(query)
+	rows, err := s.reader(userId).Query(ctx, query, userId)
 	if err != nil {
-		return []CourseForUser{}
+		return statuses, err
 	}
 
 	defer rows.Close()
 
-	courses := []CourseForUser{}
-
 	for rows.Next() {
-		var course CourseForUser
-
-		if err := rows.Scan(&course.CourseId, &course.Path, &course.CourseType, &course.Title, &course.Tags); err != nil {
-			Logger.WithFields(log.Fields{
-				"error": err.Error(),
-			}).Error("Couldn't parse row from courses selection")
-			return []CourseForUser{}
+		var courseId, status string
+		if err := rows.Scan(&courseId, &status); err != nil {
+			return statuses, err
 		}
 
-		courses = append(courses, course)
+		statuses[courseId] = status
 	}
 
-	return courses
+	return statuses, rows.Err()
 }
 
-func GetPractice(opts Options) (Practice, error) {
+func (s *PostgresStore) GetChapterStatuses(ctx context.Context, userId string) (map[string]string, error) {
+	defer observeQuery("get_chapter_statuses")()
 	query := `
-	SELECT title, chapter_id, main_file, default_cmd_line_args,
-		'not_started' as status
-		FROM practice 
-		WHERE practice.project_id=$1
-`
+		SELECT chapter_id, status FROM chapter_progress WHERE user_id=$1
+		UNION ALL
+		SELECT project_id, status FROM practice_progress WHERE user_id=$1
+	`
+	statuses := make(map[string]string)
 
-	rows, err := DB.Query(query, opts.TaskId)
+	rows, err := s.reader(userId).Query(ctx, query, userId)
 	if err != nil {
-		return Practice{}, err
+		return statuses, err
 	}
 
 	defer rows.Close()
 
-	var p Practice
-
-	if rows.Next() {
-		if err := rows.Scan(&p.Title, &p.ChapterId, &p.MainFile, &p.DefaultCmdLineArgs, &p.Status); err != nil {
-			Logger.WithFields(log.Fields{
-				"error": err.Error(),
-			}).Error("Couldn't parse row from practice selection")
-			return Practice{}, err
+	for rows.Next() {
+		var itemId, status string
+		if err := rows.Scan(&itemId, &status); err != nil {
+			return statuses, err
 		}
+
+		statuses[itemId] = status
 	}
 
-	return p, nil
+	return statuses, rows.Err()
 }
 
-func GetPracticeForUser(opts Options) (Practice, error) {
+func (s *PostgresStore) GetCompletedTaskIds(ctx context.Context, userId string) ([]string, error) {
+	defer observeQuery("get_completed_task_ids")()
 	query := `
-	SELECT title, chapter_id, main_file, default_cmd_line_args,
-		(CASE WHEN practice_progress.status IS NULL THEN 'not_started' ELSE practice_progress.status::varchar(40) END) as status, 
-		(CASE WHEN practice_progress.solution_text IS NULL THEN '' ELSE practice_progress.solution_text END) as project   
-		FROM practice 
-		LEFT JOIN practice_progress 
-		ON practice_progress.user_id=$2 AND practice_progress.project_id=practice.project_id 
-		WHERE practice.project_id=$1
-`
+		SELECT task_id FROM task_progress WHERE user_id=$1 AND status='completed'
+	`
+	taskIds := []string{}
 
-	rows, err := DB.Query(query, opts.TaskId, opts.userId)
+	rows, err := s.reader(userId).Query(ctx, query, userId)
 	if err != nil {
-		return Practice{}, err
+		return taskIds, err
 	}
 
 	defer rows.Close()
 
-	var p Practice
-
-	if rows.Next() {
-		if err := rows.Scan(&p.Title, &p.ChapterId, &p.MainFile, &p.DefaultCmdLineArgs, &p.Status, &p.Project); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": opts.userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from practice selection for user")
-			return Practice{}, err
+	for rows.Next() {
+		var taskId string
+		if err := rows.Scan(&taskId); err != nil {
+			return taskIds, err
 		}
+
+		taskIds = append(taskIds, taskId)
 	}
 
-	return p, nil
+	return taskIds, rows.Err()
 }
 
-func GetCourseInfo(courseId string) (string, error) {
+func (s *PostgresStore) GetChapterProgress(ctx context.Context, userId string, chapterId string) (string, error) {
+	defer observeQuery("get_chapter_progress")()
 	query := `
-		SELECT tags FROM courses where course_id=$1
+		SELECT status FROM chapter_progress WHERE user_id=$1 AND chapter_id=$2
 	`
+	var status string
 
-	var tags string
-	row := DB.QueryRow(query, courseId)
-	err := row.Scan(&tags)
-	return tags, err
+	// Status is checked before its change: read from primary
+	row := s.db.QueryRow(ctx, query, userId, chapterId)
+	err := row.Scan(&status)
+	return status, noRows(err)
 }
 
-func GetCoursePathOnDisk(courseId string) (string, error) {
-	query := `
-		SELECT path_on_disk FROM courses where course_id=$1
-	`
+func (s *PostgresStore) UpdateChapterProgress(ctx context.Context, userId string, chapterId string, status string) error {
+	defer observeQuery("update_chapter_progress")()
+	defer s.markWrite(userId)
+	query := withSummaryDelta("chapters_completed", `
+		SELECT status FROM chapter_progress WHERE user_id = $1 AND chapter_id = $2
+	`, `
+		INSERT INTO chapter_progress(user_id, chapter_id, status)
+		VALUES($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT unique_user_chapter_id
+		DO UPDATE SET
+		status = EXCLUDED.status
+	`, `
+		SELECT course_id FROM chapters WHERE chapter_id = $2
+	`)
 
-	var path_on_disk string
-	row := DB.QueryRow(query, courseId)
-	err := row.Scan(&path_on_disk)
-	return path_on_disk, err
+	return s.execSummaryDelta(ctx, "chapter_progress", userId, chapterId, query, userId, chapterId, status)
 }
 
-func GetCoursesForUser(userId string) []CourseForUser {
-	query := `
-		SELECT courses.course_id, courses.path_on_disk, courses.type, courses.title, courses.tags,
-		(CASE WHEN course_progress.status IS NULL THEN 'not_started' ELSE course_progress.status::varchar(40) END) as status 
-		FROM courses LEFT JOIN course_progress 
-		ON course_progress.course_id = courses.course_id AND course_progress.user_id=$1
-		ORDER BY status, courses.course_id
-	`
+// Deferred writes are retried, so interaction is upserted on both direct and deferred paths
+const userInteractionQuery = `
+	INSERT INTO user_interactions(user_id, interaction_key, interaction_val)
+	VALUES($1, $2, $3)
+	ON CONFLICT (user_id, interaction_key)
+	DO UPDATE SET
+	interaction_val = EXCLUDED.interaction_val,
+	day = CURRENT_DATE
+`
 
-	rows, err := DB.Query(query, userId)
-	if err != nil {
-		return []CourseForUser{}
-	}
+func (s *PostgresStore) AddUserInteraction(ctx context.Context, userId string, k string, v string) error {
+	defer observeQuery("add_user_interaction")()
+	_, err := s.db.Exec(ctx, userInteractionQuery, userId, k, v)
+	return err
+}
 
-	defer rows.Close()
+func (s *PostgresStore) ApplyDeferredWrites(ctx context.Context, writes []DeferredWrite) error {
+	defer observeQuery("apply_deferred_writes")()
+	batch := &pgx.Batch{}
+	userIds := []string{}
+
+	for _, w := range writes {
+		switch w.Kind {
+		case DeferredStartCourse:
+			batch.Queue(startCourseQuery, w.UserId, w.Key)
+		case DeferredUserInteraction:
+			batch.Queue(userInteractionQuery, w.UserId, w.Key, w.Value)
+		default:
+			return fmt.Errorf("unknown kind of deferred write: %s", w.Kind)
+		}
 
-	courses := []CourseForUser{}
+		userIds = append(userIds, w.UserId)
+	}
 
-	for rows.Next() {
-		var course CourseForUser
-
-		if err := rows.Scan(&course.CourseId, &course.Path, &course.CourseType, &course.Title, &course.Tags, &course.Status); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from courses selection for user")
-			return []CourseForUser{}
-		}
+	defer s.markWrite(userIds...)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
 
-		courses = append(courses, course)
+	// All queries of batch are sent in one round trip
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		tx.Rollback(ctx)
+		return err
 	}
 
-	return courses
+	return tx.Commit(ctx)
 }
 
-func GetCoursesForUserByStatus(userId string, status string) []CourseForUser {
+func (s *PostgresStore) GetCourseProgress(ctx context.Context, userId string, courseId string) (string, error) {
+	defer observeQuery("get_course_progress")()
 	query := `
-		SELECT courses.course_id, courses.path_on_disk, courses.type, courses.title, courses.tags
-		FROM courses LEFT JOIN course_progress 
-		ON course_progress.course_id = courses.course_id AND course_progress.user_id=$1
-		WHERE status = $2
-		ORDER BY courses.course_id
+		SELECT status FROM course_progress WHERE course_id=$1 AND user_id=$2
 	`
-
-	rows, err := DB.Query(query, userId, status)
+	var status string
+	rows, err := s.reader(userId).Query(ctx, query, courseId, userId)
 	if err != nil {
-		return []CourseForUser{}
+		return "", err
 	}
 
 	defer rows.Close()
 
-	courses := []CourseForUser{}
-
 	for rows.Next() {
-		var course CourseForUser
-
-		if err := rows.Scan(&course.CourseId, &course.Path, &course.CourseType, &course.Title, &course.Tags); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from courses selection for user")
-			return []CourseForUser{}
+		if err := rows.Scan(&status); err != nil {
+			return "", err
 		}
-
-		courses = append(courses, course)
 	}
 
-	return courses
+	return status, rows.Err()
 }
 
-func GetChapters(courseId string) []ChapterForUser {
+func (s *PostgresStore) GetTaskProgress(ctx context.Context, userId string, taskIds []string) ([]TaskProgress, error) {
+	defer observeQuery("get_task_progress")()
 	query := `
-	SELECT q.item_id, q.title, q.status, q.tasks_count FROM (
-		SELECT
-		chapters.chapter_id as item_id, chapters.chapter_id as item_id_sort, chapters.title as title, 'not_started' as status, 
-		(SELECT COUNT(*) FROM tasks WHERE tasks.chapter_id = chapters.chapter_id) AS tasks_count, 1 as x
-		FROM chapters 
-		WHERE course_id=$1
-		UNION
-		SELECT
-		practice.project_id as item_id, practice.chapter_id as item_id_sort, practice.title as title, 'not_started' as status, 1 as tasks_count, 2 as x
-		FROM practice
-		WHERE course_id=$1
-		) as q ORDER BY q.item_id_sort, q.x
+		SELECT task_id, status, solution_text, revision FROM task_progress WHERE user_id = $1 AND task_id = ANY($2)
 	`
+	tasks := []TaskProgress{}
 
-	rows, err := DB.Query(query, courseId)
+	rows, err := s.reader(userId).Query(ctx, query, userId, taskIds)
 	if err != nil {
-		return []ChapterForUser{}
+		return tasks, err
 	}
 
 	defer rows.Close()
 
-	chapters := []ChapterForUser{}
-
 	for rows.Next() {
-		var chapter ChapterForUser
-
-		if err := rows.Scan(&chapter.ChapterId, &chapter.Title, &chapter.Status, &chapter.TasksTotal); err != nil {
-			Logger.WithFields(log.Fields{
-				"error": err.Error(),
-			}).Error("Couldn't parse row from chapters selection")
-			return []ChapterForUser{}
+		var task TaskProgress
+		if err := rows.Scan(&task.TaskId, &task.Status, &task.SolutionText, &task.Revision); err != nil {
+			return []TaskProgress{}, err
 		}
 
-		chapters = append(chapters, chapter)
+		tasks = append(tasks, task)
 	}
 
-	return chapters
+	return tasks, rows.Err()
+}
+
+func (s *PostgresStore) UpdateCourseProgress(ctx context.Context, userId string, courseId string, status string) error {
+	defer observeQuery("update_course_progress")()
+	defer s.markWrite(userId)
+	const query = `
+		INSERT INTO course_progress(user_id, course_id, status)
+		VALUES($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT unique_user_course_id
+		DO UPDATE SET
+		status = EXCLUDED.status
+	`
+	_, err := s.db.Exec(ctx, query, userId, courseId, status)
+	return err
 }
 
-func GetChaptersForUser(userId string, courseId string) []ChapterForUser {
+func (s *PostgresStore) GetCourseSummaries(ctx context.Context, userId string, courseId string) ([]CourseSummary, error) {
+	defer observeQuery("get_course_summaries")()
 	query := `
-	SELECT q.item_id, q.title, q.status, q.tasks_count, q.tasks_count_completed FROM (
-		SELECT
-		chapters.chapter_id as item_id, chapters.title as title, 
-		(  SELECT (CASE WHEN chapter_progress.status IS NULL THEN 'not_started' ELSE chapter_progress.status::varchar(40) END) as status 
-		   FROM chapter_progress 
-		   WHERE chapters.chapter_id = chapter_progress.chapter_id AND chapter_progress.user_id=$1
-		) as status,
-		(  SELECT COUNT(*) FROM tasks WHERE tasks.chapter_id = chapters.chapter_id
-		) AS tasks_count,
-		(  SELECT COUNT(*) FROM task_progress WHERE task_progress.task_id like CONCAT(chapters.chapter_id, '_task%') 
-		   AND task_progress.status='completed' AND task_progress.user_id=$1
-		) AS tasks_count_completed, chapters.chapter_id as item_id_sort, 1 as x
-		FROM chapters
-		WHERE course_id=$2
-		UNION
-		SELECT
-		practice.project_id as item_id, practice.title as title, 
-		(  SELECT (CASE WHEN practice_progress.status IS NULL THEN 'not_started' ELSE practice_progress.status::varchar(40) END) as status 
-		   FROM practice_progress 
-		   WHERE practice.project_id = practice_progress.project_id AND practice_progress.user_id=$1
-		) as status,
-		1 AS tasks_count,
-		(  SELECT COUNT(*) FROM practice_progress WHERE practice_progress.project_id = practice.project_id
-		   AND practice_progress.status='completed' AND practice_progress.user_id=$1
-		) AS tasks_count_completed, practice.chapter_id as item_id_sort, 2 as x
-		FROM practice
-		WHERE course_id=$2
-		) as q ORDER BY q.item_id_sort, q.x
+		SELECT course_progress.course_id, course_progress.status,
+		COALESCE(summary.chapters_completed, 0), COALESCE(summary.tasks_completed, 0),
+		COALESCE(summary.projects_completed, 0), summary.dt_last_activity
+		FROM course_progress
+		LEFT JOIN user_course_summary AS summary
+		ON summary.user_id = course_progress.user_id AND summary.course_id = course_progress.course_id
+		WHERE course_progress.user_id = $1 AND course_progress.status IN ('in_progress', 'completed')
+		AND ($2 = '' OR course_progress.course_id = $2)
+		ORDER BY course_progress.course_id
 	`
+	summaries := []CourseSummary{}
 
-	rows, err := DB.Query(query, userId, courseId)
+	rows, err := s.reader(userId).Query(ctx, query, userId, courseId)
 	if err != nil {
-		return []ChapterForUser{}
+		return summaries, err
 	}
 
 	defer rows.Close()
 
-	chapters := []ChapterForUser{}
-
 	for rows.Next() {
-		var chapter ChapterForUser
-
-		var status sql.NullString
-		if err := rows.Scan(&chapter.ChapterId, &chapter.Title, &status, &chapter.TasksTotal, &chapter.TasksCompleted); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from chapters selection for user")
-			return []ChapterForUser{}
-		}
+		var summary CourseSummary
 
-		if status.Valid {
-			chapter.Status = status.String
-		} else {
-			chapter.Status = "not_started"
+		if err := rows.Scan(&summary.CourseId, &summary.Status,
+			&summary.ChaptersCompleted, &summary.TasksCompleted, &summary.ProjectsCompleted,
+			&summary.DtLastActivity); err != nil {
+			return []CourseSummary{}, err
 		}
 
-		chapters = append(chapters, chapter)
+		summaries = append(summaries, summary)
 	}
 
-	return chapters
+	return summaries, rows.Err()
 }
 
-func GetFirstChapterId(courseId string) (string, error) {
-	query := `
-		SELECT chapter_id FROM chapters WHERE course_id=$1 ORDER BY chapter_id ASC LIMIT 1
-	`
-	var firstChapterId string
+// Full recount of completed chapters, tasks and practice projects.
+// $1 is user id (0 for all users), $2 is course id (empty for all courses)
+const completedCountsQuery = `
+	SELECT user_id, course_id,
+	sum(chapters) AS chapters_completed, sum(tasks) AS tasks_completed, sum(projects) AS projects_completed
+	FROM (
+		SELECT chapter_progress.user_id, chapters.course_id, 1 AS chapters, 0 AS tasks, 0 AS projects
+		FROM chapter_progress INNER JOIN chapters ON chapters.chapter_id = chapter_progress.chapter_id
+		WHERE chapter_progress.status = 'completed'
+		UNION ALL
+		SELECT task_progress.user_id, chapters.course_id, 0, 1, 0
+		FROM task_progress
+		INNER JOIN tasks ON tasks.task_id = task_progress.task_id
+		INNER JOIN chapters ON chapters.chapter_id = tasks.chapter_id
+		WHERE task_progress.status = 'completed'
+		UNION ALL
+		SELECT practice_progress.user_id, practice.course_id, 0, 0, 1
+		FROM practice_progress INNER JOIN practice ON practice.project_id = practice_progress.project_id
+		WHERE practice_progress.status = 'completed'
+	) AS completed
+	WHERE ($1::bigint = 0 OR user_id = $1::bigint) AND ($2::varchar = '' OR course_id = $2::varchar)
+	GROUP BY user_id, course_id
+`
 
-	row := DB.QueryRow(query, courseId)
-	err := row.Scan(&firstChapterId)
-	return firstChapterId, err
+// rowQuerier is either pool or transaction
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-func GetChapterTitle(chapterId string) (string, error) {
+// rebuildCourseSummaries sets counters of summary to full recount of progress.
+// Returns count of fixed summaries.
+func rebuildCourseSummaries(q rowQuerier, ctx context.Context, userId int, courseId string) (int, error) {
 	query := `
-		SELECT title FROM chapters WHERE chapter_id = $1 LIMIT 1
+		WITH counts AS (` + completedCountsQuery + `), reset AS (
+			UPDATE user_course_summary SET chapters_completed = 0, tasks_completed = 0, projects_completed = 0
+			WHERE ($1::bigint = 0 OR user_id = $1::bigint) AND ($2::varchar = '' OR course_id = $2::varchar)
+			AND (chapters_completed <> 0 OR tasks_completed <> 0 OR projects_completed <> 0)
+			AND (user_id, course_id) NOT IN (SELECT user_id, course_id FROM counts)
+			RETURNING 1
+		), upserted AS (
+			INSERT INTO user_course_summary(user_id, course_id, chapters_completed, tasks_completed, projects_completed)
+			SELECT user_id, course_id, chapters_completed, tasks_completed, projects_completed FROM counts
+			ON CONFLICT ON CONSTRAINT user_course_summary_pkey DO UPDATE SET
+			chapters_completed = EXCLUDED.chapters_completed,
+			tasks_completed = EXCLUDED.tasks_completed,
+			projects_completed = EXCLUDED.projects_completed
+			WHERE (user_course_summary.chapters_completed, user_course_summary.tasks_completed,
+				user_course_summary.projects_completed) IS DISTINCT FROM
+				(EXCLUDED.chapters_completed, EXCLUDED.tasks_completed, EXCLUDED.projects_completed)
+			RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM reset) + (SELECT count(*) FROM upserted)
 	`
-	var title string
+	var fixed int
+	err := q.QueryRow(ctx, query, userId, courseId).Scan(&fixed)
+	return fixed, err
+}
 
-	row := DB.QueryRow(query, chapterId)
-	err := row.Scan(&title)
+func (s *PostgresStore) RebuildCourseSummaries(ctx context.Context, userId int) (int, error) {
+	defer observeQuery("rebuild_course_summaries")()
+	if userId != 0 {
+		defer s.markWrite(strconv.Itoa(userId))
+	}
 
-	return title, err
+	return rebuildCourseSummaries(s.db, ctx, userId, "")
 }
 
-func GetNextChapterId(courseId string, chapterId string, getPractice bool) (string, error) {
+func (s *PostgresStore) CheckCourseSummaries(ctx context.Context, userId int, limit int) ([]SummaryMismatch, error) {
+	defer observeQuery("check_course_summaries")()
 	query := `
-		SELECT chapter_id FROM chapters WHERE course_id=$1 AND chapter_id > $2 ORDER BY chapter_id ASC LIMIT 1
+		WITH counts AS (` + completedCountsQuery + `), summary AS (
+			SELECT * FROM user_course_summary
+			WHERE ($1::bigint = 0 OR user_id = $1::bigint) AND ($2::varchar = '' OR course_id = $2::varchar)
+		)
+		SELECT COALESCE(summary.user_id, counts.user_id), COALESCE(summary.course_id, counts.course_id),
+		COALESCE(summary.chapters_completed, 0), COALESCE(summary.tasks_completed, 0),
+		COALESCE(summary.projects_completed, 0),
+		COALESCE(counts.chapters_completed, 0), COALESCE(counts.tasks_completed, 0),
+		COALESCE(counts.projects_completed, 0)
+		FROM summary
+		FULL OUTER JOIN counts ON counts.user_id = summary.user_id AND counts.course_id = summary.course_id
+		WHERE (COALESCE(summary.chapters_completed, 0), COALESCE(summary.tasks_completed, 0),
+			COALESCE(summary.projects_completed, 0)) IS DISTINCT FROM
+			(COALESCE(counts.chapters_completed, 0), COALESCE(counts.tasks_completed, 0),
+			COALESCE(counts.projects_completed, 0))
+		ORDER BY 1, 2
+		LIMIT $3
 	`
-	var nextChapterId string
+	mismatches := []SummaryMismatch{}
 
-	row := DB.QueryRow(query, courseId, chapterId)
-	err := row.Scan(&nextChapterId)
+	rows, err := s.db.Query(ctx, query, userId, "", limit)
+	if err != nil {
+		return mismatches, err
+	}
 
-	if getPractice && err == nil {
-		query := `
-		SELECT project_id FROM practice WHERE course_id=$1 AND chapter_id = $2 ORDER BY project_id ASC LIMIT 1
-	`
-		row := DB.QueryRow(query, courseId, chapterId)
-		var nextProjectId string
-		errProj := row.Scan(&nextProjectId)
-
-		if errProj == nil {
-			return nextProjectId, errProj
-		}
-	}
-
-	return nextChapterId, err
-}
-
-func GetChapterProgress(userId string, chapterId string) (string, error) {
-	query := `
-		SELECT status FROM chapter_progress WHERE user_id=$1 AND chapter_id=$2
-	`
-	var status string
-
-	row := DB.QueryRow(query, userId, chapterId)
-	err := row.Scan(&status)
-	return status, err
-}
-
-func GetChapterInfo(userId string, chapterId string) (string, string, error) {
-	// Anonymous user
-	if len(userId) == 0 {
-		query := `
-		SELECT
-		title
-		FROM chapters
-		WHERE  chapters.chapter_id=$1
-	`
-		var title string
-
-		row := DB.QueryRow(query, chapterId)
-		err := row.Scan(&title)
-		return "not_started", title, err
-	}
-
-	query := `
-		SELECT
-		(CASE WHEN chapter_progress.status IS NULL THEN 'not_started' ELSE chapter_progress.status::varchar(40) END),
-		chapters.title
-		FROM chapters 
-		LEFT JOIN chapter_progress 
-		ON chapters.chapter_id = chapter_progress.chapter_id AND user_id=$1
-		WHERE  chapters.chapter_id=$2
-	`
-	var status string
-	var title string
-
-	row := DB.QueryRow(query, userId, chapterId)
-	err := row.Scan(&status, &title)
-	return status, title, err
-}
-
-func GetChapterForUser(opts Options) (ChapterContent, error) {
-	var chapterContent ChapterContent
-
-	if len(opts.ChapterId) == 0 {
-		// We need to get the first chapter in course id
-		activeChapterId, err := GetFirstChapterId(opts.CourseId)
-		if err != nil {
-			return ChapterContent{}, err
-		}
-		chapterContent.ChapterId = activeChapterId
-	} else {
-		chapterContent.ChapterId = opts.ChapterId
-	}
-
-	status, title, err := GetChapterInfo(opts.userId, chapterContent.ChapterId)
-	if err != nil {
-		return ChapterContent{}, err
-	}
-	chapterContent.Status = status
-	chapterContent.Title = title
-
-	contentPath, keywordsPath := GetPathToChapterText(opts.CourseId, chapterContent.ChapterId)
-
-	chapterText, _ := ReadTextFile(contentPath)
-	keywordsText, err := ReadTextFile(keywordsPath)
-	if err == nil {
-		chapterContent.Keywords = keywordsText
-	}
-	chapterContent.Content = chapterText
-	chapterContent.Tasks = GetTasks(chapterContent.ChapterId, opts.userId)
-
-	return chapterContent, nil
-}
-
-func GetCourseProgressForUser(courseId string, userId string) (string, error) {
-	query := `
-		SELECT status FROM course_progress WHERE course_id=$1 AND user_id=$2
-	`
-	var status string
-	rows, err := DB.Query(query, courseId, userId)
-	if err != nil {
-		return "", err
-	}
-
-	defer rows.Close()
-
-	for rows.Next() {
-		if err := rows.Scan(&status); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id":   userId,
-				"course_id": courseId,
-				"error":     err.Error(),
-			}).Info("Couldn't get status from course_progress table")
-			return "", err
-		}
-	}
-
-	return status, nil
-}
-
-func GetTasks(chapterId string, userId string) []TaskForUser {
-	query := ""
-
-	// Anonymous user
-	if len(userId) == 0 {
-		query = `
-		SELECT 
-		tasks.task_id, 'not_started', ''
-		FROM tasks 
-		WHERE chapter_id = $2
-	`
-	} else {
-		query = `
-		SELECT 
-		tasks.task_id,
-		(CASE WHEN task_progress.status IS NULL THEN 'not_started' ELSE task_progress.status::varchar(40) END),
-		(CASE WHEN task_progress.solution_text IS NULL THEN '' ELSE task_progress.solution_text::varchar END)
-		FROM tasks 
-		LEFT JOIN task_progress
-		ON tasks.task_id = task_progress.task_id AND user_id = $1
-		WHERE chapter_id = $2
-	`
-	}
-
-	rows, err := DB.Query(query, userId, chapterId)
-	if err != nil {
-		return []TaskForUser{}
-	}
-
-	defer rows.Close()
-
-	tasks := []TaskForUser{}
-
-	for rows.Next() {
-		var task TaskForUser
-
-		if err := rows.Scan(&task.TaskId, &task.Status, &task.UserCode); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from tasks selection for user")
-			return []TaskForUser{}
-		}
-
-		tasks = append(tasks, task)
-	}
-
-	return tasks
-}
-
-func UpdateCourseProgressForUser(courseId string, status string, userId string) error {
-	const query = `
-		INSERT INTO course_progress(user_id, course_id, status) 
-		VALUES($1, $2, $3) 
-		ON CONFLICT ON CONSTRAINT unique_user_course_id
-		DO UPDATE SET 
-		status = EXCLUDED.status
-	`
-	_, err := DB.Exec(query, userId, courseId, status)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":   userId,
-			"course_id": courseId,
-			"db_error":  err.Error(),
-		}).Error("Couldn't update course progress for user")
-		return err
-	}
-
-	return nil
-}
-
-func GetCourseStatus(userId string, courseId string) []CourseStatus {
-	query := `
-	SELECT course_id as course_id, 
-	(SELECT title from courses where courses.course_id = course_progress.course_id) as title,
-
-	(SELECT count(*) from chapters where chapters.course_id = course_progress.course_id) as chapters_total,
-	(SELECT count(*) from chapter_progress where chapter_progress.user_id = $1 and 
-	chapter_progress.status = 'completed' 
-	and chapter_progress.chapter_id like concat(course_id, '_chapter_%')) as chapters_completed,
-
-	(SELECT count(*) from tasks where tasks.task_id like concat(course_id, '_chapter_%')) as tasks_total,
-	(SELECT count(*) from task_progress where task_progress.user_id = $1 and 
-	task_progress.status = 'completed' 
-	and task_progress.task_id like concat(course_id, '_chapter_%')) as tasks_completed,
-
-	(SELECT count(*) from practice where practice.course_id = course_progress.course_id) as projects_total,
-	(SELECT count(*) from practice_progress where practice_progress.user_id = $1 and 
-	practice_progress.status = 'completed' 
-	and practice_progress.project_id like concat(course_id, '_%')) as projects_completed,
-
-	status FROM course_progress
-	WHERE user_id = $1 and status in ('in_progress', 'completed') and course_id = $2
-	`
-
-	rows, err := DB.Query(query, userId, courseId)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id": userId,
-			"error":   err.Error(),
-		}).Warning("Couldn't get course status")
-
-		return []CourseStatus{}
-	}
-
-	defer rows.Close()
-
-	courseStatuses := []CourseStatus{}
+	defer rows.Close()
 
 	for rows.Next() {
-		var cs CourseStatus
-
-		if err := rows.Scan(&cs.CourseId, &cs.Title,
-			&cs.TotalChapters, &cs.FinishedChapters,
-			&cs.TotalTasks, &cs.FinishedTasks,
-			&cs.TotalProjects, &cs.FinishedProjects,
-			&cs.Status); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from course selection for user")
-			return []CourseStatus{}
-		}
-
-		courseStatuses = append(courseStatuses, cs)
-	}
-
-	return courseStatuses
-}
-
-func GetCourseStatuses(userId string) []CourseStatus {
-	query := `
-	SELECT course_id as course_id, 
-	(SELECT title from courses where courses.course_id = course_progress.course_id) as title,
-
-	(SELECT count(*) from chapters where chapters.course_id = course_progress.course_id) as chapters_total,
-	(SELECT count(*) from chapter_progress where chapter_progress.user_id = $1 and 
-	chapter_progress.status = 'completed' 
-	and chapter_progress.chapter_id like concat(course_id, '_chapter_%')) as chapters_completed,
-
-	(SELECT count(*) from tasks where tasks.task_id like concat(course_id, '_chapter_%')) as tasks_total,
-	(SELECT count(*) from task_progress where task_progress.user_id = $1 and 
-	task_progress.status = 'completed' 
-	and task_progress.task_id like concat(course_id, '_chapter_%')) as tasks_completed,
-
-	(SELECT count(*) from practice where practice.course_id = course_progress.course_id) as projects_total,
-	(SELECT count(*) from practice_progress where practice_progress.user_id = $1 and 
-	practice_progress.status = 'completed' 
-	and practice_progress.project_id like concat(course_id, '_%')) as projects_completed,
-
-	status FROM course_progress
-	WHERE user_id = $1 and status in ('in_progress', 'completed')
-	`
-
-	rows, err := DB.Query(query, userId)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id": userId,
-			"error":   err.Error(),
-		}).Warning("Couldn't get course statuses")
-
-		return []CourseStatus{}
-	}
-
-	defer rows.Close()
-
-	courseStatuses := []CourseStatus{}
+		var m SummaryMismatch
 
-	for rows.Next() {
-		var cs CourseStatus
-
-		if err := rows.Scan(&cs.CourseId, &cs.Title,
-			&cs.TotalChapters, &cs.FinishedChapters,
-			&cs.TotalTasks, &cs.FinishedTasks,
-			&cs.TotalProjects, &cs.FinishedProjects,
-			&cs.Status); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from courses selection for user")
-			return []CourseStatus{}
+		if err := rows.Scan(&m.UserId, &m.CourseId,
+			&m.Summary.ChaptersCompleted, &m.Summary.TasksCompleted, &m.Summary.ProjectsCompleted,
+			&m.Recount.ChaptersCompleted, &m.Recount.TasksCompleted, &m.Recount.ProjectsCompleted); err != nil {
+			return []SummaryMismatch{}, err
 		}
 
-		courseStatuses = append(courseStatuses, cs)
+		mismatches = append(mismatches, m)
 	}
 
-	return courseStatuses
+	return mismatches, rows.Err()
 }
 
-func MergeUserCourses(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
+func mergeUserCourses(tx pgx.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
 	query := `
 	SELECT course_id, status FROM course_progress WHERE user_id = $1
 	`
 
-	rows, err := DB.Query(query, userIdOld)
+	rows, err := tx.Query(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"error":       err.Error(),
 		}).Warning("Couldn't get course_progress")
 		return -1
 	}
 
-	defer rows.Close()
+	type courseProgress struct {
+		courseId string
+		status   string
+	}
+
+	// Rows are read before inserting: transaction has single connection
+	courses := []courseProgress{}
 
 	for rows.Next() {
-		var courseId string
-		var status string
-		if err := rows.Scan(&courseId, &status); err != nil {
-			Logger.WithFields(log.Fields{
+		var p courseProgress
+		if err := rows.Scan(&p.courseId, &p.status); err != nil {
+			rows.Close()
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_old": userIdOld,
 				"error":       err.Error(),
 			}).Warning("Couldn't get row from course_progress")
 			return -1
 		}
 
+		courses = append(courses, p)
+	}
+	rows.Close()
+
+	for _, p := range courses {
 		query = `
-			INSERT INTO 
+			INSERT INTO
 			course_progress(user_id, course_id, status)
 			VALUES($1, $2, $3)
 			ON CONFLICT ON CONSTRAINT unique_user_course_id
-			DO UPDATE SET 
+			DO UPDATE SET
 			status = max_edu_status(EXCLUDED.status, course_progress.status)
 		`
-		_, err := tx.ExecContext(ctx, query, userIdCur, courseId, status)
+		_, err := tx.Exec(ctx, query, userIdCur, p.courseId, p.status)
 		if err != nil {
-			Logger.WithFields(log.Fields{
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_cur": userIdCur,
-				"course_id":   courseId,
-				"status":      status,
+				"course_id":   p.courseId,
+				"status":      p.status,
 				"db_error":    err.Error(),
 			}).Error("Couldn't update course status for user")
 			return -1
@@ -1086,9 +998,9 @@ func MergeUserCourses(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld
 	}
 
 	query = `DELETE FROM course_progress WHERE user_id = $1`
-	_, err = tx.ExecContext(ctx, query, userIdOld)
+	_, err = tx.Exec(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"db_error":    err.Error(),
 		}).Error("Couldn't delete course records for user")
@@ -1098,47 +1010,57 @@ func MergeUserCourses(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld
 	return 0
 }
 
-func MergeUserChapters(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
+func mergeUserChapters(tx pgx.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
 	query := `
 	SELECT chapter_id, status FROM chapter_progress WHERE user_id = $1
 	`
 
-	rows, err := DB.Query(query, userIdOld)
+	rows, err := tx.Query(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"error":       err.Error(),
 		}).Warning("Couldn't get chapter_progress")
 		return -1
 	}
 
-	defer rows.Close()
+	type chapterProgress struct {
+		chapterId string
+		status    string
+	}
+
+	chapters := []chapterProgress{}
 
 	for rows.Next() {
-		var chapterId string
-		var status string
-		if err := rows.Scan(&chapterId, &status); err != nil {
-			Logger.WithFields(log.Fields{
+		var p chapterProgress
+		if err := rows.Scan(&p.chapterId, &p.status); err != nil {
+			rows.Close()
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_old": userIdOld,
 				"error":       err.Error(),
 			}).Warning("Couldn't get row from chapter_progress")
 			return -1
 		}
 
+		chapters = append(chapters, p)
+	}
+	rows.Close()
+
+	for _, p := range chapters {
 		query = `
-			INSERT INTO 
+			INSERT INTO
 			chapter_progress(user_id, chapter_id, status)
 			VALUES($1, $2, $3)
 			ON CONFLICT ON CONSTRAINT unique_user_chapter_id
-			DO UPDATE SET 
+			DO UPDATE SET
 			status = max_edu_status(EXCLUDED.status, chapter_progress.status)
 		`
-		_, err := tx.ExecContext(ctx, query, userIdCur, chapterId, status)
+		_, err := tx.Exec(ctx, query, userIdCur, p.chapterId, p.status)
 		if err != nil {
-			Logger.WithFields(log.Fields{
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_cur": userIdCur,
-				"chapter_id":  chapterId,
-				"status":      status,
+				"chapter_id":  p.chapterId,
+				"status":      p.status,
 				"db_error":    err.Error(),
 			}).Error("Couldn't update chapter status for user")
 			return -1
@@ -1146,9 +1068,9 @@ func MergeUserChapters(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld
 	}
 
 	query = `DELETE FROM chapter_progress WHERE user_id = $1`
-	_, err = tx.ExecContext(ctx, query, userIdOld)
+	_, err = tx.Exec(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"db_error":    err.Error(),
 		}).Error("Couldn't delete chapter records for user")
@@ -1158,51 +1080,61 @@ func MergeUserChapters(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld
 	return 0
 }
 
-func MergeUserTasks(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
+func mergeUserTasks(tx pgx.Tx, ctx context.Context, userIdCur int, userIdOld int) int {
 	query := `
-	SELECT task_id, status, solution_text, attempts_count FROM task_progress WHERE user_id = $1
+	SELECT task_id, status, solution_text, attempts_count, revision FROM task_progress WHERE user_id = $1
 	`
 
-	rows, err := DB.Query(query, userIdOld)
+	rows, err := tx.Query(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"error":       err.Error(),
 		}).Warning("Couldn't get task_progress")
 		return -1
 	}
 
-	defer rows.Close()
+	type taskProgress struct {
+		TaskProgress
+		attemptsCount int
+	}
+
+	tasks := []taskProgress{}
 
 	for rows.Next() {
-		var taskId string
-		var status string
-		var solutionText string
-		var attemptsCount int
-		if err := rows.Scan(&taskId, &status, &solutionText, &attemptsCount); err != nil {
-			Logger.WithFields(log.Fields{
+		var p taskProgress
+		if err := rows.Scan(&p.TaskId, &p.Status, &p.SolutionText, &p.attemptsCount, &p.Revision); err != nil {
+			rows.Close()
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_old": userIdOld,
 				"error":       err.Error(),
 			}).Warning("Couldn't get row from task_progress")
 			return -1
 		}
 
+		tasks = append(tasks, p)
+	}
+	rows.Close()
+
+	for _, p := range tasks {
 		query = `
-			INSERT INTO 
-			task_progress(user_id, task_id, status, solution_text, attempts_count)
-			VALUES($1, $2, $3, $4, $5)
+			INSERT INTO
+			task_progress(user_id, task_id, status, solution_text, attempts_count, revision)
+			VALUES($1, $2, $3, $4, $5, $6)
 			ON CONFLICT ON CONSTRAINT unique_user_task_id
-			DO UPDATE SET 
+			DO UPDATE SET
 			status = max_edu_status(EXCLUDED.status, task_progress.status),
 			attempts_count = task_progress.attempts_count + EXCLUDED.attempts_count,
-			solution_text = best_solution(EXCLUDED.status, EXCLUDED.solution_text, task_progress.status, task_progress.solution_text)
+			solution_text = best_solution(EXCLUDED.status, EXCLUDED.solution_text, task_progress.status, task_progress.solution_text),
+			revision = CASE WHEN task_progress.status = 'completed' AND EXCLUDED.status <> 'completed'
+				THEN task_progress.revision ELSE EXCLUDED.revision END
 		`
-		_, err := tx.ExecContext(ctx, query, userIdCur, taskId, status, solutionText, attemptsCount)
+		_, err := tx.Exec(ctx, query, userIdCur, p.TaskId, p.Status, p.SolutionText, p.attemptsCount, p.Revision)
 		if err != nil {
-			Logger.WithFields(log.Fields{
+			Logger.WithContext(ctx).WithFields(log.Fields{
 				"user_id_cur": userIdCur,
-				"task_id":     taskId,
-				"status":      status,
+				"task_id":     p.TaskId,
+				"status":      p.Status,
 				"db_error":    err.Error(),
 			}).Error("Couldn't insert into task_progress for user")
 			return -1
@@ -1210,9 +1142,9 @@ func MergeUserTasks(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld in
 	}
 
 	query = `DELETE FROM task_progress WHERE user_id = $1`
-	_, err = tx.ExecContext(ctx, query, userIdOld)
+	_, err = tx.Exec(ctx, query, userIdOld)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"user_id_old": userIdOld,
 			"db_error":    err.Error(),
 		}).Error("Couldn't delete task records for user")
@@ -1222,1586 +1154,248 @@ func MergeUserTasks(tx *sql.Tx, ctx context.Context, userIdCur int, userIdOld in
 	return 0
 }
 
-func SplitUsers(userIdCur int, userIdNew int) int {
-	ctx := context.Background()
-	tx, err := DB.BeginTx(ctx, nil)
-
+func (s *PostgresStore) SplitUsers(ctx context.Context, userIdCur int, userIdNew int) error {
+	defer observeQuery("split_users")()
+	defer s.markWrite(strconv.Itoa(userIdCur), strconv.Itoa(userIdNew))
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_new": userIdNew,
-			"error":       err.Error(),
-		}).Error("/split_users [SplitUsers()]: couldn't start transaction")
-		return -1
+		return err
 	}
 
 	// Split courses
-	query := `INSERT INTO course_progress(user_id, course_id, status) 
+	query := `INSERT INTO course_progress(user_id, course_id, status)
 	SELECT $2, course_id, status FROM course_progress WHERE user_id = $1`
 
-	_, err = tx.ExecContext(ctx, query, userIdCur, userIdNew)
-
+	_, err = tx.Exec(ctx, query, userIdCur, userIdNew)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_new": userIdNew,
-			"error":       err.Error(),
-		}).Error("/split_users [SplitUsers()]: couldn't split course records for user")
-		return -1
+		tx.Rollback(ctx)
+		return err
 	}
 
 	// Split chapters
-	query = `INSERT INTO chapter_progress(user_id, chapter_id, status) 
+	query = `INSERT INTO chapter_progress(user_id, chapter_id, status)
 	SELECT $2, chapter_id, status FROM chapter_progress WHERE user_id = $1`
 
-	_, err = tx.ExecContext(ctx, query, userIdCur, userIdNew)
-
+	_, err = tx.Exec(ctx, query, userIdCur, userIdNew)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_new": userIdNew,
-			"db_error":    err.Error(),
-		}).Error("/split_users [SplitUsers()]: couldn't split chapter records for user")
-		return -1
+		tx.Rollback(ctx)
+		return err
 	}
 
 	// Split tasks
-	query = `INSERT INTO task_progress(user_id, task_id, status, solution_text, attempts_count) 
-	SELECT $2, task_id, status, solution_text, attempts_count FROM task_progress WHERE user_id = $1`
-
-	_, err = tx.ExecContext(ctx, query, userIdCur, userIdNew)
+	query = `INSERT INTO task_progress(user_id, task_id, status, solution_text, attempts_count, revision)
+	SELECT $2, task_id, status, solution_text, attempts_count, revision FROM task_progress WHERE user_id = $1`
 
+	_, err = tx.Exec(ctx, query, userIdCur, userIdNew)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_new": userIdNew,
-			"db_error":    err.Error(),
-		}).Error("/split_users [SplitUsers()]: couldn't split task records for user")
-		return -1
+		tx.Rollback(ctx)
+		return err
 	}
 
-	err = tx.Commit()
+	// Split summary: practice isn't split, so counters are recounted
+	query = `INSERT INTO user_course_summary(user_id, course_id, dt_last_activity)
+	SELECT $2, course_id, dt_last_activity FROM user_course_summary WHERE user_id = $1
+	ON CONFLICT ON CONSTRAINT user_course_summary_pkey DO NOTHING`
+
+	_, err = tx.Exec(ctx, query, userIdCur, userIdNew)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_new": userIdNew,
-			"db_error":    err.Error(),
-		}).Error("/split_users [SplitUsers()]: couldn't commit transaction")
-		return -1
+		tx.Rollback(ctx)
+		return err
 	}
 
-	return 0
+	if _, err = rebuildCourseSummaries(tx, ctx, userIdNew, ""); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func MergeUsers(userIdCur int, userIdOld int) int {
-	ctx := context.Background()
-	tx, err := DB.BeginTx(ctx, nil)
+// mergeUserSummaries moves last activity of old user to current one and recounts counters of current user.
+func mergeUserSummaries(tx pgx.Tx, ctx context.Context, userIdCur int, userIdOld int) error {
+	query := `
+		WITH old AS (
+			DELETE FROM user_course_summary WHERE user_id = $2 RETURNING course_id, dt_last_activity
+		)
+		INSERT INTO user_course_summary(user_id, course_id, dt_last_activity)
+		SELECT $1, course_id, dt_last_activity FROM old
+		ON CONFLICT ON CONSTRAINT user_course_summary_pkey DO UPDATE SET
+		dt_last_activity = GREATEST(user_course_summary.dt_last_activity, EXCLUDED.dt_last_activity)
+	`
+	if _, err := tx.Exec(ctx, query, userIdCur, userIdOld); err != nil {
+		return err
+	}
+
+	_, err := rebuildCourseSummaries(tx, ctx, userIdCur, "")
+	return err
+}
 
+func (s *PostgresStore) MergeUsers(ctx context.Context, userIdCur int, userIdOld int) error {
+	defer observeQuery("merge_users")()
+	defer s.markWrite(strconv.Itoa(userIdCur), strconv.Itoa(userIdOld))
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_old": userIdOld,
-			"error":       err.Error(),
-		}).Error("/merge_users [MergeUsers()]: couldn't start transaction")
-		return -1
+		return err
 	}
 
-	if MergeUserCourses(tx, ctx, userIdCur, userIdOld) != 0 {
-		tx.Rollback()
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_old": userIdOld,
-		}).Error("/merge_users [MergeUsers()]: couldn't merge user courses")
-		return -1
+	if mergeUserCourses(tx, ctx, userIdCur, userIdOld) != 0 {
+		tx.Rollback(ctx)
+		return fmt.Errorf("couldn't merge user courses")
 	}
 
-	if MergeUserChapters(tx, ctx, userIdCur, userIdOld) != 0 {
-		tx.Rollback()
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_old": userIdOld,
-		}).Error("/merge_users [MergeUsers()]: couldn't merge user chapters")
-		return -1
+	if mergeUserChapters(tx, ctx, userIdCur, userIdOld) != 0 {
+		tx.Rollback(ctx)
+		return fmt.Errorf("couldn't merge user chapters")
 	}
 
-	if MergeUserTasks(tx, ctx, userIdCur, userIdOld) != 0 {
-		tx.Rollback()
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_old": userIdOld,
-		}).Error("/merge_users [MergeUsers()]: couldn't merge user tasks")
-		return -1
+	if mergeUserTasks(tx, ctx, userIdCur, userIdOld) != 0 {
+		tx.Rollback(ctx)
+		return fmt.Errorf("couldn't merge user tasks")
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": userIdCur,
-			"user_id_old": userIdOld,
-			"error":       err.Error(),
-		}).Error("/merge_users [MergeUsers()]: couldn't commit transaction")
-		return -1
+	if err = mergeUserSummaries(tx, ctx, userIdCur, userIdOld); err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("couldn't merge user summaries: %w", err)
 	}
 
-	return 0
+	return tx.Commit(ctx)
 }
 
-func GetTaskForUser(userId string, taskId string) (TaskForUser, error) {
+func (s *PostgresStore) HasEntitlement(ctx context.Context, userId string, courseId string) (bool, error) {
+	defer observeQuery("has_entitlement")()
 	query := `
-	SELECT status, solution_text FROM task_progress WHERE user_id = $1 and task_id = $2
+		SELECT COUNT(*) FROM entitlements
+		WHERE user_id=$1 AND course_id=$2 AND (dt_expire IS NULL OR dt_expire > Now())
 	`
-	rows, err := DB.Query(query, userId, taskId)
-	if err != nil {
-		return TaskForUser{}, err
-	}
-
-	var task TaskForUser
-	task.TaskId = taskId
-	task.Status = "not_started"
-
-	defer rows.Close()
-
-	for rows.Next() {
-		if err := rows.Scan(&task.Status, &task.UserCode); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id": userId,
-				"task_id": taskId,
-				"error":   err.Error(),
-			}).Error("Couldn't parse row from task_progress selection for user")
-			return TaskForUser{}, err
-		}
-	}
+	var count int
 
-	return task, nil
+	// Access is granted by billing backend through any instance: replica may not see fresh grant
+	row := s.db.QueryRow(ctx, query, userId, courseId)
+	err := row.Scan(&count)
+	return count > 0, err
 }
 
-func AreAllChaptersInCourseCompleted(userId string, courseId string) (bool, error) {
-	query := `
-	SELECT 
-	(SELECT count(*) FROM chapters WHERE course_id = $1) AS chapters_total,
-	(SELECT count(*) FROM chapter_progress WHERE chapter_id like CONCAT($1, '_chapter%') and user_id = $2 and status = 'completed') AS chapters_completed
+func (s *PostgresStore) GrantEntitlement(ctx context.Context, opts OptionsEntitlement) error {
+	defer observeQuery("grant_entitlement")()
+	defer s.markWrite(strconv.Itoa(opts.UserId))
+	const query = `
+		INSERT INTO entitlements(user_id, course_id, dt_expire, source)
+		VALUES($1, $2, $3, $4)
+		ON CONFLICT ON CONSTRAINT unique_user_entitlement_id
+		DO UPDATE SET
+		dt_grant = Now(),
+		dt_expire = EXCLUDED.dt_expire,
+		source = EXCLUDED.source
 	`
-	rows, err := DB.Query(query, courseId, userId)
-	if err != nil {
-		return false, err
-	}
-
-	chaptersTotal := 0
-	chaptersCompleted := 0
-
-	defer rows.Close()
-
-	for rows.Next() {
-		if err := rows.Scan(&chaptersTotal, &chaptersCompleted); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id":   userId,
-				"course_id": courseId,
-				"error":     err.Error(),
-			}).Warning("AreAllChaptersInCourseCompleted: couldn't parse response from postgres for user")
-			return false, err
-		}
-	}
+	_, err := s.db.Exec(ctx, query, opts.UserId, opts.CourseId, opts.DtExpire, opts.Source)
+	return err
+}
 
-	Logger.WithFields(log.Fields{
-		"user_id":            userId,
-		"course_id":          courseId,
-		"chapters_total":     chaptersTotal,
-		"chapters_completed": chaptersCompleted,
-	}).Info("AreAllChaptersInCourseCompleted: retrieved stats")
-	return chaptersTotal == chaptersCompleted, nil
+func (s *PostgresStore) RevokeEntitlement(ctx context.Context, opts OptionsEntitlement) error {
+	defer observeQuery("revoke_entitlement")()
+	defer s.markWrite(strconv.Itoa(opts.UserId))
+	const query = `
+		DELETE FROM entitlements WHERE user_id=$1 AND course_id=$2
+	`
+	_, err := s.db.Exec(ctx, query, opts.UserId, opts.CourseId)
+	return err
 }
 
-func GetPracticeProjects(userId string, courseId string) []PracticeProject {
+func (s *PostgresStore) GetPendingTaskChanges(ctx context.Context, courseId string, taskId string) ([]TaskChange, error) {
+	defer observeQuery("get_pending_task_changes")()
 	query := `
-    SELECT practice.project_id, title,
-        (CASE WHEN practice_progress.status IS NULL THEN 'not_started' ELSE practice_progress.status::varchar(40) END) as status
-        FROM practice 
-        LEFT JOIN practice_progress 
-        ON practice_progress.user_id=$2 AND practice_progress.project_id=practice.project_id
-		WHERE practice.course_id=$1
-        ORDER BY practice.chapter_id
+		SELECT task_changes.task_id, old_revision, new_revision, policy, dt_change
+		FROM task_changes
+		INNER JOIN tasks ON tasks.task_id = task_changes.task_id AND tasks.revision = task_changes.new_revision
+		INNER JOIN chapters ON chapters.chapter_id = tasks.chapter_id
+		WHERE policy = 'pending' AND chapters.course_id = $1 AND ($2 = '' OR task_changes.task_id = $2)
+		ORDER BY task_changes.task_id
 	`
-	rows, err := DB.Query(query, courseId, userId)
+	changes := []TaskChange{}
+
+	rows, err := s.db.Query(ctx, query, courseId, taskId)
 	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id":   userId,
-			"course_id": courseId,
-			"error":     err.Error(),
-		}).Error("Couldn't query from GetUnfinishedPracticeProjects selection for user")
-		return []PracticeProject{}
+		return changes, err
 	}
 
 	defer rows.Close()
 
-	projects := []PracticeProject{}
-
 	for rows.Next() {
-		var project PracticeProject
-
-		if err := rows.Scan(&project.ProjectId, &project.Title, &project.Status); err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id":   userId,
-				"course_id": courseId,
-				"error":     err.Error(),
-			}).Error("Couldn't parse row from GetUnfinishedPracticeProjects selection for user")
-			return []PracticeProject{}
+		var change TaskChange
+		if err := rows.Scan(&change.TaskId, &change.OldRevision, &change.NewRevision, &change.Policy, &change.DtChange); err != nil {
+			return []TaskChange{}, err
 		}
 
-		projects = append(projects, project)
+		changes = append(changes, change)
 	}
 
-	return projects
+	return changes, rows.Err()
 }
 
-func HandleGetCourses(w http.ResponseWriter, r *http.Request) {
-	countGetCoursesTotal.Inc()
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-
-	if err != nil {
-		countGetCoursesErrClient.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"status":  opts.Status,
-			"error":   err.Error(),
-		}).Warning("/get_courses: couldn't parse request")
-		return
-	}
-
-	var courses []CourseForUser
-
-	// Case when user is not authorized
-	if len(opts.userId) == 0 {
-		countGetCoursesAnonym.Inc()
-		courses = GetCourses()
-	} else {
-		countGetCoursesAuthorized.Inc()
-		if opts.Status == "all" {
-			courses = GetCoursesForUser(opts.userId)
-		} else {
-			courses = GetCoursesForUserByStatus(opts.userId, opts.Status)
+func applyTaskChange(tx pgx.Tx, ctx context.Context, change TaskChange, courseId string, policy string) error {
+	switch policy {
+	case RevisionPolicyMigrate:
+		query := `
+			UPDATE task_progress SET revision = $2 WHERE task_id = $1 AND revision <> $2 AND revision <> ''
+		`
+		if _, err := tx.Exec(ctx, query, change.TaskId, change.NewRevision); err != nil {
+			return err
 		}
-	}
 
-	for i := 0; i < len(courses); i++ {
-		descr, _ := ReadTextFile(filepath.Join(courses[i].Path, "description.md"))
-		courses[i].Description = descr
-	}
-
-	if len(courses) == 0 {
-		countGetCoursesErrServer.Inc()
+	case RevisionPolicyInvalidate:
+		// Chapter and course completed by users are not completed anymore
+		query := `
+			WITH invalidated AS (
+				UPDATE task_progress SET status = 'in_progress'
+				WHERE task_id = $1 AND revision <> $2 AND revision <> '' AND status = 'completed'
+				RETURNING user_id
+			), chapters_invalidated AS (
+				UPDATE chapter_progress SET status = 'in_progress'
+				WHERE chapter_id = (SELECT chapter_id FROM tasks WHERE task_id = $1)
+				AND status = 'completed' AND user_id IN (SELECT user_id FROM invalidated)
+				RETURNING user_id
+			), summary AS (
+				UPDATE user_course_summary SET
+				tasks_completed = tasks_completed - 1,
+				chapters_completed = chapters_completed -
+					(CASE WHEN user_id IN (SELECT user_id FROM chapters_invalidated) THEN 1 ELSE 0 END)
+				WHERE course_id = $3 AND user_id IN (SELECT user_id FROM invalidated)
+			)
+			UPDATE course_progress SET status = 'in_progress'
+			WHERE course_id = $3 AND status = 'completed' AND user_id IN (SELECT user_id FROM invalidated)
+		`
+		if _, err := tx.Exec(ctx, query, change.TaskId, change.NewRevision, courseId); err != nil {
+			return err
+		}
 	}
 
-	Logger.WithFields(log.Fields{
-		"user_id":               opts.userId,
-		"status":                opts.Status,
-		"retrieved_courses_len": len(courses),
-	}).Info("/get_courses: completed")
-
-	json.NewEncoder(w).Encode(courses)
+	// Previous pending changes of task are covered by the latest one
+	query := `
+		UPDATE task_changes SET policy = $2, dt_apply = Now() WHERE task_id = $1 AND policy = 'pending'
+	`
+	_, err := tx.Exec(ctx, query, change.TaskId, policy)
+	return err
 }
 
-func HandleUpdateCourseProgress(w http.ResponseWriter, r *http.Request) {
-	countUpdateCourseProgressTotal.Inc()
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
+func (s *PostgresStore) ApplyTaskChanges(ctx context.Context, opts OptionsTaskChanges) (int, error) {
+	defer observeQuery("apply_task_changes")()
+	changes, err := s.GetPendingTaskChanges(ctx, opts.CourseId, opts.TaskId)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
 
-	opts, err := ParseOptions(r)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		countUpdateCourseProgressClientError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"status":    opts.Status,
-			"error":     err.Error(),
-		}).Warning("/update_course_progress: couldn't parse request")
-		return
+		return 0, err
 	}
 
-	if len(opts.userId) == 0 || len(opts.Status) == 0 || len(opts.CourseId) == 0 {
-		countUpdateCourseProgressClientError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Required fields are not set in request",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"status":    opts.Status,
-			"error":     err.Error(),
-		}).Warning("/update_course_progress: required fields not set in request")
-		return
-	}
-
-	curStatus, err := GetCourseProgressForUser(opts.CourseId, opts.userId)
-	if err != nil {
-		countUpdateCourseProgressServerError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user progress on course",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"status":    opts.Status,
-			"error":     err.Error(),
-		}).Warning("/update_course_progress: couldn't get progress on course for user")
-		return
-	}
-
-	if !IsNewStatusValid(curStatus, opts.Status) {
-		if opts.Status == "in_progress" && (curStatus == "in_progress" || curStatus == "completed") {
-			json.NewEncoder(w).Encode(map[string]string{
-				"status": "no_action",
-			})
-
-			Logger.WithFields(log.Fields{
-				"user_id":        opts.userId,
-				"course_id":      opts.CourseId,
-				"current_status": curStatus,
-				"new_status":     opts.Status,
-			}).Info("/update_course_progress: no action")
-
-			countUpdateCourseProgressNoAction.Inc()
-			return
-
-		}
-		countUpdateCourseProgressStatusError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":          "Couldn't change status",
-			"current_status": curStatus,
-			"new_status":     opts.Status,
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":        opts.userId,
-			"course_id":      opts.CourseId,
-			"current_status": curStatus,
-			"new_status":     opts.Status,
-		}).Info("/update_course_progress: invalid course status transmission for user")
-		return
-	}
-
-	if opts.Status == "completed" {
-		isCourseCompleted, _ := AreAllChaptersInCourseCompleted(opts.userId, opts.CourseId)
-
-		if isCourseCompleted {
-			countUpdateCourseProgressOkCompleted.Inc()
-		} else {
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Not all materials in course are completed",
-			})
-
-			Logger.WithFields(log.Fields{
-				"user_id":        opts.userId,
-				"course_id":      opts.CourseId,
-				"current_status": curStatus,
-				"new_status":     opts.Status,
-			}).Info("/update_course_progress: not all materials in course are completed")
-			return
-		}
-	}
-
-	err = UpdateCourseProgressForUser(opts.CourseId, opts.Status, opts.userId)
-	if err != nil {
-		countUpdateCourseProgressServerError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't update user progress on course",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":        opts.userId,
-			"course_id":      opts.CourseId,
-			"current_status": curStatus,
-			"new_status":     opts.Status,
-			"error":          err.Error(),
-		}).Error("/update_course_progress: couldn't update user progress on course")
-		return
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":    opts.userId,
-		"course_id":  opts.CourseId,
-		"old_status": curStatus,
-		"new_status": opts.Status,
-	}).Info("/update_course_progress: completed")
-
-	countUpdateCourseProgressOk.Inc()
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-	})
-}
-
-func UpdateChapterStatus(userId string, chapterId string, status string) error {
-	const query = `
-		INSERT INTO chapter_progress(user_id, chapter_id, status) 
-		VALUES($1, $2, $3) 
-		ON CONFLICT ON CONSTRAINT unique_user_chapter_id 
-		DO UPDATE SET
-		status = EXCLUDED.status
-    `
-
-	_, err := DB.Exec(query, userId, chapterId, status)
-	return err
-}
-
-func AddUserInteraction(userId string, k string, v string) error {
-	const query = `
-		INSERT INTO user_interactions(user_id, interaction_key, interaction_val) 
-		VALUES($1, $2, $3) 
-    `
-	_, err := DB.Exec(query, userId, k, v)
-	return err
-}
-
-func HandleUpdateChapterProgress(w http.ResponseWriter, r *http.Request) {
-	countUpdateChapterProgressTotal.Inc()
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		countUpdateChapterProgressClientError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"status":     opts.Status,
-			"error":      err.Error(),
-		}).Warning("/update_chapter_progress: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 || len(opts.ChapterId) == 0 || len(opts.Status) == 0 {
-		countUpdateChapterProgressClientError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user_id, chapter_id or status",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"status":     opts.Status,
-		}).Warning("/update_chapter_progress: required fields not set in request")
-		return
-	}
-
-	curStatus, err := GetChapterProgress(opts.userId, opts.ChapterId)
-	if err != nil {
-		if err != sql.ErrNoRows {
-			countUpdateChapterProgressServerError.Inc()
-
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Couldn't get user progress on chapter",
-			})
-
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"chapter_id": opts.ChapterId,
-				"error":      err.Error(),
-			}).Warning("/update_chapter_progress: couldn't get user progress on chapter")
-			return
-		}
-
-		curStatus = "not_started"
-	}
-
-	if !IsNewStatusValid(curStatus, opts.Status) {
-		if opts.Status == "in_progress" && (curStatus == "in_progress" || curStatus == "completed") {
-			chapters := GetChaptersForUser(opts.userId, opts.CourseId)
-			ret_chapter_id := opts.ChapterId
-
-			for i := 0; i < len(chapters); i++ {
-				if chapters[i].Status == "in_progress" || chapters[i].Status == "not_started" {
-					ret_chapter_id = chapters[i].ChapterId
-					break
-				}
-			}
-
-			Logger.WithFields(log.Fields{
-				"user_id":           opts.userId,
-				"chapter_id":        opts.ChapterId,
-				"status":            opts.Status,
-				"action":            "not_changed",
-				"return_chapter_id": ret_chapter_id,
-			}).Info("/update_chapter_progress: no action")
-
-			json.NewEncoder(w).Encode(map[string]string{
-				"status":     "no_action",
-				"chapter_id": ret_chapter_id,
-				"course_id":  opts.CourseId,
-			})
-
-			countUpdateChapterProgressNoAction.Inc()
-			return
-		}
-
-		countUpdateChapterProgressStatusError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":          "Couldn't change status",
-			"current_status": curStatus,
-			"new_status":     opts.Status,
-			"chapter_id":     opts.ChapterId,
-			"course_id":      opts.CourseId,
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":        opts.userId,
-			"chapter_id":     opts.ChapterId,
-			"current_status": curStatus,
-			"new_status":     opts.Status,
-		}).Info("/update_chapter_progress: invalid chapter status transmission for user")
-		return
-	}
-
-	if opts.Status == "completed" {
-		tasks := GetTasks(opts.ChapterId, opts.userId)
-
-		for _, task := range tasks {
-			if task.Status != "completed" {
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "Not all tasks in chapter are completed",
-				})
-
-				Logger.WithFields(log.Fields{
-					"user_id":    opts.userId,
-					"chapter_id": opts.ChapterId,
-					"new_status": opts.Status,
-					"task_id":    task.TaskId,
-					"status":     task.Status,
-				}).Info("/update_chapter_progress: do nothing because not all tasks are completed")
-				return
-			}
-		}
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":    opts.userId,
-		"new_status": opts.Status,
-		"chapter_id": opts.ChapterId,
-	}).Info("/update_chapter_progress: BEFORE UPDATING IN DB")
-
-	err = UpdateChapterStatus(opts.userId, opts.ChapterId, opts.Status)
-
-	if err != nil {
-		countUpdateChapterProgressServerError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":      "Couldn't update chapter status for user",
-			"chapter_id": opts.ChapterId,
-			"course_id":  opts.CourseId,
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"old_status": curStatus,
-			"new_status": opts.Status,
-			"error":      err.Error(),
-		}).Error("/update_chapter_progress: couldn't update chapter status for user")
-		return
-	}
-
-	TryStartCourse(opts.userId, opts.CourseId)
-
-	if opts.Status == "completed" {
-		countUpdateChapterProgressOkCompleted.Inc()
-	}
-
-	countUpdateChapterProgressOk.Inc()
-
-	Logger.WithFields(log.Fields{
-		"user_id":    opts.userId,
-		"old_status": curStatus,
-		"new_status": opts.Status,
-		"chapter_id": opts.ChapterId,
-	}).Info("/update_chapter_progress: completed")
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":     "ok",
-		"chapter_id": opts.ChapterId,
-		"course_id":  opts.CourseId,
-	})
-}
-
-func HandleGetCourseDescription(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_course_description: couldn't parse request")
-		return
-	}
-
-	if len(opts.CourseId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get course_id in get_course_description",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-		}).Warning("/get_course_description: required fields not set in request")
-		return
-	}
-	path, err := GetCoursePathOnDisk(opts.CourseId)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_course_description: couldn't get path to description")
-		return
-	}
-
-	descr, _ := ReadTextFile(filepath.Join(path, "description.md"))
-
-	Logger.WithFields(log.Fields{
-		"user_id":   opts.userId,
-		"course_id": opts.CourseId,
-	}).Info("/get_course_description: completed")
-
-	body, _ := json.Marshal(map[string]string{
-		"description": descr,
-	})
-	w.Write(body)
-}
-
-func HandleGetChapters(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_chapters: couldn't parse request")
-		return
-	}
-
-	if len(opts.CourseId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get course_id in get_chapters",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-		}).Warning("/get_chapters: required fields not set in request")
-		return
-	}
-
-	// User is not authorized
-	if len(opts.userId) == 0 {
-		chapters := GetChapters(opts.CourseId)
-
-		Logger.WithFields(log.Fields{
-			"course_id": opts.CourseId,
-		}).Info("/get_chapters: completed for not authorized user")
-
-		json.NewEncoder(w).Encode(chapters)
-		return
-	}
-
-	chapters := GetChaptersForUser(opts.userId, opts.CourseId)
-
-	Logger.WithFields(log.Fields{
-		"user_id":   opts.userId,
-		"course_id": opts.CourseId,
-	}).Info("/get_chapters: completed")
-
-	json.NewEncoder(w).Encode(chapters)
-}
-
-func HandleGetCourseInfo(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_course_info: couldn't parse request")
-		return
-	}
-
-	if len(opts.CourseId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get required request params",
-		})
-
-		Logger.WithFields(log.Fields{
-			"course_id": opts.CourseId,
-		}).Warning("/get_course_info: required fields not set in request")
-		return
-	}
-
-	tags_str, err := GetCourseInfo(opts.CourseId)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": "Couldn't get course info",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_course_info: couldn't get course info")
-		return
-	}
-
-	body, _ := json.Marshal(map[string]string{
-		"tags": tags_str,
-	})
-	w.Write(body)
-}
-
-func HandlePracticeCode(w http.ResponseWriter, r *http.Request) {
-	countRunPracticeTotal.Inc()
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	var opts PracticeReq
-	opts.userId = GetUserId(r)
-
-	err := json.NewDecoder(r.Body).Decode(&opts)
-
-	if err != nil {
-		countRunPracticeErrClient.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"project_id": opts.ProjectId,
-			"course_id":  opts.CourseId,
-			"action":     opts.Action,
-			"error":      err.Error(),
-		}).Warning("/handle_practice_code: couldn't parse request")
-
-		return
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":    opts.userId,
-		"project_id": opts.ProjectId,
-		"course_id":  opts.CourseId,
-		"action":     opts.Action,
-	}).Warning("/handle_practice_code: parsed request")
-
-	if len(opts.userId) == 0 || len(opts.ProjectId) == 0 || len(opts.CourseId) == 0 {
-		countRunPracticeErrClient.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get some fields",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"project_id": opts.ProjectId,
-			"course_id":  opts.CourseId,
-			"action":     opts.Action,
-		}).Warning("/handle_practice_code: couldn't get required fields")
-		return
-	}
-
-	res := new(RunTaskResult)
-
-	if opts.Action == "save" {
-		if SavePractice(opts.userId, opts.ProjectId, opts.CourseId, opts.ProjectContents) {
-			countRunPracticeOk.Inc()
-		} else {
-			countRunPracticeErrServer.Inc()
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Couldn't save project",
-			})
-
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"project_id": opts.ProjectId,
-				"course_id":  opts.CourseId,
-				"action":     opts.Action,
-			}).Warning("/handle_practice_code: couldn't save project")
-			return
-		}
-	} else {
-		bodyReq, err := json.Marshal(opts)
-
-		if err != nil {
-			countRunPracticeErrServer.Inc()
-
-			body, _ := json.Marshal(map[string]string{
-				"error": "Couldn't communicate with tasks runner",
-			})
-			w.Write(body)
-
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"project_id": opts.ProjectId,
-				"action":     opts.Action,
-				"error":      err.Error(),
-			}).Error("/handle_practice_code: error creating json for watchman")
-			return
-		}
-
-		bodyResp, err := sendRequestToWatchman(addrWatchmanPractice, &bodyReq)
-
+	for _, change := range changes {
+		err = applyTaskChange(tx, ctx, change, opts.CourseId, opts.Policy)
 		if err != nil {
-			countRunPracticeErrServer.Inc()
-
-			body, _ := json.Marshal(map[string]string{
-				"error": "Couldn't communicate with tasks runner",
-			})
-			w.Write(body)
-
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"project_id": opts.ProjectId,
-				"action":     opts.Action,
-				"error":      err.Error(),
-			}).Error("/handle_practice_code: error communicating watchman")
-			return
+			tx.Rollback(ctx)
+			return 0, err
 		}
-
-		err = json.Unmarshal(bodyResp, &res)
-
-		if err != nil {
-			countRunPracticeErrServer.Inc()
-
-			body, _ := json.Marshal(map[string]string{
-				"error": "Couldn't communicate with tasks runner",
-			})
-			w.Write(body)
-
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"project_id": opts.ProjectId,
-				"action":     opts.Action,
-				"error":      err.Error(),
-			}).Error("/handle_practice_code: error extracting json from watchman resp")
-			return
-		}
-
-		if UpdateStatusPractice(opts.userId, opts.ProjectId, opts.CourseId,
-			opts.Action == "test" && res.StatusCode == 0, opts.ProjectContents) {
-			countRunPracticeOk.Inc()
-		} else {
-			countRunPracticeErrServer.Inc()
-		}
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":     opts.userId,
-		"project_id":  opts.ProjectId,
-		"course_id":   opts.CourseId,
-		"action":      opts.Action,
-		"status_code": res.StatusCode,
-	}).Info("/handle_practice_code: completed")
-
-	json.NewEncoder(w).Encode(res)
-}
-
-func HandleGetPractice(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	var opts Options
-	opts.userId = GetUserId(r)
-
-	err := json.NewDecoder(r.Body).Decode(&opts)
-
-	if err != nil {
-		//countGetChapterClientError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"task_id":   opts.TaskId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_practice: couldn't parse request")
-		return
 	}
 
-	if len(opts.CourseId) == 0 || len(opts.TaskId) == 0 {
-		//countGetChapterClientError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get required request params",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"task_id":   opts.TaskId,
-			"course_id": opts.CourseId,
-		}).Warning("/get_practice: required fields not set in request")
-		return
-	}
-
-	var practice Practice
-
-	if len(opts.userId) > 0 {
-		practice, err = GetPracticeForUser(opts)
-	} else {
-		practice, err = GetPractice(opts)
-	}
-
-	if err != nil {
-		//countGetChapterServerError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": "Couldn't get practice for user",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"task_id":   opts.TaskId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Error("/get_practice: couldn't get practice for user")
-		return
-	}
-
-	practice.NextChapterId, _ = GetNextChapterId(opts.CourseId, practice.ChapterId, false)
-
-	pathToText := filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "text.md")
-	practice.ProjectDescription, err = ReadTextFile(pathToText)
-
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": "Couldn't get practice for user",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"task_id":   opts.TaskId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Error("/get_practice: couldn't get practice text for user")
-		return
-	}
-
-	pathToHint := filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "hint.md")
-	practice.ProjectHint, err = ReadTextFile(pathToHint)
-
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": "Couldn't get practice hint for user",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"task_id":   opts.TaskId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Error("/get_practice: couldn't get practice hint for user")
-		return
-	}
-
-	practice.ProjectPath = filepath.Join(RootCourses, opts.CourseId, "practice", opts.TaskId, "project")
-
-	practice.Tags, err = GetCourseInfo(opts.CourseId)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": "Couldn't get course info",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_practice: couldn't get course info")
-		return
-	}
-
-	// countGetChapterOk.Inc()
-	Logger.WithFields(log.Fields{
-		"user_id":         opts.userId,
-		"course_id":       opts.CourseId,
-		"task_id":         opts.TaskId,
-		"next_chapter_id": practice.NextChapterId,
-	}).Info("/get_practice: completed")
-
-	json.NewEncoder(w).Encode(practice)
-
-}
-
-func HandleGetChapter(w http.ResponseWriter, r *http.Request) {
-	countGetChapterTotal.Inc()
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		countGetChapterClientError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"course_id":  opts.CourseId,
-			"error":      err.Error(),
-		}).Warning("/get_chapter: couldn't parse request")
-		return
-	}
-
-	if len(opts.CourseId) == 0 && len(opts.ChapterId) == 0 {
-		countGetChapterClientError.Inc()
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get required request params",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"course_id":  opts.CourseId,
-		}).Warning("/get_chapter: required fields not set in request")
-		return
-	}
-
-	chapter, err := GetChapterForUser(opts)
-
-	if err != nil {
-		countGetChapterServerError.Inc()
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Couldn't get %s chapter for user %s (chapter %s): %s",
-				opts.CourseId, opts.userId, opts.ChapterId, err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"course_id":  opts.CourseId,
-			"error":      err.Error(),
-		}).Error("/get_chapter: couldn't get chapter for user")
-		return
-	}
-
-	chapter.NextChapterId, _ = GetNextChapterId(opts.CourseId, opts.ChapterId, true)
-	if len(opts.userId) == 0 {
-		countGetChapterAnonymous.Inc()
-		chapter.CourseStatus = "not_started"
-	} else {
-		chapter.CourseStatus, _ = GetCourseProgressForUser(opts.CourseId, opts.userId)
-	}
-
-	if !strings.HasSuffix(chapter.ChapterId, "0") { // subchapter
-		chapter.ParentChapterId = chapter.ChapterId[:len(chapter.ChapterId)-1] + string('0')
-		chapter.ParentChapterTitle, _ = GetChapterTitle(chapter.ParentChapterId)
-	}
-
-	countGetChapterOk.Inc()
-	Logger.WithFields(log.Fields{
-		"user_id":         opts.userId,
-		"course_id":       opts.CourseId,
-		"chapter_id":      opts.ChapterId,
-		"next_chapter_id": chapter.NextChapterId,
-	}).Info("/get_chapter: completed")
-
-	json.NewEncoder(w).Encode(chapter)
-}
-
-func HandleGetProgress(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"error":      err.Error(),
-		}).Warning("/get_progress: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 || len(opts.ChapterId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get required request params",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-		}).Warning("/get_progress: required fields not set in request")
-		return
-	}
-
-	chapterStatus, err := GetChapterProgress(opts.userId, opts.ChapterId)
-
-	if err != nil && err != sql.ErrNoRows {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get chapter progress",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"chapter_id": opts.ChapterId,
-			"error":      err.Error(),
-		}).Warning("/get_progress: couldn't get chapter progress (sql query)")
-		return
-	}
-
-	var userProgress UserProgress
-
-	tasks := GetTasks(opts.ChapterId, opts.userId)
-
-	for _, task := range tasks {
-		if task.Status != "completed" {
-			userProgress.NotCompletedTaskIds = append(userProgress.NotCompletedTaskIds, task.TaskId)
-		}
-	}
-
-	if len(userProgress.NotCompletedTaskIds) > 0 && chapterStatus != "completed" {
-		userProgress.StatusOnChapter = "chapter_not_completed"
-	} else {
-		userProgress.StatusOnChapter = "chapter_completed"
-	}
-
-	nextChapterId, err := GetNextChapterId(opts.CourseId, opts.ChapterId, true)
-	if err != nil && err != sql.ErrNoRows {
-		Logger.WithFields(log.Fields{
-			"user_id":    opts.userId,
-			"course_id":  opts.CourseId,
-			"chapter_id": opts.ChapterId,
-			"error":      err.Error(),
-		}).Error("/get_progress: couldn't get user progress on chapter")
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get progress",
-		})
-		return
-	}
-
-	userProgress.NextChapterId = nextChapterId
-	userProgress.CourseId = opts.CourseId
-
-	if userProgress.StatusOnChapter == "chapter_completed" {
-		userProgress.IsCourseCompleted, err = AreAllChaptersInCourseCompleted(opts.userId, opts.CourseId)
-
-		if err != nil {
-			Logger.WithFields(log.Fields{
-				"user_id":    opts.userId,
-				"course_id":  opts.CourseId,
-				"chapter_id": opts.ChapterId,
-				"error":      err.Error(),
-			}).Error("/get_progress: couldn't check if all chapters are completed")
-
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Couldn't get progress",
-			})
-			return
-		}
-
-		if userProgress.IsCourseCompleted {
-			userProgress.PracticeProjects = GetPracticeProjects(opts.userId, opts.CourseId)
-		}
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":             opts.userId,
-		"chapter_id":          opts.ChapterId,
-		"course_id":           opts.CourseId,
-		"chapter_status":      userProgress.StatusOnChapter,
-		"next_chapter_id":     userProgress.NextChapterId,
-		"is_course_completed": userProgress.IsCourseCompleted,
-		"not_completed_tasks": userProgress.NotCompletedTaskIds,
-	}).Info("/get_progress: completed")
-
-	json.NewEncoder(w).Encode(userProgress)
-}
-
-func HandleCoursesStats(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"error":   err.Error(),
-		}).Warning("/courses_stats: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user_id",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-		}).Warning("/courses_stats: required fields not set in request")
-		return
-	}
-
-	courseStatuses := GetCourseStatuses(opts.userId)
-
-	Logger.WithFields(log.Fields{
-		"user_id":             opts.userId,
-		"course_statuses_len": len(courseStatuses),
-	}).Info("/courses_stats: completed")
-
-	json.NewEncoder(w).Encode(courseStatuses)
-}
-
-func HandleCourseStats(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"error":   err.Error(),
-		}).Warning("/course_stats: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 || len(opts.CourseId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user_id or course_id",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-		}).Warning("/course_stats: required fields not set in request")
-		return
-	}
-
-	courseStatus := GetCourseStatus(opts.userId, opts.CourseId)
-
-	Logger.WithFields(log.Fields{
-		"user_id": opts.userId,
-	}).Info("/course_stats: completed")
-
-	json.NewEncoder(w).Encode(courseStatus)
-}
-
-func HandleMergeUsers(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptionsTg(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id_cur": opts.UserIdCur,
-			"user_id_old": opts.UserIdOld,
-			"error":       err.Error(),
-		}).Warning("/merge_users: couldn't parse request")
-		return
-	}
-
-	status := MergeUsers(opts.UserIdCur, opts.UserIdOld)
-
-	body, _ := json.Marshal(map[string]int{
-		"status": status,
-	})
-
-	if status == 0 {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": opts.UserIdCur,
-			"user_id_old": opts.UserIdOld,
-		}).Info("/merge_users: completed")
-	}
-
-	w.Write(body)
-}
-
-func HandleSplitUsers(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptionsTg(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id_cur": opts.UserIdCur,
-			"user_id_new": opts.UserIdNew,
-			"error":       err.Error(),
-		}).Warning("/split_users: couldn't parse request")
-		return
-	}
-
-	status := SplitUsers(opts.UserIdCur, opts.UserIdNew)
-	body, _ := json.Marshal(map[string]int{
-		"status": status,
-	})
-
-	if status == 0 {
-		Logger.WithFields(log.Fields{
-			"user_id_cur": opts.UserIdCur,
-			"user_id_new": opts.UserIdNew,
-		}).Info("/split_users: completed")
-	}
-
-	w.Write(body)
-}
-
-func HandleGetTask(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"task_id": opts.TaskId,
-			"error":   err.Error(),
-		}).Warning("/get_task: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 || len(opts.TaskId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user_id or task_id",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"task_id": opts.TaskId,
-		}).Warning("/get_task: required fields not set in request")
-		return
-	}
-
-	task, err := GetTaskForUser(opts.userId, opts.TaskId)
-	if err != nil {
-		Logger.WithFields(log.Fields{
-			"user_id": opts.userId,
-			"task_id": opts.TaskId,
-			"error":   err.Error(),
-		}).Error("/get_task: couldn't get task details")
-
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Couldn't get task details for: %s", opts.TaskId),
-		})
-		w.Write(body)
-		return
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id": opts.userId,
-		"task_id": opts.TaskId,
-	}).Warning("/get_task: completed")
-
-	json.NewEncoder(w).Encode(task)
-}
-
-func HandleGetActiveChapter(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-type", "application/json")
-
-	opts, err := ParseOptions(r)
-	if err != nil {
-		body, _ := json.Marshal(map[string]string{
-			"error": fmt.Sprintf("Invalid request: %s", err),
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-			"error":     err.Error(),
-		}).Warning("/get_active_chapter: couldn't parse request")
-		return
-	}
-
-	if len(opts.userId) == 0 || len(opts.CourseId) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Couldn't get user_id or course_id",
-		})
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-		}).Warning("/get_active_chapter: required fields not set in request")
-		return
-	}
-
-	courses := GetCoursesForUserByStatus(opts.userId, "in_progress")
-	hasAccess := false
-	for i := 0; i < len(courses); i++ {
-		if courses[i].CourseId == opts.CourseId {
-			hasAccess = true
-			break
-		}
-	}
-
-	if !hasAccess {
-		body, _ := json.Marshal(map[string]string{
-			"error": "Course is not in state 'in_progress' for user",
-		})
-		w.Write(body)
-
-		Logger.WithFields(log.Fields{
-			"user_id":   opts.userId,
-			"course_id": opts.CourseId,
-		}).Warning("/get_active_chapter: course is not in state 'in_progress' for user")
-		return
-	}
-
-	chapters := GetChaptersForUser(opts.userId, opts.CourseId)
-
-	for i := 0; i < len(chapters); i++ {
-		if chapters[i].Status == "in_progress" || chapters[i].Status == "not_started" {
-			opts.ChapterId = chapters[i].ChapterId
-
-			var chapter ChapterContent
-			chapter.IsPractice = false
-
-			prefixLen := len(opts.ChapterId) - 4 // NNNN
-			if prefixLen < len(opts.ChapterId) {
-				_, err := strconv.Atoi(opts.ChapterId[prefixLen:])
-				// Chapter is practice: it is not in format coursename_NNNN
-				if err != nil {
-					chapter.ChapterId = opts.ChapterId
-					chapter.IsPractice = true
-				}
-			}
-
-			if !chapter.IsPractice {
-				chapter, err = GetChapterForUser(opts)
-				if err != nil {
-					body, _ := json.Marshal(map[string]string{
-						"error": fmt.Sprintf("Couldn't get chapter for user: %s", err),
-					})
-					w.Write(body)
-
-					Logger.WithFields(log.Fields{
-						"user_id":    opts.userId,
-						"course_id":  opts.CourseId,
-						"chapter_id": opts.ChapterId,
-						"error":      err.Error(),
-					}).Error("/get_active_chapter: couldn't get chapter for user")
-					return
-				}
-			}
-
-			Logger.WithFields(log.Fields{
-				"user_id":             opts.userId,
-				"course_id":           opts.CourseId,
-				"chapter_id":          opts.ChapterId,
-				"returned_chapter_id": chapter.ChapterId,
-				"is_practice":         chapter.IsPractice,
-			}).Info("/get_active_chapter: completed")
-
-			json.NewEncoder(w).Encode(chapter)
-			return
-		}
-	}
-
-	Logger.WithFields(log.Fields{
-		"user_id":   opts.userId,
-		"course_id": opts.CourseId,
-	}).Info("/get_active_chapter: completed with no active chapter for user")
-
-	body, _ := json.Marshal(map[string]string{
-		"error": "No active chapter for user",
-	})
-	w.Write(body)
+	return len(changes), tx.Commit(ctx)
 }
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Unlock rules are declared in tags.json of the course:
+// "prerequisites": {
+//     "python_chapter_0050": ["python_chapter_0030", "python_chapter_0040"],
+//     "python_chapter_0100_project": ["python_chapter_0090"]
+// }
+// Chapter or practice project from the key is blocked until user completes all materials from the value.
+
+type UnlockRules map[string][]string
+
+// Status of locked material in response
+const MaterialLockStatus = "blocked"
+
+type MaterialLock struct {
+	Error       string   `json:"error"`
+	Status      string   `json:"status"`
+	ItemId      string   `json:"item_id"`
+	RequiredIds []string `json:"required_ids"`
+	Reason      string   `json:"reason"`
+}
+
+func ParseUnlockRules(tags string) (UnlockRules, error) {
+	var courseTags struct {
+		Prerequisites UnlockRules `json:"prerequisites"`
+	}
+
+	if len(tags) == 0 {
+		return UnlockRules{}, nil
+	}
+
+	err := json.Unmarshal([]byte(tags), &courseTags)
+	if err != nil {
+		return UnlockRules{}, err
+	}
+
+	if courseTags.Prerequisites == nil {
+		return UnlockRules{}, nil
+	}
+
+	return courseTags.Prerequisites, nil
+}
+
+// GetMissingPrerequisites returns ids of materials which user must complete to unlock item.
+func (rules UnlockRules) GetMissingPrerequisites(itemId string, completedIds map[string]bool) []string {
+	missing := []string{}
+
+	for _, requiredId := range rules[itemId] {
+		if !completedIds[requiredId] {
+			missing = append(missing, requiredId)
+		}
+	}
+
+	return missing
+}
+
+// ApplyToChapters marks chapters and projects which are not completed and not unlocked yet as blocked.
+func (rules UnlockRules) ApplyToChapters(chapters []ChapterForUser) {
+	if len(rules) == 0 {
+		return
+	}
+
+	completedIds := make(map[string]bool, len(chapters))
+	for _, chapter := range chapters {
+		if chapter.Status == "completed" {
+			completedIds[chapter.ChapterId] = true
+		}
+	}
+
+	for i := range chapters {
+		if chapters[i].Status == "completed" {
+			continue
+		}
+
+		missing := rules.GetMissingPrerequisites(chapters[i].ChapterId, completedIds)
+		if len(missing) > 0 {
+			chapters[i].Status = "blocked"
+			chapters[i].LockedBy = missing
+		}
+	}
+}
+
+func NewMaterialLock(itemId string, missing []string) MaterialLock {
+	what := "chapter"
+	if IsPracticeId(itemId) {
+		what = "project"
+	}
+
+	return MaterialLock{
+		Error:       "Material is locked",
+		Status:      MaterialLockStatus,
+		ItemId:      itemId,
+		RequiredIds: missing,
+		Reason:      "To unlock " + what + " " + itemId + " complete: " + strings.Join(missing, ", "),
+	}
+}
+
+// GetMaterialLock checks if chapter or practice project is locked for user by unlock rules of the course.
+// Returns nil if material is available.
+func (s *Server) GetMaterialLock(ctx context.Context, userId string, courseId string, itemId string) (*MaterialLock, error) {
+	tags, err := GetCourseInfo(courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := ParseUnlockRules(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules[itemId]) == 0 {
+		return nil, nil
+	}
+
+	completedIds, err := s.GetCompletedMaterials(ctx, userId, courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Material completed before rules were introduced stays available
+	if completedIds[itemId] {
+		return nil, nil
+	}
+
+	missing := rules.GetMissingPrerequisites(itemId, completedIds)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	lock := NewMaterialLock(itemId, missing)
+	return &lock, nil
+}
+
+// ApplyUnlockRules marks locked chapters of the course as blocked for user.
+func ApplyUnlockRules(courseId string, chapters []ChapterForUser) error {
+	tags, err := GetCourseInfo(courseId)
+	if err != nil {
+		return err
+	}
+
+	rules, err := ParseUnlockRules(tags)
+	if err != nil {
+		return err
+	}
+
+	rules.ApplyToChapters(chapters)
+	return nil
+}
+
+// GetChaptersForUserWithRules returns chapters of the course for user with locked materials marked as blocked.
+// Chapters aren't returned if progress or unlock rules aren't available: locked materials mustn't look open.
+func (s *Server) GetChaptersForUserWithRules(ctx context.Context, userId string, courseId string) ([]ChapterForUser, error) {
+	chapters, err := s.GetChaptersForUser(ctx, userId, courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyUnlockRules(courseId, chapters)
+	if err != nil {
+		return nil, err
+	}
+
+	return chapters, nil
+}
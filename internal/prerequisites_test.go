@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+const prerequisitesTestTags = `{
+	"title": "Python",
+	"prerequisites": {
+		"python_chapter_0030": ["python_chapter_0010", "python_chapter_0020"],
+		"python_chapter_0030_project": ["python_chapter_0030"]
+	}
+}`
+
+func TestParseUnlockRules(t *testing.T) {
+	rules, err := ParseUnlockRules(prerequisitesTestTags)
+	if err != nil {
+		t.Fatalf(`Couldn't parse unlock rules: %v`, err)
+	}
+
+	if len(rules) != 2 || len(rules["python_chapter_0030"]) != 2 {
+		t.Fatalf(`Wrong unlock rules: %v`, rules)
+	}
+
+	rules, err = ParseUnlockRules(`{"title": "Rust"}`)
+	if err != nil || len(rules) != 0 {
+		t.Fatalf(`Course without rules has rules: %v %v`, rules, err)
+	}
+}
+
+func TestGetMissingPrerequisites(t *testing.T) {
+	rules, _ := ParseUnlockRules(prerequisitesTestTags)
+
+	completedIds := map[string]bool{"python_chapter_0010": true}
+	missing := rules.GetMissingPrerequisites("python_chapter_0030", completedIds)
+
+	if len(missing) != 1 || missing[0] != "python_chapter_0020" {
+		t.Fatalf(`Wrong missing prerequisites: %v`, missing)
+	}
+
+	missing = rules.GetMissingPrerequisites("python_chapter_0020", completedIds)
+	if len(missing) != 0 {
+		t.Fatalf(`Chapter without rules is locked by: %v`, missing)
+	}
+}
+
+func TestApplyUnlockRulesToChapters(t *testing.T) {
+	rules, _ := ParseUnlockRules(prerequisitesTestTags)
+
+	chapters := []ChapterForUser{
+		{ChapterId: "python_chapter_0010", Status: "completed"},
+		{ChapterId: "python_chapter_0020", Status: "completed"},
+		{ChapterId: "python_chapter_0030", Status: "in_progress"},
+		{ChapterId: "python_chapter_0030_project", Status: "not_started"},
+	}
+
+	rules.ApplyToChapters(chapters)
+
+	if chapters[2].Status != "in_progress" {
+		t.Fatalf(`Unlocked chapter is blocked: %v`, chapters[2])
+	}
+
+	if chapters[3].Status != "blocked" || len(chapters[3].LockedBy) != 1 {
+		t.Fatalf(`Locked project is not blocked: %v`, chapters[3])
+	}
+}
+
+// failingProgressStore fails to read progress of users
+type failingProgressStore struct {
+	*MemoryStore
+}
+
+func (s failingProgressStore) GetChapterStatuses(ctx context.Context, userId string) (map[string]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+// newPrerequisitesTestServer returns test server with course "python" with unlock rules
+func newPrerequisitesTestServer(t *testing.T) (*Server, *MemoryStore) {
+	newTestServer()
+
+	c := NewCatalog()
+	var course CatalogCourse
+	course.CourseId = "python"
+	course.Tags = prerequisitesTestTags
+	c.AddCourse(course)
+	for _, chapterId := range []string{"python_chapter_0010", "python_chapter_0020", "python_chapter_0030"} {
+		c.AddChapter(CatalogChapter{ChapterId: chapterId, CourseId: "python"})
+	}
+	c.Build()
+
+	prev := GetCatalog()
+	t.Cleanup(func() { SetCatalog(prev) })
+	SetCatalog(c)
+
+	store := NewMemoryStore(c)
+	return NewServer(store, nil, nil), store
+}
+
+func TestGetMaterialLock(t *testing.T) {
+	s, store := newPrerequisitesTestServer(t)
+	ctx := context.Background()
+
+	store.UpdateChapterProgress(ctx, "1", "python_chapter_0010", "completed")
+	store.UpdateChapterProgress(ctx, "1", "python_chapter_0020", "completed")
+
+	tests := []struct {
+		userId string
+		locked bool
+	}{
+		{"1", false},
+		// Anonymous user has no progress
+		{"", true},
+		{"2", true},
+	}
+
+	for _, test := range tests {
+		lock, err := s.GetMaterialLock(ctx, test.userId, "python", "python_chapter_0030")
+		if err != nil || (lock != nil) != test.locked {
+			t.Fatalf("Wrong lock for user %v. Plan: %v Fact: %v %v", test.userId, test.locked, lock, err)
+		}
+	}
+
+	// Lock isn't lifted if progress isn't available
+	s = NewServer(failingProgressStore{store}, nil, nil)
+	lock, err := s.GetMaterialLock(ctx, "1", "python", "python_chapter_0030")
+	if err == nil || lock != nil {
+		t.Fatalf("Wrong lock on failed progress lookup. Plan: %v Fact: %v %v", "error", lock, err)
+	}
+}
+
+func TestGetChaptersWithRules(t *testing.T) {
+	s, store := newPrerequisitesTestServer(t)
+	ctx := context.Background()
+
+	store.UpdateCourseProgress(ctx, "1", "python", "in_progress")
+	store.UpdateChapterProgress(ctx, "1", "python_chapter_0010", "completed")
+
+	tests := []struct {
+		url     string
+		blocked string
+	}{
+		{"/get_chapters?user_id=1", "python_chapter_0030"},
+		// Anonymous user sees the same locks as /get_chapter applies
+		{"/get_chapters", "python_chapter_0030"},
+	}
+
+	for _, test := range tests {
+		var chapters []ChapterForUser
+		err := callHandler(s.HandleGetChapters, test.url, `{"course_id": "python"}`, &chapters)
+		if err != nil {
+			t.Fatalf("Couldn't call handler: %v", err)
+		}
+
+		if len(chapters) != 3 || chapters[2].ChapterId != test.blocked || chapters[2].Status != "blocked" ||
+			chapters[0].Status == "blocked" {
+			t.Fatalf("Wrong chapters of %v. Plan: %v Fact: %v", test.url, test.blocked+" blocked", chapters)
+		}
+	}
+
+	// Chapters aren't returned unblocked if progress isn't available
+	s = NewServer(failingProgressStore{store}, nil, nil)
+	chapters, err := s.GetChaptersForUserWithRules(ctx, "1", "python")
+	if err == nil || chapters != nil {
+		t.Fatalf("Wrong chapters on failed progress lookup. Plan: %v Fact: %v %v", "error", chapters, err)
+	}
+
+	var response map[string]interface{}
+	err = callHandler(s.HandleGetActiveChapter, "/get_active_chapter?user_id=1", `{"course_id": "python"}`, &response)
+	if err != nil || response["error"] != "Couldn't get chapters for user" {
+		t.Fatalf("Wrong active chapter on failed progress lookup. Plan: %v Fact: %v %v", "error", response, err)
+	}
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Input of user program: stdin and command-line arguments. It's passed to watchman in separate fields
+// user_stdin and user_args and never mixed with flags of runner in cmd_line_args.
+// Each argument of user_args is a separate element of argv of user program: runner execs program
+// without shell. Arguments are still restricted to allowed chars in case runner builds command line.
+
+// Longer input is rejected as invalid request
+const maxUserStdinSize = 64 * 1024
+const maxUserArgs = 32
+const maxUserArgLen = 1024
+
+// Chars allowed in arguments besides letters and digits. None of them is interpreted by shell:
+// spaces, quotes, globs, braces, "~", "#", "!" and so on are rejected
+const argAllowedChars = "-_.,:=+/@%"
+
+type ProgramInput struct {
+	Stdin string   `json:"user_stdin,omitempty"`
+	Args  []string `json:"user_args,omitempty"`
+}
+
+func (in *ProgramInput) IsEmpty() bool {
+	return len(in.Stdin) == 0 && len(in.Args) == 0
+}
+
+// Validate checks size of input and that arguments are safe for shell
+func (in *ProgramInput) Validate() error {
+	if len(in.Stdin) > maxUserStdinSize {
+		return fmt.Errorf("user_stdin is longer than %d bytes", maxUserStdinSize)
+	}
+
+	if !utf8.ValidString(in.Stdin) || strings.ContainsRune(in.Stdin, 0) {
+		return errors.New("user_stdin contains invalid chars")
+	}
+
+	if len(in.Args) > maxUserArgs {
+		return fmt.Errorf("user_args contains more than %d arguments", maxUserArgs)
+	}
+
+	for _, arg := range in.Args {
+		if len(arg) > maxUserArgLen {
+			return fmt.Errorf("argument of user_args is longer than %d bytes", maxUserArgLen)
+		}
+
+		if !isShellSafeArg(arg) {
+			return fmt.Errorf("argument %q of user_args contains forbidden chars: only letters, digits and %s are allowed", arg, argAllowedChars)
+		}
+	}
+
+	return nil
+}
+
+func isShellSafeArg(arg string) bool {
+	if !utf8.ValidString(arg) {
+		return false
+	}
+
+	for _, c := range arg {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(argAllowedChars, c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateProgramInput(t *testing.T) {
+	tests := []struct {
+		input ProgramInput
+		valid bool
+	}{
+		{ProgramInput{}, true},
+		{ProgramInput{Stdin: "1 2\n3 4\n", Args: []string{"--count", "10", "file_name.txt", "-x=1,2", "/tmp/a+b@c%d:e"}}, true},
+		{ProgramInput{Stdin: "привет\n", Args: []string{"мир"}}, true},
+		{ProgramInput{Stdin: strings.Repeat("a", maxUserStdinSize+1)}, false},
+		{ProgramInput{Stdin: "a\x00b"}, false},
+		{ProgramInput{Stdin: "\xff"}, false},
+		{ProgramInput{Args: make([]string, maxUserArgs+1)}, false},
+		{ProgramInput{Args: []string{strings.Repeat("a", maxUserArgLen+1)}}, false},
+		{ProgramInput{Args: []string{"a; rm -rf /"}}, false},
+		{ProgramInput{Args: []string{"$(id)"}}, false},
+		{ProgramInput{Args: []string{"`id`"}}, false},
+		{ProgramInput{Args: []string{"a\nb"}}, false},
+		{ProgramInput{Args: []string{"> out"}}, false},
+		// Not in allowlist
+		{ProgramInput{Args: []string{"file name.txt"}}, false},
+		{ProgramInput{Args: []string{"*.txt"}}, false},
+		{ProgramInput{Args: []string{"a?"}}, false},
+		{ProgramInput{Args: []string{"(a)"}}, false},
+		{ProgramInput{Args: []string{"{a,b}"}}, false},
+		{ProgramInput{Args: []string{"~"}}, false},
+		{ProgramInput{Args: []string{"#a"}}, false},
+		{ProgramInput{Args: []string{"!1"}}, false},
+		{ProgramInput{Args: []string{"[1,2]"}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.input.Validate(); (err == nil) != test.valid {
+			t.Fatalf("Wrong validation of %v. Plan: %v Fact: %v", test.input, test.valid, err)
+		}
+	}
+}
+
+func TestRunTaskProgramInput(t *testing.T) {
+	opts := Options{TaskType: "code", ColorOutput: true, containerType: "python",
+		ProgramInput: ProgramInput{Stdin: "42\n", Args: []string{"-v", "1"}}}
+
+	body, err := getRequestBodyRunTask(&opts)
+	if err != nil {
+		t.Fatalf("Couldn't create body: %v", err)
+	}
+
+	var watchmanOpts WatchmanOptions
+	json.Unmarshal(body, &watchmanOpts)
+
+	// Arguments of user program are not mixed with flags of runner
+	planArgs := []string{"-c always", "-v code"}
+	if !reflect.DeepEqual(watchmanOpts.CmdLineArgs, planArgs) || !reflect.DeepEqual(watchmanOpts.ProgramInput, opts.ProgramInput) {
+		t.Fatalf("Wrong body for watchman. Plan: %v %v Fact: %s", planArgs, opts.ProgramInput, body)
+	}
+}
+
+func TestHandleRunCodeProgramInput(t *testing.T) {
+	s, _ := newTestServer()
+
+	var watchmanBody map[string]interface{}
+	watchman := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &watchmanBody)
+		w.Write([]byte(`{"status_code": 0, "user_code_output": "42\n"}`))
+	}))
+	defer watchman.Close()
+
+	prevAddr, prevAddrPlayground, prevAddrPractice := addrWatchman, addrWatchmanPlayground, addrWatchmanPractice
+	defer func() {
+		addrWatchman, addrWatchmanPlayground, addrWatchmanPractice = prevAddr, prevAddrPlayground, prevAddrPractice
+	}()
+	BindWatchman(watchman.URL)
+
+	var response map[string]interface{}
+	err := callHandler(s.HandleRunCode, "/run_code",
+		`{"lang_id": "python", "user_code": "cHJpbnQoaW5wdXQoKSk=", "project": "{}", "user_stdin": "42\n", "user_args": ["a", "b-c"]}`, &response)
+	if err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if response["status_code"] != float64(0) || watchmanBody["user_stdin"] != "42\n" ||
+		!reflect.DeepEqual(watchmanBody["user_args"], []interface{}{"a", "b-c"}) || watchmanBody["cmd_line_args"] != nil {
+		t.Fatalf("Wrong input passed to watchman. Plan: %v Fact: %v", "user_stdin and user_args", watchmanBody)
+	}
+
+	watchmanBody = nil
+	response = nil
+	err = callHandler(s.HandleRunCode, "/run_code",
+		`{"lang_id": "python", "user_code": "cHJpbnQoaW5wdXQoKSk=", "project": "{}", "user_args": ["$(id)"]}`, &response)
+	if err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if response["error"] == nil || watchmanBody != nil {
+		t.Fatalf("Wrong response to unsafe arguments. Plan: %v Fact: %v", "error", response)
+	}
+}
+
+func TestPracticeProgramInput(t *testing.T) {
+	tests := []struct {
+		req   PracticeReq
+		args  []string
+		valid bool
+	}{
+		{PracticeReq{CmdLineArgs: "--count 10"}, []string{"--count", "10"}, true},
+		{PracticeReq{ProgramInput: ProgramInput{Args: []string{"-v"}}}, []string{"-v"}, true},
+		{PracticeReq{CmdLineArgs: "a; rm -rf /"}, nil, false},
+		{PracticeReq{CmdLineArgs: "*"}, nil, false},
+		{PracticeReq{CmdLineArgs: "-v", ProgramInput: ProgramInput{Args: []string{"-v"}}}, nil, false},
+	}
+
+	for _, test := range tests {
+		req := test.req
+		err := req.validateProgramInput()
+		if (err == nil) != test.valid {
+			t.Fatalf("Wrong validation of %v. Plan: %v Fact: %v", test.req, test.valid, err)
+		}
+
+		if !test.valid {
+			continue
+		}
+
+		// Arguments reach watchman only in user_args
+		body, _ := json.Marshal(req)
+		var sent map[string]interface{}
+		json.Unmarshal(body, &sent)
+		if _, ok := sent["user_cmd_line_args"]; ok || !reflect.DeepEqual(req.Args, test.args) {
+			t.Fatalf("Wrong arguments of practice. Plan: %v Fact: %s", test.args, body)
+		}
+	}
+}
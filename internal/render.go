@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Server-side rendering of chapter and practice markdown to HTML, so that site and
+// telegram bot show lessons the same way:
+// - code blocks are highlighted with css classes of chroma (https://github.com/alecthomas/chroma),
+//   blocks without language are highlighted as code of the course language;
+// - headings get anchors and are collected to table of contents;
+// - links to other chapters lead to the chapter page on site, relative links lead to assets;
+// - resulting HTML is sanitized.
+
+// URL of chapter or practice project asset: /assets/{course_id}/{item_id}/{path}
+const assetsUrlPrefix = "/assets"
+
+// URLs of chapters and practice projects on site
+const chapterUrlPattern = "/courses/%s/chapters/%s/"
+const practiceUrlPattern = "/courses/%s/practice/%s/"
+
+// Course ids which differ from chroma lexer names
+var courseLanguages = map[string]string{
+	"cpp": "c++",
+}
+
+type TocEntry struct {
+	Level  int    `json:"level"`
+	Title  string `json:"title"`
+	Anchor string `json:"anchor"`
+}
+
+type RenderedText struct {
+	Html string     `json:"html"`
+	Toc  []TocEntry `json:"toc,omitempty"`
+}
+
+var htmlPolicy = newHtmlPolicy()
+
+func newHtmlPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-zA-Z0-9 _-]+$`)).OnElements("span", "pre", "code", "div")
+	p.AllowAttrs("data-chapter-id").Matching(regexp.MustCompile(`^[a-z0-9_]+$`)).OnElements("a")
+	return p
+}
+
+// GetHeadingAnchor makes anchor from heading text: "Что такое Cargo?" -> "что-такое-cargo"
+func GetHeadingAnchor(title string) string {
+	var anchor strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if dash && anchor.Len() > 0 {
+				anchor.WriteRune('-')
+			}
+			anchor.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	return anchor.String()
+}
+
+func getCourseLexer(courseId string, language string) chroma.Lexer {
+	if len(language) == 0 {
+		language = courseId
+		if l, ok := courseLanguages[courseId]; ok {
+			language = l
+		}
+	}
+
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	return chroma.Coalesce(lexer)
+}
+
+// codeBlockRenderer highlights fenced and indented code blocks.
+type codeBlockRenderer struct {
+	courseId  string
+	formatter *chromahtml.Formatter
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderCodeBlock)
+	reg.Register(ast.KindCodeBlock, r.renderCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	language := ""
+	if n, ok := node.(*ast.FencedCodeBlock); ok && n.Info != nil {
+		language = string(n.Language(source))
+	}
+
+	var code bytes.Buffer
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	iterator, err := getCourseLexer(r.courseId, language).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	return ast.WalkSkipChildren, r.formatter.Format(w, styles.Fallback, iterator)
+}
+
+// rewriteLink makes link to other chapter or practice project of the course lead to its page on site
+// and relative link lead to asset of the current chapter.
+func rewriteLink(c *Catalog, courseId string, itemId string, destination string) (string, string) {
+	u, err := url.Parse(destination)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || len(u.Path) == 0 {
+		return destination, ""
+	}
+
+	fragment := ""
+	if len(u.Fragment) > 0 {
+		fragment = "#" + u.Fragment
+	}
+
+	// Link to chapter: "python_chapter_0020", "../python_chapter_0020/text.md" or site url of chapter
+	for _, part := range strings.Split(u.Path, "/") {
+		if chapter, ok := c.Chapters[part]; ok && chapter.CourseId == courseId {
+			return fmt.Sprintf(chapterUrlPattern, courseId, part) + fragment, part
+		}
+
+		if p, ok := c.Practice[part]; ok && p.CourseId == courseId {
+			return fmt.Sprintf(practiceUrlPattern, courseId, part) + fragment, part
+		}
+	}
+
+	if strings.HasPrefix(u.Path, "/") || strings.HasPrefix(path.Clean(u.Path), "..") {
+		return destination, ""
+	}
+
+	return path.Join(assetsUrlPrefix, courseId, itemId, path.Clean(u.Path)) + fragment, ""
+}
+
+// RenderMarkdown renders markdown of chapter or practice project (itemId) to sanitized HTML.
+func RenderMarkdown(c *Catalog, courseId string, itemId string, markdown string) (RenderedText, error) {
+	rendered := RenderedText{Toc: []TocEntry{}}
+	source := []byte(markdown)
+
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(
+			// Raw HTML is allowed in lessons: it is sanitized after rendering
+			goldmarkhtml.WithUnsafe(),
+			renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{
+				courseId:  courseId,
+				formatter: chromahtml.New(chromahtml.WithClasses(true)),
+			}, 100)),
+		),
+	)
+
+	doc := md.Parser().Parse(text.NewReader(source))
+	anchors := make(map[string]int)
+
+	err := ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := node.(type) {
+		case *ast.Heading:
+			title := string(n.Text(source))
+			anchor := GetHeadingAnchor(title)
+
+			// Headings with the same text get different anchors
+			if count := anchors[anchor]; count > 0 {
+				anchors[anchor]++
+				anchor = fmt.Sprintf("%s-%d", anchor, count)
+			} else {
+				anchors[anchor] = 1
+			}
+
+			n.SetAttributeString("id", []byte(anchor))
+			rendered.Toc = append(rendered.Toc, TocEntry{Level: n.Level, Title: title, Anchor: anchor})
+
+		case *ast.Link:
+			destination, chapterId := rewriteLink(c, courseId, itemId, string(n.Destination))
+			n.Destination = []byte(destination)
+			if len(chapterId) > 0 {
+				n.SetAttributeString("data-chapter-id", []byte(chapterId))
+			}
+
+		case *ast.Image:
+			destination, _ := rewriteLink(c, courseId, itemId, string(n.Destination))
+			n.Destination = []byte(destination)
+		}
+
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return RenderedText{}, err
+	}
+
+	var html bytes.Buffer
+	err = md.Renderer().Render(&html, source, doc)
+	if err != nil {
+		return RenderedText{}, err
+	}
+
+	rendered.Html = htmlPolicy.Sanitize(html.String())
+	return rendered, nil
+}
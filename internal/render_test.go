@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	c := setTestCatalog()
+
+	markdown := "# Глава 1. Переменные\n\n" +
+		"## Что такое let?\n\n" +
+		"См. [владение](rust_chapter_0020#заимствование) и [ниже](#что-такое-let).\n\n" +
+		"![Схема](img/scheme.svg)\n\n" +
+		"```\nfn main() {}\n```\n\n" +
+		"<script>alert(1)</script><a href=\"javascript:alert(1)\">x</a>\n"
+
+	rendered, err := RenderMarkdown(c, "rust", "rust_chapter_0011", markdown)
+	if err != nil {
+		t.Fatalf(`Couldn't render markdown: %v`, err)
+	}
+
+	if len(rendered.Toc) != 2 || rendered.Toc[1].Anchor != "что-такое-let" || rendered.Toc[1].Level != 2 {
+		t.Fatalf(`Wrong table of contents: %v`, rendered.Toc)
+	}
+
+	plan := []string{
+		`<h2 id="что-такое-let">`,
+		`href="/courses/rust/chapters/rust_chapter_0020/#%D0%B7`,
+		`data-chapter-id="rust_chapter_0020">`,
+		`src="/assets/rust/rust_chapter_0011/img/scheme.svg"`,
+		`<span class="k">fn</span>`,
+	}
+
+	for _, s := range plan {
+		if !strings.Contains(rendered.Html, s) {
+			t.Fatalf(`Rendered HTML doesn't contain %v: %v`, s, rendered.Html)
+		}
+	}
+
+	if strings.Contains(rendered.Html, "<script>") || strings.Contains(rendered.Html, "javascript:") {
+		t.Fatalf(`Rendered HTML is not sanitized: %v`, rendered.Html)
+	}
+}
+
+func TestGetAssetPath(t *testing.T) {
+	c := setTestCatalog()
+
+	path, err := GetAssetPath(c, "rust", "rust_chapter_0011", "img/../scheme.png")
+	if err != nil || path != "/data/courses/rust/rust_chapter_0011/scheme.png" {
+		t.Fatalf(`Wrong asset path: %v %v`, path, err)
+	}
+
+	path, err = GetAssetPath(c, "rust", "rust_chapter_0011", "../../../etc/shadow.png")
+	if err != nil || path != "/data/courses/rust/rust_chapter_0011/etc/shadow.png" {
+		t.Fatalf(`Asset path is outside of chapter: %v %v`, path, err)
+	}
+
+	badPaths := [][]string{
+		{"rust", "rust_chapter_0011", "tasks/rust_chapter_0011_task_0010/solution.rs"},
+		{"rust", "rust_chapter_0011", ".git/logo.png"},
+		{"python", "rust_chapter_0011", "scheme.png"},
+	}
+
+	for _, p := range badPaths {
+		if path, err := GetAssetPath(c, p[0], p[1], p[2]); err == nil {
+			t.Fatalf(`Asset path is allowed: %v`, path)
+		}
+	}
+}
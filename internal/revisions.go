@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Content revisions: import_courses.py records revision of each course and revision in which
+// each task changed last time. Progress on task references revision it was earned against.
+// When task changes, admin applies one of policies to progress earned against previous revisions.
+
+const (
+	RevisionPolicyPending    = "pending"
+	RevisionPolicyKeep       = "keep"
+	RevisionPolicyInvalidate = "invalidate"
+	RevisionPolicyMigrate    = "migrate"
+)
+
+type TaskChange struct {
+	TaskId      string    `json:"task_id"`
+	OldRevision string    `json:"old_revision"`
+	NewRevision string    `json:"new_revision"`
+	Policy      string    `json:"policy"`
+	DtChange    time.Time `json:"dt_change"`
+}
+
+type OptionsTaskChanges struct {
+	CourseId string `json:"course_id"`
+	TaskId   string `json:"task_id,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+}
+
+func GetTaskRevision(taskId string) string {
+	return GetCatalog().TaskRevisions[taskId]
+}
+
+// IsTaskChanged checks if task changed since progress on it was saved against revision.
+// Progress without revision was saved before revisions were recorded.
+func IsTaskChanged(taskId string, revision string) bool {
+	current := GetTaskRevision(taskId)
+	return len(revision) > 0 && len(current) > 0 && revision != current
+}
+
+func IsRevisionPolicyValid(policy string) bool {
+	return policy == RevisionPolicyKeep || policy == RevisionPolicyInvalidate || policy == RevisionPolicyMigrate
+}
+
+func parseOptionsTaskChanges(r *http.Request) (OptionsTaskChanges, error) {
+	var opts OptionsTaskChanges
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil {
+		return OptionsTaskChanges{}, err
+	}
+
+	if len(opts.CourseId) == 0 {
+		return opts, fmt.Errorf("course_id is required")
+	}
+
+	return opts, nil
+}
+
+func (s *Server) HandleGetTaskChanges(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := parseOptionsTaskChanges(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"error":     err.Error(),
+		}).Warning("/get_task_changes: couldn't parse request")
+		return
+	}
+
+	changes, err := s.store.GetPendingTaskChanges(r.Context(), opts.CourseId, opts.TaskId)
+	if err != nil {
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get task changes",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"task_id":   opts.TaskId,
+			"db_error":  err.Error(),
+		}).Error("/get_task_changes: couldn't get task changes")
+		return
+	}
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"course_id":   opts.CourseId,
+		"task_id":     opts.TaskId,
+		"changes_len": len(changes),
+	}).Info("/get_task_changes: completed")
+
+	json.NewEncoder(w).Encode(changes)
+}
+
+func (s *Server) HandleApplyTaskChanges(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := parseOptionsTaskChanges(r)
+	if err == nil && !IsRevisionPolicyValid(opts.Policy) {
+		err = fmt.Errorf("unknown policy: %s", opts.Policy)
+	}
+
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"policy":    opts.Policy,
+			"error":     err.Error(),
+		}).Warning("/apply_task_changes: couldn't parse request")
+		return
+	}
+
+	status := 0
+
+	applied, err := s.store.ApplyTaskChanges(r.Context(), opts)
+	if err != nil {
+		status = -1
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"task_id":   opts.TaskId,
+			"policy":    opts.Policy,
+			"db_error":  err.Error(),
+		}).Error("/apply_task_changes: couldn't apply policy to task changes")
+	} else {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+			"task_id":   opts.TaskId,
+			"policy":    opts.Policy,
+			"applied":   applied,
+		}).Info("/apply_task_changes: completed")
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{
+		"status":  status,
+		"applied": applied,
+	})
+}
@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Full-text search over chapter texts, chapter keywords and practice descriptions.
+// Index is built together with catalog, so it is rebuilt on each catalog reload.
+// Words are stemmed, so search by "функции" finds chapter about "функциях".
+
+const searchDefaultLimit = 20
+const searchMaxLimit = 100
+const searchSnippetRunesBefore = 60
+const searchSnippetRunesAfter = 140
+
+// BM25 ranking parameters
+const searchK1 = 1.2
+const searchB = 0.75
+
+// Weights of words in title and keywords relative to words in text
+const searchTitleWeight = 3
+const searchKeywordsWeight = 2
+
+var searchStopWords = map[string]bool{
+	"и": true, "в": true, "во": true, "не": true, "что": true, "на": true, "с": true, "со": true, "как": true,
+	"а": true, "то": true, "все": true, "так": true, "но": true, "да": true, "к": true, "у": true, "же": true,
+	"за": true, "бы": true, "по": true, "от": true, "о": true, "об": true, "из": true, "ли": true, "если": true,
+	"или": true, "ни": true, "до": true, "для": true, "это": true, "при": true, "без": true, "под": true,
+	"the": true, "an": true, "and": true, "or": true, "of": true, "to": true, "in": true, "is": true,
+	"it": true, "for": true, "on": true, "with": true, "as": true, "by": true, "be": true,
+}
+
+type OptionsSearch struct {
+	Query    string `json:"query"`
+	CourseId string `json:"course_id,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+type SearchResult struct {
+	CourseId   string `json:"course_id"`
+	ChapterId  string `json:"chapter_id"`
+	Title      string `json:"title"`
+	IsPractice bool   `json:"is_practice"`
+	// Empty if user has no access to chapter or practice of paid course
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+type SearchDocument struct {
+	CourseId   string
+	ChapterId  string
+	Title      string
+	IsPractice bool
+	Keywords   string
+	Text       string
+}
+
+type searchPosting struct {
+	doc int
+	tf  float64
+}
+
+type SearchIndex struct {
+	Documents []SearchDocument
+	postings  map[string][]searchPosting
+	docLen    []float64
+	avgDocLen float64
+}
+
+type searchToken struct {
+	stem  string
+	start int // rune offset in text
+	end   int
+}
+
+func normalizeSearchWord(word string) string {
+	word = strings.ToLower(word)
+	return strings.ReplaceAll(word, "ё", "е")
+}
+
+func stemSearchWord(word string) string {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return StemRussian(word)
+		}
+	}
+
+	// Latin words: only plural forms
+	if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+		return word[:len(word)-1]
+	}
+
+	return word
+}
+
+// tokenizeForSearch splits text to words and returns their stems with positions in text.
+func tokenizeForSearch(text []rune) []searchToken {
+	tokens := []searchToken{}
+
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		isWordRune := i < len(text) && (unicode.IsLetter(text[i]) || unicode.IsDigit(text[i]))
+
+		if isWordRune && start == -1 {
+			start = i
+			continue
+		}
+
+		if isWordRune || start == -1 {
+			continue
+		}
+
+		word := normalizeSearchWord(string(text[start:i]))
+		if len([]rune(word)) > 1 && !searchStopWords[word] {
+			tokens = append(tokens, searchToken{stem: stemSearchWord(word), start: start, end: i})
+		}
+		start = -1
+	}
+
+	return tokens
+}
+
+func NewSearchIndex(c *Catalog) *SearchIndex {
+	index := &SearchIndex{
+		Documents: []SearchDocument{},
+		postings:  make(map[string][]searchPosting),
+	}
+
+	for _, courseId := range c.CourseIds {
+		for _, item := range c.Courses[courseId].Items {
+			doc := SearchDocument{
+				CourseId:  courseId,
+				ChapterId: item.ChapterId,
+				Title:     item.Title,
+			}
+
+			if p, ok := c.Practice[item.ChapterId]; ok {
+				doc.IsPractice = true
+				doc.Text = c.Texts[filepath.Join(RootCourses, courseId, "practice", p.ProjectId, "text.md")]
+			} else {
+				contentPath, keywordsPath := GetPathToChapterText(courseId, item.ChapterId)
+				doc.Text = c.Texts[contentPath]
+				doc.Keywords = c.Texts[keywordsPath]
+			}
+
+			index.AddDocument(doc)
+		}
+	}
+
+	return index
+}
+
+func (index *SearchIndex) AddDocument(doc SearchDocument) {
+	docId := len(index.Documents)
+	index.Documents = append(index.Documents, doc)
+
+	tf := make(map[string]float64)
+
+	textTokens := tokenizeForSearch([]rune(doc.Text))
+	for _, token := range textTokens {
+		tf[token.stem] += 1
+	}
+
+	for _, token := range tokenizeForSearch([]rune(doc.Keywords)) {
+		tf[token.stem] += searchKeywordsWeight
+	}
+
+	for _, token := range tokenizeForSearch([]rune(doc.Title)) {
+		tf[token.stem] += searchTitleWeight
+	}
+
+	for stem, count := range tf {
+		index.postings[stem] = append(index.postings[stem], searchPosting{doc: docId, tf: count})
+	}
+
+	index.docLen = append(index.docLen, float64(len(textTokens)))
+	index.avgDocLen += (float64(len(textTokens)) - index.avgDocLen) / float64(len(index.docLen))
+}
+
+// Search returns documents ranked by BM25. If courseId is set, only documents of this course are returned.
+func (index *SearchIndex) Search(query string, courseId string, limit int) []SearchResult {
+	results := []SearchResult{}
+
+	if index == nil {
+		return results
+	}
+
+	stems := make(map[string]bool)
+	for _, token := range tokenizeForSearch([]rune(query)) {
+		stems[token.stem] = true
+	}
+
+	scores := make(map[int]float64)
+	matchedStems := make(map[int]int)
+	n := float64(len(index.Documents))
+
+	for stem := range stems {
+		postings := index.postings[stem]
+		idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+
+		for _, p := range postings {
+			if len(courseId) > 0 && index.Documents[p.doc].CourseId != courseId {
+				continue
+			}
+
+			norm := 1 - searchB + searchB*index.docLen[p.doc]/math.Max(index.avgDocLen, 1)
+			scores[p.doc] += idf * p.tf * (searchK1 + 1) / (p.tf + searchK1*norm)
+			matchedStems[p.doc]++
+		}
+	}
+
+	docIds := make([]int, 0, len(scores))
+	for docId := range scores {
+		// Documents with all words of query go first
+		scores[docId] *= float64(matchedStems[docId]) / float64(len(stems))
+		docIds = append(docIds, docId)
+	}
+
+	sort.Slice(docIds, func(i, j int) bool {
+		if scores[docIds[i]] != scores[docIds[j]] {
+			return scores[docIds[i]] > scores[docIds[j]]
+		}
+		return docIds[i] < docIds[j]
+	})
+
+	if len(docIds) > limit {
+		docIds = docIds[:limit]
+	}
+
+	for _, docId := range docIds {
+		doc := index.Documents[docId]
+
+		snippet := GetSearchSnippet(doc.Text, stems)
+		if len(snippet) == 0 {
+			snippet = GetSearchSnippet(doc.Keywords, stems)
+		}
+
+		results = append(results, SearchResult{
+			CourseId:   doc.CourseId,
+			ChapterId:  doc.ChapterId,
+			Title:      doc.Title,
+			IsPractice: doc.IsPractice,
+			Snippet:    snippet,
+			Score:      math.Round(scores[docId]*1000) / 1000,
+		})
+	}
+
+	return results
+}
+
+// GetSearchSnippet returns HTML-escaped fragment of text around the first found word
+// with found words wrapped into <mark></mark>.
+func GetSearchSnippet(text string, stems map[string]bool) string {
+	runes := []rune(text)
+	tokens := tokenizeForSearch(runes)
+
+	first := -1
+	for i, token := range tokens {
+		if stems[token.stem] {
+			first = i
+			break
+		}
+	}
+
+	if first == -1 {
+		return ""
+	}
+
+	start := tokens[first].start - searchSnippetRunesBefore
+	if start < 0 {
+		start = 0
+	}
+
+	end := tokens[first].end + searchSnippetRunesAfter
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+
+	pos := start
+	for _, token := range tokens[first:] {
+		if token.end > end {
+			break
+		}
+
+		if !stems[token.stem] {
+			continue
+		}
+
+		snippet.WriteString(html.EscapeString(string(runes[pos:token.start])))
+		snippet.WriteString("<mark>")
+		snippet.WriteString(html.EscapeString(string(runes[token.start:token.end])))
+		snippet.WriteString("</mark>")
+		pos = token.end
+	}
+
+	snippet.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		snippet.WriteString("…")
+	}
+
+	return strings.Join(strings.Fields(snippet.String()), " ")
+}
+
+// hideDeniedSnippets blanks snippets of items which user can't open. Titles are left
+// because they are shown in list of chapters too. If access couldn't be checked snippet is blanked.
+func (s *Server) hideDeniedSnippets(ctx context.Context, userId string, results []SearchResult) {
+	for i := range results {
+		denied, err := s.GetAccessDenied(ctx, userId, results[i].CourseId, results[i].ChapterId)
+		if err != nil {
+			Logger.WithContext(ctx).WithFields(log.Fields{
+				"user_id":   userId,
+				"course_id": results[i].CourseId,
+				"item_id":   results[i].ChapterId,
+				"error":     err.Error(),
+			}).Error("/search: couldn't check access to course")
+		}
+
+		if err != nil || denied != nil {
+			results[i].Snippet = ""
+		}
+	}
+}
+
+func (s *Server) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	var opts OptionsSearch
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		body, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+		w.Write(body)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"error": err.Error(),
+		}).Warning("/search: couldn't parse request")
+		return
+	}
+
+	if len(strings.TrimSpace(opts.Query)) == 0 {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't get query",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"course_id": opts.CourseId,
+		}).Warning("/search: required fields not set in request")
+		return
+	}
+
+	if opts.Limit <= 0 {
+		opts.Limit = searchDefaultLimit
+	}
+
+	if opts.Limit > searchMaxLimit {
+		opts.Limit = searchMaxLimit
+	}
+
+	userId := GetUserId(r)
+	results := GetCatalog().Search.Search(opts.Query, opts.CourseId, opts.Limit)
+	s.hideDeniedSnippets(r.Context(), userId, results)
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id":     userId,
+		"query":       opts.Query,
+		"course_id":   opts.CourseId,
+		"results_len": len(results),
+	}).Info("/search: completed")
+
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestStemRussian(t *testing.T) {
+	groups := [][]string{
+		{"функция", "функции", "функцией", "функциях"},
+		{"переменная", "переменные", "переменных"},
+		{"замыкание", "замыкания", "замыканием"},
+	}
+
+	for _, group := range groups {
+		plan := StemRussian(group[0])
+		for _, word := range group[1:] {
+			if fact := StemRussian(word); fact != plan {
+				t.Fatalf(`Wrong stem of %v. Plan: %v Fact: %v`, word, plan, fact)
+			}
+		}
+	}
+
+	if StemRussian("код") == StemRussian("кот") {
+		t.Fatalf(`Different words have the same stem`)
+	}
+}
+
+func setTestSearchCatalog() *Catalog {
+	c := setTestCatalog()
+
+	contentPath, keywordsPath := GetPathToChapterText("rust", "rust_chapter_0010")
+	c.Texts[contentPath] = "Переменные объявляются с помощью let. Функция main — точка входа."
+	c.Texts[keywordsPath] = "переменная, let"
+
+	contentPath, _ = GetPathToChapterText("rust", "rust_chapter_0020")
+	c.Texts[contentPath] = "Каждое значение имеет владельца. Функции могут передавать владение: <T>."
+
+	c.Search = NewSearchIndex(c)
+	return c
+}
+
+func TestSearchRanking(t *testing.T) {
+	c := setTestSearchCatalog()
+
+	results := c.Search.Search("функциями", "", 10)
+	if len(results) != 2 {
+		t.Fatalf(`Wrong results count. Plan: %v Fact: %v`, 2, len(results))
+	}
+
+	results = c.Search.Search("переменных", "rust", 10)
+	if len(results) == 0 || results[0].ChapterId != "rust_chapter_0010" {
+		t.Fatalf(`Wrong first result: %v`, results)
+	}
+
+	results = c.Search.Search("владение функций", "", 10)
+	if len(results) == 0 || results[0].ChapterId != "rust_chapter_0020" {
+		t.Fatalf(`Wrong first result: %v`, results)
+	}
+
+	results = c.Search.Search("функции", "python", 10)
+	if len(results) != 0 {
+		t.Fatalf(`Course filter is ignored: %v`, results)
+	}
+
+	// Practice project is found by its title
+	results = c.Search.Search("calculator", "", 10)
+	if len(results) != 1 || !results[0].IsPractice {
+		t.Fatalf(`Wrong practice result: %v`, results)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	c := setTestSearchCatalog()
+
+	results := c.Search.Search("владение", "", 1)
+	if len(results) != 1 {
+		t.Fatalf(`Wrong results count. Plan: %v Fact: %v`, 1, len(results))
+	}
+
+	snippet := results[0].Snippet
+	if !strings.Contains(snippet, "<mark>владение</mark>") {
+		t.Fatalf(`Found words are not highlighted: %v`, snippet)
+	}
+
+	if !strings.Contains(snippet, "&lt;T&gt;") {
+		t.Fatalf(`Snippet is not escaped: %v`, snippet)
+	}
+}
+
+func TestHideDeniedSnippets(t *testing.T) {
+	s, store := newPaidTestServer()
+	ctx := context.Background()
+
+	store.GrantEntitlement(ctx, OptionsEntitlement{UserId: 1, CourseId: "cpp"})
+
+	tests := []struct {
+		userId    string
+		chapterId string
+		courseId  string
+		hidden    bool
+	}{
+		{"", "rust_chapter_0010", "rust", false},
+		{"", "cpp_chapter_0010", "cpp", false},
+		{"", "cpp_chapter_0020", "cpp", true},
+		{"2", "cpp_chapter_0020", "cpp", true},
+		{"1", "cpp_chapter_0020", "cpp", false},
+		// Access couldn't be checked
+		{"1", "unknown_chapter_0010", "unknown", true},
+	}
+
+	for _, test := range tests {
+		results := []SearchResult{{CourseId: test.courseId, ChapterId: test.chapterId, Snippet: "<mark>text</mark>"}}
+		s.hideDeniedSnippets(ctx, test.userId, results)
+
+		if (len(results[0].Snippet) == 0) != test.hidden {
+			t.Fatalf(`Wrong snippet of %v for user %v. Plan: %v Fact: %v`, test.chapterId, test.userId, test.hidden, results[0].Snippet)
+		}
+	}
+}
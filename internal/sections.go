@@ -0,0 +1,145 @@
+package internal
+
+import "strconv"
+
+// Sections are chapters which group other chapters of the course (parts, modules).
+// Chapter belongs to section if its ParentChapterId is set. Sections may be nested.
+
+// IsPracticeId checks if item from chapters list is practice project.
+// Chapter ids are in format coursename_chapter_NNNN, project ids are not.
+func IsPracticeId(itemId string) bool {
+	prefixLen := len(itemId) - 4 // NNNN
+	if prefixLen < 0 {
+		return true
+	}
+
+	_, err := strconv.Atoi(itemId[prefixLen:])
+	return err != nil
+}
+
+// groupBySections returns top-level items and children of each section.
+// Order of items inside each group is preserved.
+func groupBySections(chapters []ChapterForUser) ([]int, map[string][]int) {
+	known := make(map[string]bool, len(chapters))
+	for _, chapter := range chapters {
+		if !IsPracticeId(chapter.ChapterId) {
+			known[chapter.ChapterId] = true
+		}
+	}
+
+	roots := []int{}
+	children := make(map[string][]int)
+
+	for i, chapter := range chapters {
+		// Parent could be absent in list: treat such chapter as top-level
+		if len(chapter.ParentChapterId) == 0 || !known[chapter.ParentChapterId] {
+			roots = append(roots, i)
+			continue
+		}
+
+		children[chapter.ParentChapterId] = append(children[chapter.ParentChapterId], i)
+	}
+
+	return roots, children
+}
+
+// OrderChaptersBySections returns flat list of chapters in reading order:
+// each section is followed by its chapters, then by the next section.
+func OrderChaptersBySections(chapters []ChapterForUser) []ChapterForUser {
+	roots, children := groupBySections(chapters)
+	ordered := make([]ChapterForUser, 0, len(chapters))
+	visited := make(map[string]bool, len(chapters))
+
+	var visit func(i int)
+	visit = func(i int) {
+		ordered = append(ordered, chapters[i])
+
+		id := chapters[i].ChapterId
+		if IsPracticeId(id) || visited[id] {
+			return
+		}
+		visited[id] = true
+
+		for _, j := range children[id] {
+			visit(j)
+		}
+	}
+
+	for _, i := range roots {
+		visit(i)
+	}
+
+	return ordered
+}
+
+// BuildChaptersTree nests chapters into their sections and aggregates
+// progress of each section over all its chapters.
+func BuildChaptersTree(chapters []ChapterForUser) []ChapterForUser {
+	roots, children := groupBySections(chapters)
+	visited := make(map[string]bool, len(chapters))
+
+	var build func(i int) ChapterForUser
+	build = func(i int) ChapterForUser {
+		node := chapters[i]
+
+		if IsPracticeId(node.ChapterId) || visited[node.ChapterId] {
+			return node
+		}
+		visited[node.ChapterId] = true
+
+		for _, j := range children[node.ChapterId] {
+			child := build(j)
+			aggregateSectionProgress(&node, &child)
+			node.Chapters = append(node.Chapters, child)
+		}
+
+		if len(node.Chapters) > 0 {
+			node.Status = getSectionStatus(node)
+		}
+
+		return node
+	}
+
+	tree := make([]ChapterForUser, 0, len(roots))
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+
+	return tree
+}
+
+func aggregateSectionProgress(section *ChapterForUser, child *ChapterForUser) {
+	section.TasksTotal += child.TasksTotal
+	section.TasksCompleted += child.TasksCompleted
+
+	if IsPracticeId(child.ChapterId) {
+		return
+	}
+
+	section.ChaptersTotal += 1 + child.ChaptersTotal
+	section.ChaptersCompleted += child.ChaptersCompleted
+
+	if child.Status == "completed" && child.ChaptersCompleted == child.ChaptersTotal {
+		section.ChaptersCompleted++
+	}
+}
+
+// Section is completed only when it is completed by itself and all its chapters are completed.
+// Section is in progress if user started any of its chapters.
+func getSectionStatus(section ChapterForUser) string {
+	if section.Status == "completed" && section.ChaptersCompleted == section.ChaptersTotal {
+		return "completed"
+	}
+
+	if section.Status != "not_started" {
+		return "in_progress"
+	}
+
+	for _, child := range section.Chapters {
+		if child.Status != "not_started" {
+			return "in_progress"
+		}
+	}
+
+	return "not_started"
+}
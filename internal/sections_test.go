@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"testing"
+)
+
+func getSectionsTestChapters() []ChapterForUser {
+	// Sorted by id as returned from DB
+	return []ChapterForUser{
+		{ChapterId: "cpp_chapter_0010", Status: "completed", TasksTotal: 1, TasksCompleted: 1},
+		{ChapterId: "cpp_chapter_0015", Status: "completed", TasksTotal: 2, TasksCompleted: 2, ParentChapterId: "cpp_chapter_0030"},
+		{ChapterId: "cpp_chapter_0020", Status: "in_progress", TasksTotal: 3, TasksCompleted: 1, ParentChapterId: "cpp_chapter_0010"},
+		{ChapterId: "cpp_chapter_0020_project", Status: "not_started", TasksTotal: 1, ParentChapterId: "cpp_chapter_0010"},
+		{ChapterId: "cpp_chapter_0030", Status: "completed"},
+		{ChapterId: "cpp_chapter_0040", Status: "not_started", TasksTotal: 2},
+	}
+}
+
+func TestIsPracticeId(t *testing.T) {
+	if IsPracticeId("python_chapter_0010") {
+		t.Fatalf(`Chapter is detected as practice`)
+	}
+
+	if !IsPracticeId("python_chapter_0010_project") {
+		t.Fatalf(`Practice is detected as chapter`)
+	}
+}
+
+func TestOrderChaptersBySections(t *testing.T) {
+	plan := []string{
+		"cpp_chapter_0010", "cpp_chapter_0020", "cpp_chapter_0020_project",
+		"cpp_chapter_0030", "cpp_chapter_0015",
+		"cpp_chapter_0040",
+	}
+	fact := OrderChaptersBySections(getSectionsTestChapters())
+
+	if len(plan) != len(fact) {
+		t.Fatalf(`Wrong chapters count. Plan: %v Fact: %v`, len(plan), len(fact))
+	}
+
+	for i := range plan {
+		if plan[i] != fact[i].ChapterId {
+			t.Fatalf(`Wrong chapter at position %v. Plan: %v Fact: %v`, i, plan[i], fact[i].ChapterId)
+		}
+	}
+}
+
+func TestBuildChaptersTree(t *testing.T) {
+	tree := BuildChaptersTree(getSectionsTestChapters())
+
+	if len(tree) != 3 {
+		t.Fatalf(`Wrong top-level chapters count. Plan: %v Fact: %v`, 3, len(tree))
+	}
+
+	section := tree[0]
+	if len(section.Chapters) != 2 {
+		t.Fatalf(`Wrong section chapters count. Plan: %v Fact: %v`, 2, len(section.Chapters))
+	}
+
+	if section.Status != "in_progress" {
+		t.Fatalf(`Wrong section status. Plan: %v Fact: %v`, "in_progress", section.Status)
+	}
+
+	if section.TasksTotal != 5 || section.TasksCompleted != 2 {
+		t.Fatalf(`Wrong section tasks progress. Plan: 2/5 Fact: %v/%v`, section.TasksCompleted, section.TasksTotal)
+	}
+
+	if section.ChaptersTotal != 1 || section.ChaptersCompleted != 0 {
+		t.Fatalf(`Wrong section chapters progress. Plan: 0/1 Fact: %v/%v`, section.ChaptersCompleted, section.ChaptersTotal)
+	}
+
+	completedSection := tree[1]
+	if completedSection.Status != "completed" || completedSection.ChaptersCompleted != 1 {
+		t.Fatalf(`Wrong completed section progress. Status: %v Chapters completed: %v`,
+			completedSection.Status, completedSection.ChaptersCompleted)
+	}
+
+	if len(tree[2].Chapters) != 0 || tree[2].Status != "not_started" {
+		t.Fatalf(`Chapter without section is changed: %v`, tree[2])
+	}
+}
@@ -0,0 +1,649 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Server holds dependencies of handlers. Courses are read from in-memory catalog,
+// users progress is read from and written to store.
+type Server struct {
+	store Store
+
+	// Write-behind of non-critical writes. Nil if they are written synchronously
+	deferred *WriteBehind
+
+	// Activity of users on chapters for metrics DB. Nil if metrics DB is not configured
+	activity *ActivityRecorder
+}
+
+func NewServer(store Store, deferred *WriteBehind, activity *ActivityRecorder) *Server {
+	return &Server{
+		store:    store,
+		deferred: deferred,
+		activity: activity,
+	}
+}
+
+// TryStartCourse marks course as started by user. Mark is deferred: response doesn't depend on it.
+func (s *Server) TryStartCourse(ctx context.Context, userId string, courseId string) {
+	if s.deferred != nil {
+		s.deferred.Enqueue(DeferredWrite{Kind: DeferredStartCourse, UserId: userId, Key: courseId})
+		return
+	}
+
+	err := s.store.TryStartCourse(ctx, userId, courseId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"error":     err.Error(),
+		}).Error("update course status: couldn't update course status for user")
+	}
+}
+
+// AddUserInteraction records key-value of user interaction with site. Write is deferred.
+func (s *Server) AddUserInteraction(ctx context.Context, userId string, k string, v string) {
+	if s.deferred != nil {
+		s.deferred.Enqueue(DeferredWrite{Kind: DeferredUserInteraction, UserId: userId, Key: k, Value: v})
+		return
+	}
+
+	err := s.store.AddUserInteraction(ctx, userId, k, v)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"key":     k,
+			"error":   err.Error(),
+		}).Error("add user interaction: couldn't save interaction of user")
+	}
+}
+
+func (s *Server) SaveTask(ctx context.Context, userId string, taskId string, chapterId string, courseId string, solutionText string) bool {
+	err := s.store.SaveTask(ctx, userId, taskId, solutionText, GetTaskRevision(taskId))
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"task_id": taskId,
+			"error":   err.Error(),
+		}).Error("/save_task update task in DB: couldn't update task solution for user")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) UpdateStatus(ctx context.Context, userId string, taskId string, chapterId string, courseId string, isSolved bool, solutionText string) bool {
+	taskStatus := getEduMaterialStatus(isSolved)
+
+	err := s.store.UpdateTaskStatus(ctx, userId, taskId, taskStatus, solutionText, GetTaskRevision(taskId))
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"task_id": taskId,
+			"error":   err.Error(),
+		}).Error("/run_task: update task status: couldn't update task status for user")
+		return false
+	}
+
+	err = s.store.UpdateChapterProgress(ctx, userId, chapterId, "in_progress")
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":    userId,
+			"chapter_id": chapterId,
+			"error":      err.Error(),
+		}).Error("/run_task: update chapter status: couldn't update chapter status for user")
+		return false
+	}
+
+	s.TryStartCourse(ctx, userId, courseId)
+
+	Logger.WithContext(ctx).WithFields(log.Fields{
+		"user_id": userId,
+		"task_id": taskId,
+		"status":  taskStatus,
+	}).Info("/run_task: update task status: completed")
+
+	return true
+}
+
+func (s *Server) UpdateStatusPractice(ctx context.Context, userId string, projectId string, courseId string, isSolved bool, project string) bool {
+	taskStatus := getEduMaterialStatus(isSolved)
+
+	err := s.store.UpdatePracticeStatus(ctx, userId, projectId, taskStatus, project)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":    userId,
+			"project_id": projectId,
+			"error":      err.Error(),
+		}).Error("update practice status: couldn't update project status for user")
+		return false
+	}
+
+	s.TryStartCourse(ctx, userId, courseId)
+
+	Logger.WithContext(ctx).WithFields(log.Fields{
+		"user_id":    userId,
+		"project_id": projectId,
+		"status":     taskStatus,
+	}).Info("update practice status: completed")
+
+	return true
+}
+
+func (s *Server) SavePractice(ctx context.Context, userId string, projectId string, courseId string, project string) bool {
+	err := s.store.SavePractice(ctx, userId, projectId, project)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":    userId,
+			"project_id": projectId,
+			"error":      err.Error(),
+		}).Error("save practice: couldn't update project for user")
+		return false
+	}
+
+	s.TryStartCourse(ctx, userId, courseId)
+
+	Logger.WithContext(ctx).WithFields(log.Fields{
+		"user_id":    userId,
+		"project_id": projectId,
+	}).Info("save practice: completed")
+
+	return true
+}
+
+func GetCourses() []CourseForUser {
+	c := GetCatalog()
+	courses := []CourseForUser{}
+
+	for _, courseId := range c.CourseIds {
+		courses = append(courses, c.Courses[courseId].CourseForUser)
+	}
+
+	return courses
+}
+
+func GetPractice(opts Options) (Practice, error) {
+	p, err := GetCatalog().GetPractice(opts.TaskId)
+	if err != nil {
+		return Practice{}, nil
+	}
+
+	practice := p.Practice
+	practice.Status = "not_started"
+	return practice, nil
+}
+
+func (s *Server) GetPracticeForUser(ctx context.Context, opts Options) (Practice, error) {
+	practice, err := GetPractice(opts)
+	if err != nil || len(practice.ChapterId) == 0 {
+		return practice, err
+	}
+
+	status, project, err := s.store.GetPracticeProgress(ctx, opts.userId, opts.TaskId)
+	if err == sql.ErrNoRows {
+		return practice, nil
+	}
+
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"error":   err.Error(),
+		}).Error("Couldn't parse row from practice selection for user")
+		return Practice{}, err
+	}
+
+	practice.Status = status
+	practice.Project = project
+	return practice, nil
+}
+
+func GetCourseInfo(courseId string) (string, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return "", err
+	}
+
+	return course.Tags, nil
+}
+
+func GetCoursePathOnDisk(courseId string) (string, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return "", err
+	}
+
+	return course.Path, nil
+}
+
+func (s *Server) GetCoursesForUser(ctx context.Context, userId string) []CourseForUser {
+	statuses, err := s.store.GetCourseStatuses(ctx, userId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"error":   err.Error(),
+		}).Error("Couldn't parse row from courses selection for user")
+		return []CourseForUser{}
+	}
+
+	courses := GetCourses()
+
+	for i := range courses {
+		courses[i].Status = "not_started"
+		if status, ok := statuses[courses[i].CourseId]; ok {
+			courses[i].Status = status
+		}
+	}
+
+	sort.SliceStable(courses, func(i, j int) bool {
+		return courses[i].Status < courses[j].Status
+	})
+
+	return courses
+}
+
+func (s *Server) GetCoursesForUserByStatus(ctx context.Context, userId string, status string) []CourseForUser {
+	statuses, err := s.store.GetCourseStatuses(ctx, userId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"error":   err.Error(),
+		}).Error("Couldn't parse row from courses selection for user")
+		return []CourseForUser{}
+	}
+
+	courses := []CourseForUser{}
+
+	for _, course := range GetCourses() {
+		if statuses[course.CourseId] == status {
+			courses = append(courses, course)
+		}
+	}
+
+	return courses
+}
+
+func GetChapters(courseId string) []ChapterForUser {
+	return GetCatalog().GetItems(courseId)
+}
+
+// GetCompletedTasksCount returns chapter id -> count of tasks completed by user in chapter
+func (s *Server) GetCompletedTasksCount(ctx context.Context, userId string) (map[string]int, error) {
+	c := GetCatalog()
+	counts := make(map[string]int)
+
+	taskIds, err := s.store.GetCompletedTaskIds(ctx, userId)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, taskId := range taskIds {
+		if chapterId, ok := c.Tasks[taskId]; ok {
+			counts[chapterId]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (s *Server) GetChaptersForUser(ctx context.Context, userId string, courseId string) ([]ChapterForUser, error) {
+	chapters := GetChapters(courseId)
+
+	statuses, err := s.store.GetChapterStatuses(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	tasksCompleted, err := s.GetCompletedTasksCount(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range chapters {
+		if status, ok := statuses[chapters[i].ChapterId]; ok {
+			chapters[i].Status = status
+		}
+
+		if IsPracticeId(chapters[i].ChapterId) {
+			if chapters[i].Status == "completed" {
+				chapters[i].TasksCompleted = 1
+			}
+			continue
+		}
+
+		chapters[i].TasksCompleted = tasksCompleted[chapters[i].ChapterId]
+	}
+
+	return chapters, nil
+}
+
+func GetFirstChapterId(courseId string) (string, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return "", err
+	}
+
+	if len(course.ChapterIds) == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return course.ChapterIds[0], nil
+}
+
+func GetChapterTitle(chapterId string) (string, error) {
+	chapter, err := GetCatalog().GetChapter(chapterId)
+	if err != nil {
+		return "", err
+	}
+
+	return chapter.Title, nil
+}
+
+// GetChaptersOrder returns chapter ids of the course in reading order
+// (sections are followed by their chapters).
+func GetChaptersOrder(courseId string) ([]string, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return course.ChapterIds, nil
+}
+
+// GetParentChapter returns id and title of the section which contains chapter.
+// Returns sql.ErrNoRows for top-level chapter.
+func GetParentChapter(chapterId string) (string, string, error) {
+	c := GetCatalog()
+
+	chapter, err := c.GetChapter(chapterId)
+	if err != nil {
+		return "", "", err
+	}
+
+	parent, err := c.GetChapter(chapter.ParentChapterId)
+	if err != nil {
+		return "", "", err
+	}
+
+	return parent.ChapterId, parent.Title, nil
+}
+
+func GetNextChapterId(courseId string, chapterId string, getPractice bool) (string, error) {
+	c := GetCatalog()
+
+	course, err := c.GetCourse(courseId)
+	if err != nil {
+		return "", err
+	}
+
+	var nextChapterId string
+	err = sql.ErrNoRows
+
+	for i := 0; i+1 < len(course.ChapterIds); i++ {
+		if course.ChapterIds[i] == chapterId {
+			nextChapterId = course.ChapterIds[i+1]
+			err = nil
+			break
+		}
+	}
+
+	if getPractice && err == nil {
+		for _, item := range course.Items {
+			if p, ok := c.Practice[item.ChapterId]; ok && p.ChapterId == chapterId {
+				return p.ProjectId, nil
+			}
+		}
+	}
+
+	return nextChapterId, err
+}
+
+func (s *Server) GetChapterInfo(ctx context.Context, userId string, chapterId string) (string, string, error) {
+	title, err := GetChapterTitle(chapterId)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Anonymous user
+	if len(userId) == 0 {
+		return "not_started", title, nil
+	}
+
+	status, err := s.store.GetChapterProgress(ctx, userId, chapterId)
+	if err == sql.ErrNoRows {
+		return "not_started", title, nil
+	}
+
+	return status, title, err
+}
+
+func (s *Server) GetChapterForUser(ctx context.Context, opts Options) (ChapterContent, error) {
+	var chapterContent ChapterContent
+
+	if len(opts.ChapterId) == 0 {
+		// We need to get the first chapter in course id
+		activeChapterId, err := GetFirstChapterId(opts.CourseId)
+		if err != nil {
+			return ChapterContent{}, err
+		}
+		chapterContent.ChapterId = activeChapterId
+	} else {
+		chapterContent.ChapterId = opts.ChapterId
+	}
+
+	status, title, err := s.GetChapterInfo(ctx, opts.userId, chapterContent.ChapterId)
+	if err != nil {
+		return ChapterContent{}, err
+	}
+	chapterContent.Status = status
+	chapterContent.Title = GetCatalog().GetTitle(chapterContent.ChapterId, opts.locale, title)
+
+	parentId, parentTitle, err := GetParentChapter(chapterContent.ChapterId)
+	if err != nil && err != sql.ErrNoRows {
+		return ChapterContent{}, err
+	}
+	chapterContent.ParentChapterId = parentId
+	chapterContent.ParentChapterTitle = GetCatalog().GetTitle(parentId, opts.locale, parentTitle)
+
+	contentPath, keywordsPath := GetPathToChapterText(opts.CourseId, chapterContent.ChapterId)
+
+	chapterText, _ := ReadLocalizedText(contentPath, opts.locale)
+	keywordsText, err := ReadLocalizedText(keywordsPath, opts.locale)
+	if err == nil {
+		chapterContent.Keywords = keywordsText
+	}
+	chapterContent.Content = chapterText
+	chapterContent.Tasks = s.GetTasks(ctx, chapterContent.ChapterId, opts.userId)
+
+	if opts.Format == "html" {
+		rendered, err := RenderCatalogText(opts.CourseId, chapterContent.ChapterId, contentPath, opts.locale)
+		if err != nil {
+			return ChapterContent{}, err
+		}
+		chapterContent.Html = rendered.Html
+		chapterContent.Toc = rendered.Toc
+	}
+
+	return chapterContent, nil
+}
+
+func (s *Server) GetCourseProgressForUser(ctx context.Context, courseId string, userId string) (string, error) {
+	status, err := s.store.GetCourseProgress(ctx, userId, courseId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"error":     err.Error(),
+		}).Info("Couldn't get status from course_progress table")
+		return "", err
+	}
+
+	return status, nil
+}
+
+func (s *Server) GetTasks(ctx context.Context, chapterId string, userId string) []TaskForUser {
+	chapter, err := GetCatalog().GetChapter(chapterId)
+	if err != nil {
+		return []TaskForUser{}
+	}
+
+	tasks := []TaskForUser{}
+	index := make(map[string]int, len(chapter.TaskIds))
+
+	for i, taskId := range chapter.TaskIds {
+		tasks = append(tasks, TaskForUser{TaskId: taskId, Status: "not_started"})
+		index[taskId] = i
+	}
+
+	// Anonymous user
+	if len(userId) == 0 || len(tasks) == 0 {
+		return tasks
+	}
+
+	progress, err := s.store.GetTaskProgress(ctx, userId, chapter.TaskIds)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"error":   err.Error(),
+		}).Error("Couldn't parse row from tasks selection for user")
+		return []TaskForUser{}
+	}
+
+	for _, p := range progress {
+		tasks[index[p.TaskId]] = TaskForUser{
+			TaskId:    p.TaskId,
+			Status:    p.Status,
+			UserCode:  p.SolutionText,
+			IsChanged: IsTaskChanged(p.TaskId, p.Revision),
+		}
+	}
+
+	return tasks
+}
+
+func (s *Server) GetTaskForUser(ctx context.Context, userId string, taskId string) (TaskForUser, error) {
+	var task TaskForUser
+	task.TaskId = taskId
+	task.Status = "not_started"
+
+	progress, err := s.store.GetTaskProgress(ctx, userId, []string{taskId})
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"task_id": taskId,
+			"error":   err.Error(),
+		}).Error("Couldn't parse row from task_progress selection for user")
+		return TaskForUser{}, err
+	}
+
+	for _, p := range progress {
+		task.Status = p.Status
+		task.UserCode = p.SolutionText
+		task.IsChanged = IsTaskChanged(taskId, p.Revision)
+	}
+
+	return task, nil
+}
+
+func (s *Server) UpdateCourseProgressForUser(ctx context.Context, courseId string, status string, userId string) error {
+	err := s.store.UpdateCourseProgress(ctx, userId, courseId, status)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"db_error":  err.Error(),
+		}).Error("Couldn't update course progress for user")
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) AreAllChaptersInCourseCompleted(ctx context.Context, userId string, courseId string) (bool, error) {
+	course, err := GetCatalog().GetCourse(courseId)
+	if err != nil {
+		return false, err
+	}
+
+	statuses, err := s.store.GetChapterStatuses(ctx, userId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"error":     err.Error(),
+		}).Warning("AreAllChaptersInCourseCompleted: couldn't get chapter statuses for user")
+		return false, err
+	}
+
+	chaptersTotal := len(course.ChapterIds)
+	chaptersCompleted := 0
+
+	for _, chapterId := range course.ChapterIds {
+		if statuses[chapterId] == "completed" {
+			chaptersCompleted++
+		}
+	}
+
+	Logger.WithContext(ctx).WithFields(log.Fields{
+		"user_id":            userId,
+		"course_id":          courseId,
+		"chapters_total":     chaptersTotal,
+		"chapters_completed": chaptersCompleted,
+	}).Info("AreAllChaptersInCourseCompleted: retrieved stats")
+	return chaptersTotal == chaptersCompleted, nil
+}
+
+// GetCompletedMaterials returns ids of completed chapters and practice projects of the course.
+func (s *Server) GetCompletedMaterials(ctx context.Context, userId string, courseId string) (map[string]bool, error) {
+	completedIds := make(map[string]bool)
+
+	// Anonymous user
+	if len(userId) == 0 {
+		return completedIds, nil
+	}
+
+	statuses, err := s.store.GetChapterStatuses(ctx, userId)
+	if err != nil {
+		return completedIds, err
+	}
+
+	for _, item := range GetChapters(courseId) {
+		if statuses[item.ChapterId] == "completed" {
+			completedIds[item.ChapterId] = true
+		}
+	}
+
+	return completedIds, nil
+}
+
+func (s *Server) GetPracticeProjects(ctx context.Context, userId string, courseId string) []PracticeProject {
+	statuses, err := s.store.GetChapterStatuses(ctx, userId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"error":     err.Error(),
+		}).Error("Couldn't query from GetUnfinishedPracticeProjects selection for user")
+		return []PracticeProject{}
+	}
+
+	projects := []PracticeProject{}
+
+	for _, item := range GetChapters(courseId) {
+		if !IsPracticeId(item.ChapterId) {
+			continue
+		}
+
+		project := PracticeProject{ProjectId: item.ChapterId, Title: item.Title, Status: "not_started"}
+		if status, ok := statuses[item.ChapterId]; ok {
+			project.Status = status
+		}
+
+		projects = append(projects, project)
+	}
+
+	return projects
+}
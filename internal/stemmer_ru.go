@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"sort"
+)
+
+// Russian stemmer from Snowball project: https://snowballstem.org/algorithms/russian/stemmer.html
+// It strips inflectional endings, so "функции", "функцией" and "функция" have the same stem.
+
+func sortedByLen(endings ...string) [][]rune {
+	res := make([][]rune, 0, len(endings))
+	for _, e := range endings {
+		res = append(res, []rune(e))
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return len(res[i]) > len(res[j])
+	})
+	return res
+}
+
+var ruPerfectiveGerund1 = sortedByLen("в", "вши", "вшись")
+var ruPerfectiveGerund2 = sortedByLen("ив", "ивши", "ившись", "ыв", "ывши", "ывшись")
+var ruAdjective = sortedByLen("ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+	"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею")
+var ruParticiple1 = sortedByLen("ем", "нн", "вш", "ющ", "щ")
+var ruParticiple2 = sortedByLen("ивш", "ывш", "ующ")
+var ruReflexive = sortedByLen("ся", "сь")
+var ruVerb1 = sortedByLen("ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно")
+var ruVerb2 = sortedByLen("ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+	"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю")
+var ruNoun = sortedByLen("а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+	"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я")
+var ruSuperlative = sortedByLen("ейш", "ейше")
+var ruDerivational = sortedByLen("ост", "ость")
+var ruI = sortedByLen("и")
+var ruSoftSign = sortedByLen("ь")
+
+func isRuVowel(r rune) bool {
+	switch r {
+	case 'а', 'е', 'и', 'о', 'у', 'ы', 'э', 'ю', 'я':
+		return true
+	}
+	return false
+}
+
+func hasRuSuffix(w []rune, start int, suffix []rune) bool {
+	if len(w)-len(suffix) < start {
+		return false
+	}
+
+	for i := range suffix {
+		if w[len(w)-len(suffix)+i] != suffix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeRuEnding removes the longest ending which lies in w[start:].
+// If afterAYa is set, ending must be preceded by 'а' or 'я' which are kept.
+func removeRuEnding(w []rune, start int, endings [][]rune, afterAYa bool) ([]rune, bool) {
+	for _, e := range endings {
+		if !hasRuSuffix(w, start, e) {
+			continue
+		}
+
+		if afterAYa {
+			i := len(w) - len(e) - 1
+			if i < start || (w[i] != 'а' && w[i] != 'я') {
+				continue
+			}
+		}
+
+		return w[:len(w)-len(e)], true
+	}
+
+	return w, false
+}
+
+func removeRuEndingOfGroups(w []rune, start int, group1 [][]rune, group2 [][]rune) ([]rune, bool) {
+	// The longest ending of both groups wins
+	w1, ok1 := removeRuEnding(w, start, group1, true)
+	w2, ok2 := removeRuEnding(w, start, group2, false)
+
+	if ok1 && ok2 {
+		if len(w1) < len(w2) {
+			return w1, true
+		}
+		return w2, true
+	}
+
+	if ok1 {
+		return w1, true
+	}
+
+	return w2, ok2
+}
+
+// getRuRegions returns start of RV, R1 and R2 regions of the word.
+func getRuRegions(w []rune) (int, int, int) {
+	rv, r1, r2 := len(w), len(w), len(w)
+
+	for i := 0; i < len(w); i++ {
+		if isRuVowel(w[i]) {
+			rv = i + 1
+			break
+		}
+	}
+
+	for i := 1; i < len(w); i++ {
+		if !isRuVowel(w[i]) && isRuVowel(w[i-1]) {
+			r1 = i + 1
+			break
+		}
+	}
+
+	for i := r1 + 1; i < len(w); i++ {
+		if !isRuVowel(w[i]) && isRuVowel(w[i-1]) {
+			r2 = i + 1
+			break
+		}
+	}
+
+	return rv, r1, r2
+}
+
+func StemRussian(word string) string {
+	w := []rune(word)
+	rv, _, r2 := getRuRegions(w)
+
+	if rv >= len(w) {
+		return word
+	}
+
+	// Step 1
+	var ok bool
+	w, ok = removeRuEndingOfGroups(w, rv, ruPerfectiveGerund1, ruPerfectiveGerund2)
+	if !ok {
+		w, _ = removeRuEnding(w, rv, ruReflexive, false)
+
+		w, ok = removeRuEnding(w, rv, ruAdjective, false)
+		if ok {
+			w, _ = removeRuEndingOfGroups(w, rv, ruParticiple1, ruParticiple2)
+		} else {
+			w, ok = removeRuEndingOfGroups(w, rv, ruVerb1, ruVerb2)
+			if !ok {
+				w, _ = removeRuEnding(w, rv, ruNoun, false)
+			}
+		}
+	}
+
+	// Step 2
+	w, _ = removeRuEnding(w, rv, ruI, false)
+
+	// Step 3
+	w, _ = removeRuEnding(w, r2, ruDerivational, false)
+
+	// Step 4
+	if hasRuSuffix(w, rv, []rune("нн")) {
+		return string(w[:len(w)-1])
+	}
+
+	w, ok = removeRuEnding(w, rv, ruSuperlative, false)
+	if ok {
+		if hasRuSuffix(w, rv, []rune("нн")) {
+			w = w[:len(w)-1]
+		}
+		return string(w)
+	}
+
+	w, _ = removeRuEnding(w, rv, ruSoftSign, false)
+	return string(w)
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// Storage of courses and users progress. PostgresStore is used in production,
+// MemoryStore is used in tests of handlers.
+
+// Progress of user on task as it is stored
+type TaskProgress struct {
+	TaskId       string
+	Status       string
+	SolutionText string
+	Revision     string
+}
+
+type CatalogRepo interface {
+	// LoadCatalog returns new snapshot of courses, chapters, tasks and practice projects
+	LoadCatalog(ctx context.Context) (*Catalog, error)
+}
+
+type ProgressRepo interface {
+	TryStartCourse(ctx context.Context, userId string, courseId string) error
+
+	// GetCourseStatuses returns course id -> status of user on course
+	GetCourseStatuses(ctx context.Context, userId string) (map[string]string, error)
+	// GetCourseProgress returns empty status if user didn't start the course
+	GetCourseProgress(ctx context.Context, userId string, courseId string) (string, error)
+	UpdateCourseProgress(ctx context.Context, userId string, courseId string, status string) error
+	// GetCourseSummaries returns summaries of courses in progress and completed ones. Empty courseId means all courses
+	GetCourseSummaries(ctx context.Context, userId string, courseId string) ([]CourseSummary, error)
+	// RebuildCourseSummaries recounts summaries from progress. Zero userId means all users.
+	// Returns count of fixed summaries
+	RebuildCourseSummaries(ctx context.Context, userId int) (int, error)
+	// CheckCourseSummaries returns up to limit summaries which differ from full recount of progress.
+	// Zero userId means all users
+	CheckCourseSummaries(ctx context.Context, userId int, limit int) ([]SummaryMismatch, error)
+
+	// GetChapterStatuses returns chapter or project id -> status of user on it
+	GetChapterStatuses(ctx context.Context, userId string) (map[string]string, error)
+	// GetChapterProgress returns sql.ErrNoRows if user didn't start the chapter
+	GetChapterProgress(ctx context.Context, userId string, chapterId string) (string, error)
+	UpdateChapterProgress(ctx context.Context, userId string, chapterId string, status string) error
+
+	GetCompletedTaskIds(ctx context.Context, userId string) ([]string, error)
+	// GetTaskProgress returns progress on tasks which user started
+	GetTaskProgress(ctx context.Context, userId string, taskIds []string) ([]TaskProgress, error)
+	// SaveTask saves solution without changing status of task
+	SaveTask(ctx context.Context, userId string, taskId string, solutionText string, revision string) error
+	UpdateTaskStatus(ctx context.Context, userId string, taskId string, status string, solutionText string, revision string) error
+
+	MergeUsers(ctx context.Context, userIdCur int, userIdOld int) error
+	SplitUsers(ctx context.Context, userIdCur int, userIdNew int) error
+	AddUserInteraction(ctx context.Context, userId string, k string, v string) error
+
+	GetPendingTaskChanges(ctx context.Context, courseId string, taskId string) ([]TaskChange, error)
+	// ApplyTaskChanges returns count of applied changes
+	ApplyTaskChanges(ctx context.Context, opts OptionsTaskChanges) (int, error)
+}
+
+type PracticeRepo interface {
+	// GetPracticeProgress returns status and solution of project. Returns sql.ErrNoRows if user didn't start it
+	GetPracticeProgress(ctx context.Context, userId string, projectId string) (string, string, error)
+	SavePractice(ctx context.Context, userId string, projectId string, project string) error
+	UpdatePracticeStatus(ctx context.Context, userId string, projectId string, status string, project string) error
+}
+
+type PlaygroundRepo interface {
+	// CreatePlayground creates or updates playground. Returns true if playground is created.
+	// Returns ErrPlaygroundOwner if playground belongs to another user or is anonymous with another edit token
+	CreatePlayground(ctx context.Context, playground Playground) (bool, error)
+	// GetPlayground returns sql.ErrNoRows if playground doesn't exist
+	GetPlayground(ctx context.Context, playgroundId string) (Playground, error)
+	// TouchPlayground sets time of the last use of playground if it was set before dtTouched
+	TouchPlayground(ctx context.Context, playgroundId string, dtTouched time.Time) error
+	// GetUserPlaygrounds returns playgrounds of user without code, recently used first. Empty visibility means any
+	GetUserPlaygrounds(ctx context.Context, userId string, visibility string, limit int) ([]Playground, error)
+	// CountExpiredPlaygrounds counts playgrounds of rule which weren't used since dtExpire
+	CountExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time) (int, error)
+	// DeleteExpiredPlaygrounds deletes up to limit expired playgrounds of rule. Returns count of deleted ones
+	DeleteExpiredPlaygrounds(ctx context.Context, rule RetentionRule, dtExpire time.Time, limit int) (int, error)
+}
+
+type AccessRepo interface {
+	HasEntitlement(ctx context.Context, userId string, courseId string) (bool, error)
+	GrantEntitlement(ctx context.Context, opts OptionsEntitlement) error
+	RevokeEntitlement(ctx context.Context, opts OptionsEntitlement) error
+}
+
+type DeferredRepo interface {
+	// ApplyDeferredWrites applies all writes or none of them
+	ApplyDeferredWrites(ctx context.Context, writes []DeferredWrite) error
+}
+
+type Store interface {
+	CatalogRepo
+	ProgressRepo
+	PracticeRepo
+	PlaygroundRepo
+	AccessRepo
+	DeferredRepo
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Summary of user progress on course: counters of completed chapters, tasks and practice projects.
+// Store changes counters together with statuses of chapters, tasks and projects. Totals of
+// the course are taken from catalog.
+
+type CourseCounters struct {
+	ChaptersCompleted int `json:"chapters_completed"`
+	TasksCompleted    int `json:"tasks_completed"`
+	ProjectsCompleted int `json:"projects_completed"`
+}
+
+type CourseSummary struct {
+	CourseId string
+	Status   string
+	CourseCounters
+
+	// Last change of progress on course. Nil if unknown
+	DtLastActivity *time.Time
+}
+
+// SummaryMismatch is summary which differs from full recount of progress
+type SummaryMismatch struct {
+	UserId   int            `json:"user_id"`
+	CourseId string         `json:"course_id"`
+	Summary  CourseCounters `json:"summary"`
+	Recount  CourseCounters `json:"recount"`
+}
+
+// GetCourseTotals returns count of chapters (with sections), tasks and practice projects of the course.
+func (c *Catalog) GetCourseTotals(courseId string) (int, int, int) {
+	course, ok := c.Courses[courseId]
+	if !ok {
+		return 0, 0, 0
+	}
+
+	chapters, tasks, projects := len(course.ChapterIds), 0, 0
+
+	for _, chapterId := range course.ChapterIds {
+		tasks += len(c.Chapters[chapterId].TaskIds)
+	}
+
+	for _, item := range course.Items {
+		if IsPracticeId(item.ChapterId) {
+			projects++
+		}
+	}
+
+	return chapters, tasks, projects
+}
+
+// getCourseStats returns stats of courses in progress and completed ones. Empty courseId means all courses.
+func (s *Server) getCourseStats(ctx context.Context, userId string, courseId string) ([]CourseStatus, error) {
+	summaries, err := s.store.GetCourseSummaries(ctx, userId, courseId)
+	if err != nil {
+		return []CourseStatus{}, err
+	}
+
+	c := GetCatalog()
+	courseStatuses := []CourseStatus{}
+
+	for _, summary := range summaries {
+		course, err := c.GetCourse(summary.CourseId)
+		if err != nil {
+			continue
+		}
+
+		cs := CourseStatus{
+			CourseId:         summary.CourseId,
+			Title:            course.Title,
+			FinishedChapters: summary.ChaptersCompleted,
+			FinishedTasks:    summary.TasksCompleted,
+			FinishedProjects: summary.ProjectsCompleted,
+			Status:           summary.Status,
+			DtLastActivity:   summary.DtLastActivity,
+		}
+		cs.TotalChapters, cs.TotalTasks, cs.TotalProjects = c.GetCourseTotals(summary.CourseId)
+
+		courseStatuses = append(courseStatuses, cs)
+	}
+
+	return courseStatuses, nil
+}
+
+func (s *Server) GetCourseStatus(ctx context.Context, userId string, courseId string) []CourseStatus {
+	courseStatuses, err := s.getCourseStats(ctx, userId, courseId)
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id":   userId,
+			"course_id": courseId,
+			"error":     err.Error(),
+		}).Warning("Couldn't get course status")
+
+		return []CourseStatus{}
+	}
+
+	return courseStatuses
+}
+
+func (s *Server) GetCourseStatuses(ctx context.Context, userId string) []CourseStatus {
+	courseStatuses, err := s.getCourseStats(ctx, userId, "")
+	if err != nil {
+		Logger.WithContext(ctx).WithFields(log.Fields{
+			"user_id": userId,
+			"error":   err.Error(),
+		}).Warning("Couldn't get course statuses")
+
+		return []CourseStatus{}
+	}
+
+	return courseStatuses
+}
@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Starter templates of playgrounds. Each template is a directory in templates directory:
+//   templates/cpp_cmake/template.json - {"lang_id": "cpp", "title": "...", "main_file": "src/main.cpp", "default": true}
+//   templates/cpp_cmake/project/...   - files of project
+// Templates are loaded to catalog snapshot with texts of courses and reloaded with them.
+// Project of template is json object: path of file in project -> content.
+
+// Path to templates. If it's empty, templates are next to courses: /data/courses -> /data/templates
+var RootTemplates string
+
+// Templates over these limits are skipped on load
+const maxTemplateFiles = 100
+const maxTemplateSize = 1 << 20
+
+type PlaygroundTemplate struct {
+	TemplateId string `json:"template_id"`
+	LangId     string `json:"lang_id"`
+	Title      string `json:"title"`
+	// Path of file with code of user. Its content is user_code of template
+	MainFile string `json:"main_file"`
+	// Default template of language is used if playground has no project
+	IsDefault bool     `json:"default"`
+	Files     []string `json:"files"`
+	UserCode  string   `json:"user_code"`
+	Project   string   `json:"project"`
+}
+
+func GetTemplatesPath() string {
+	if len(RootTemplates) > 0 {
+		return RootTemplates
+	}
+
+	return filepath.Join(filepath.Dir(filepath.Clean(RootCourses)), "templates")
+}
+
+// LoadTemplates reads templates from directory. Invalid templates are skipped.
+func (c *Catalog) LoadTemplates(root string) {
+	c.Templates = make(map[string]*PlaygroundTemplate)
+	c.TemplateIds = []string{}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		Logger.WithFields(log.Fields{
+			"path":  root,
+			"error": err.Error(),
+		}).Warning("Couldn't read directory of playground templates")
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		t, err := loadTemplate(filepath.Join(root, entry.Name()), c.Containers)
+		if err != nil {
+			Logger.WithFields(log.Fields{
+				"template_id": entry.Name(),
+				"error":       err.Error(),
+			}).Warning("Couldn't load playground template")
+			continue
+		}
+
+		c.Templates[t.TemplateId] = t
+		c.TemplateIds = append(c.TemplateIds, t.TemplateId)
+	}
+
+	sort.Strings(c.TemplateIds)
+}
+
+func loadTemplate(dir string, containers *ContainerRegistry) (*PlaygroundTemplate, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "template.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &PlaygroundTemplate{}
+	if err = json.Unmarshal(content, t); err != nil {
+		return nil, err
+	}
+
+	t.TemplateId = filepath.Base(dir)
+	if !isValidPlaygroundId(t.TemplateId) {
+		return nil, errors.New("invalid name of template directory")
+	}
+
+	container, ok := containers.Get(t.LangId)
+	if !ok {
+		return nil, errors.New("unknown lang_id")
+	}
+	t.LangId = container.LangId
+
+	files := map[string]string{}
+	size := 0
+	projectDir := filepath.Join(dir, "project")
+
+	err = filepath.WalkDir(projectDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(d.Name(), ".") && path != projectDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		size += len(content)
+		if len(files) >= maxTemplateFiles || size > maxTemplateSize {
+			return fmt.Errorf("template is over limits: %d files, %d bytes", maxTemplateFiles, maxTemplateSize)
+		}
+
+		rel, _ := filepath.Rel(projectDir, path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userCode, ok := files[t.MainFile]
+	if !ok {
+		return nil, fmt.Errorf("main file %s is not found in project", t.MainFile)
+	}
+
+	project, err := json.Marshal(files)
+	if err != nil {
+		return nil, err
+	}
+
+	t.UserCode, t.Project = userCode, string(project)
+	t.Files = make([]string, 0, len(files))
+	for path := range files {
+		t.Files = append(t.Files, path)
+	}
+	sort.Strings(t.Files)
+
+	return t, nil
+}
+
+// GetTemplate returns template by id or default template of language if id is empty
+func (c *Catalog) GetTemplate(templateId string, langId string) (*PlaygroundTemplate, bool) {
+	if len(templateId) > 0 {
+		t, ok := c.Templates[templateId]
+		return t, ok
+	}
+
+	for _, id := range c.TemplateIds {
+		if t := c.Templates[id]; t.LangId == langId && t.IsDefault {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+// applyTemplate fills project of playground from template_id or from default template of language.
+// Project and code sent by user have priority over template.
+func applyTemplate(opts *OptionsPlayground) error {
+	if len(opts.TemplateId) == 0 && len(opts.Project) > 0 {
+		return nil
+	}
+
+	t, ok := GetCatalog().GetTemplate(opts.TemplateId, opts.LangId)
+	if !ok {
+		if len(opts.TemplateId) > 0 {
+			return fmt.Errorf("unknown template_id %s", opts.TemplateId)
+		}
+		return nil
+	}
+
+	if len(opts.LangId) > 0 && opts.LangId != t.LangId {
+		return fmt.Errorf("template %s is for %s", t.TemplateId, t.LangId)
+	}
+
+	opts.LangId = t.LangId
+	if len(opts.Project) == 0 {
+		opts.Project = t.Project
+	}
+	if len(opts.UserCode) == 0 {
+		opts.UserCode = t.UserCode
+	}
+
+	return nil
+}
+
+// HandleGetPlaygroundTemplates lists templates of playgrounds. lang_id is optional.
+func (s *Server) HandleGetPlaygroundTemplates(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-type", "application/json")
+
+	opts, err := extractOptionsPlayground(r)
+	if err != nil {
+		setErrorClass(w, errorClassClient)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("Invalid request: %s", err),
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"error":   err.Error(),
+		}).Warning("/get_playground_templates: couldn't parse request")
+		return
+	}
+
+	c := GetCatalog()
+	templates := []*PlaygroundTemplate{}
+	for _, id := range c.TemplateIds {
+		if t := c.Templates[id]; len(opts.LangId) == 0 || t.LangId == opts.LangId {
+			templates = append(templates, t)
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"templates": templates,
+	})
+
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
+		"user_id": opts.userId,
+		"lang_id": opts.LangId,
+		"count":   len(templates),
+	}).Info("/get_playground_templates: completed")
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTemplateFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Couldn't create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Couldn't write file: %v", err)
+		}
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	newTestServer()
+
+	root := t.TempDir()
+	writeTemplateFiles(t, root, map[string]string{
+		"cpp_cmake/template.json":          `{"lang_id": "cpp", "title": "C++", "main_file": "src/main.cpp", "default": true}`,
+		"cpp_cmake/project/CMakeLists.txt": "add_executable(main src/main.cpp)",
+		"cpp_cmake/project/src/main.cpp":   "int main() {}",
+		"cpp_cmake/project/.git/HEAD":      "ref: refs/heads/main",
+		// Invalid templates are skipped
+		"no_main/template.json":    `{"lang_id": "python", "main_file": "main.py"}`,
+		"no_main/project/other.py": "print(1)",
+		"no_lang/template.json":    `{"lang_id": "cobol", "main_file": "main.cob"}`,
+		"no_lang/project/main.cob": "DISPLAY 'HI'.",
+		"no_json/project/main.py":  "print(1)",
+	})
+
+	c := NewCatalog()
+	c.LoadTemplates(root)
+
+	if !reflect.DeepEqual(c.TemplateIds, []string{"cpp_cmake"}) {
+		t.Fatalf("Wrong loaded templates. Plan: %v Fact: %v", []string{"cpp_cmake"}, c.TemplateIds)
+	}
+
+	tmpl := c.Templates["cpp_cmake"]
+	if !reflect.DeepEqual(tmpl.Files, []string{"CMakeLists.txt", "src/main.cpp"}) || tmpl.UserCode != "int main() {}" {
+		t.Fatalf("Wrong files of template. Plan: %v Fact: %v", "CMakeLists.txt, src/main.cpp", tmpl)
+	}
+
+	var project map[string]string
+	if err := json.Unmarshal([]byte(tmpl.Project), &project); err != nil || project["src/main.cpp"] != "int main() {}" {
+		t.Fatalf("Wrong project of template. Plan: %v Fact: %v", "json with src/main.cpp", tmpl.Project)
+	}
+
+	// Missing directory means no templates
+	c.LoadTemplates(filepath.Join(root, "missing"))
+	if len(c.TemplateIds) != 0 || len(c.Templates) != 0 {
+		t.Fatalf("Wrong templates of missing dir. Plan: %v Fact: %v", "none", c.TemplateIds)
+	}
+}
+
+// Templates shipped in docker image must be valid
+func TestShippedTemplates(t *testing.T) {
+	newTestServer()
+
+	c := NewCatalog()
+	c.LoadTemplates("../etc/templates")
+
+	plan := []string{"cpp_cmake", "golang", "haskell", "python", "rust_cargo"}
+	if !reflect.DeepEqual(c.TemplateIds, plan) {
+		t.Fatalf("Wrong shipped templates. Plan: %v Fact: %v", plan, c.TemplateIds)
+	}
+
+	for _, langId := range []string{"cpp", "golang", "haskell", "python", "rust"} {
+		if _, ok := c.GetTemplate("", langId); !ok {
+			t.Fatalf("Wrong default template of %s. Plan: %v Fact: %v", langId, true, ok)
+		}
+	}
+}
+
+func TestPlaygroundTemplates(t *testing.T) {
+	s, _ := newTestServer()
+
+	prev := GetCatalog()
+	defer SetCatalog(prev)
+
+	c := NewCatalog()
+	c.LoadTemplates("../etc/templates")
+	SetCatalog(c)
+
+	var response struct {
+		Templates []PlaygroundTemplate `json:"templates"`
+	}
+	if err := callHandler(s.HandleGetPlaygroundTemplates, "/get_playground_templates", `{"lang_id": "rust"}`, &response); err != nil {
+		t.Fatalf("Couldn't call handler: %v", err)
+	}
+
+	if len(response.Templates) != 1 || response.Templates[0].TemplateId != "rust_cargo" ||
+		!reflect.DeepEqual(response.Templates[0].Files, []string{"Cargo.toml", "src/main.rs"}) {
+		t.Fatalf("Wrong templates of rust. Plan: %v Fact: %v", "rust_cargo", response.Templates)
+	}
+
+	tests := []struct {
+		body       string
+		statusCode float64
+		project    string
+	}{
+		// Language is taken from template
+		{`{"template_id": "cpp_cmake"}`, 0, c.Templates["cpp_cmake"].Project},
+		// Default template of language
+		{`{"lang_id": "python"}`, 0, c.Templates["python"].Project},
+		// Project of request has priority over default template
+		{`{"lang_id": "python", "project": "{}"}`, 0, "{}"},
+		{`{"template_id": "unknown"}`, 1, ""},
+		{`{"template_id": "cpp_cmake", "lang_id": "python"}`, 1, ""},
+	}
+
+	for _, test := range tests {
+		var saved map[string]interface{}
+		if err := callHandler(s.HandleSavePlayground, "/save_playground", test.body, &saved); err != nil {
+			t.Fatalf("Couldn't call handler: %v", err)
+		}
+
+		if saved["status_code"] != test.statusCode {
+			t.Fatalf("Wrong status code of %v. Plan: %v Fact: %v", test.body, test.statusCode, saved)
+		}
+
+		if test.statusCode != 0 {
+			continue
+		}
+
+		var playground Playground
+		callHandler(s.HandleGetPlaygroundCode, "/get_playground_code", `{"playground_id": "`+saved["playground_id"].(string)+`"}`, &playground)
+		if playground.Project != test.project {
+			t.Fatalf("Wrong project of %v. Plan: %v Fact: %v", test.body, test.project, playground.Project)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry spans: inbound request, file IO of wrappers, SQL queries and requests to watchman.
+// Trace context is taken from traceparent header of request and passed to watchman.
+// Until SetupTracing is called spans are not recorded.
+
+const tracerName = "senjun.ru/handyman"
+
+// Longer SQL is truncated in attributes of spans
+const maxSpanStatementLen = 2048
+
+func getTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// SetupTracing registers global provider of spans. Pass exporter as option:
+// sdktrace.WithBatcher(otlpExporter) in production, sdktrace.WithSyncer(tracetest.NewInMemoryExporter()) in tests.
+// Shutdown of provider exports the rest of spans.
+func SetupTracing(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String("handyman"))),
+	}, opts...)
+
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider
+}
+
+// endSpan marks span as failed if err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// TracingMiddleware starts span of request named by route: /assets/{course_id}/{item_id}/{path:.+}
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := getRoute(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := getTracer().Start(ctx, route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		if id := GetRequestId(ctx); len(id) > 0 {
+			span.SetAttributes(attribute.String("handyman.request_id", id))
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// setSpanErrorClass marks span of request with class of error from MetricsMiddleware
+func setSpanErrorClass(ctx context.Context, errorClass string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("handyman.error_class", errorClass))
+
+	if errorClass == errorClassServer {
+		span.SetStatus(codes.Error, "server error")
+	}
+}
+
+// startWatchmanSpan starts span of request to watchman and injects trace context to headers
+func startWatchmanSpan(ctx context.Context, req *http.Request, containerType string) (context.Context, trace.Span) {
+	ctx, span := getTracer().Start(ctx, "watchman "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(req.URL.String()),
+			attribute.String("handyman.container_type", containerType),
+		),
+	)
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return ctx, span
+}
+
+// startWrapperSpan starts span of reading wrapper of task and injecting code to it
+func startWrapperSpan(ctx context.Context, opts *Options, wrapperName string) trace.Span {
+	_, span := getTracer().Start(ctx, "inject_code_to_wrapper", trace.WithAttributes(
+		attribute.String("handyman.task_id", opts.TaskId),
+		attribute.String("handyman.wrapper", wrapperName),
+	))
+	return span
+}
+
+// dbTracer starts span for every query of pgx: Query, QueryRow, Exec and batches.
+type dbTracer struct{}
+
+func getStatementSpanName(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "postgres"
+	}
+
+	return "postgres " + strings.ToUpper(fields[0])
+}
+
+func getStatementAttribute(sql string) attribute.KeyValue {
+	sql = strings.TrimSpace(sql)
+	if len(sql) > maxSpanStatementLen {
+		sql = sql[:maxSpanStatementLen]
+	}
+
+	return semconv.DBStatementKey.String(sql)
+}
+
+func (t dbTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = getTracer().Start(ctx, getStatementSpanName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, getStatementAttribute(data.SQL)),
+	)
+	return ctx
+}
+
+func (t dbTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	endSpan(span, data.Err)
+}
+
+func (t dbTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = getTracer().Start(ctx, "postgres BATCH",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, attribute.Int("db.batch_size", data.Batch.Len())),
+	)
+	return ctx
+}
+
+func (t dbTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	_, span := getTracer().Start(ctx, getStatementSpanName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, getStatementAttribute(data.SQL)),
+	)
+	endSpan(span, data.Err)
+}
+
+func (t dbTracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	endSpan(trace.SpanFromContext(ctx), data.Err)
+}
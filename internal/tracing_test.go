@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := SetupTracing(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return exporter
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+
+	return nil
+}
+
+func TestTracingRunTask(t *testing.T) {
+	newTestServer()
+	exporter := setTestTracing(t)
+
+	var traceparent, requestId string
+	watchman := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		requestId = r.Header.Get(HeaderRequestId)
+		w.Write([]byte(`{"status": 0}`))
+	}))
+	defer watchman.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/run_task", func(w http.ResponseWriter, r *http.Request) {
+		opts := Options{TaskId: "python_chapter_0010_task_0010", TaskType: "code"}
+		InjectCodeToWrapper(r.Context(), &opts, "wrapper_run")
+
+		body := []byte(`{}`)
+		sendRequestToWatchman(r.Context(), watchman.URL+"/run", "python", &body)
+
+		setErrorClass(w, errorClassServer)
+		w.Write([]byte(`{"error": "Couldn't communicate with tasks runner"}`))
+	})
+	r.Use(RequestIdMiddleware)
+	r.Use(TracingMiddleware)
+	r.Use(MetricsMiddleware)
+
+	req := httptest.NewRequest(http.MethodPost, "/run_task", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(HeaderRequestId, "abc-123")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+
+	root := findSpan(spans, "/run_task")
+	if root == nil {
+		t.Fatalf("Wrong spans. Plan: %v Fact: %v", "/run_task", len(spans))
+	}
+
+	if root.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Wrong trace of request. Plan: %v Fact: %v", "4bf92f3577b34da6a3ce929d0e0e4736", root.SpanContext.TraceID())
+	}
+
+	if root.Status.Code != codes.Error {
+		t.Fatalf("Wrong status of request span. Plan: %v Fact: %v", codes.Error, root.Status.Code)
+	}
+
+	for _, name := range []string{"inject_code_to_wrapper", "watchman /run"} {
+		span := findSpan(spans, name)
+		if span == nil {
+			t.Fatalf("Wrong spans. Plan: %v Fact: %v", name, "no span")
+		}
+
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("Wrong parent of span %v. Plan: %v Fact: %v", name, root.SpanContext.SpanID(), span.Parent.SpanID())
+		}
+	}
+
+	// Wrapper doesn't exist in test catalog
+	if span := findSpan(spans, "inject_code_to_wrapper"); span.Status.Code != codes.Error {
+		t.Fatalf("Wrong status of wrapper span. Plan: %v Fact: %v", codes.Error, span.Status.Code)
+	}
+
+	watchmanSpan := findSpan(spans, "watchman /run")
+	if len(traceparent) == 0 || traceparent[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" || traceparent[36:52] != watchmanSpan.SpanContext.SpanID().String() {
+		t.Fatalf("Wrong traceparent passed to watchman. Plan: %v Fact: %v", watchmanSpan.SpanContext.SpanID(), traceparent)
+	}
+
+	if requestId != "abc-123" {
+		t.Fatalf("Wrong request id passed to watchman. Plan: %v Fact: %v", "abc-123", requestId)
+	}
+}
+
+func TestDbTracer(t *testing.T) {
+	exporter := setTestTracing(t)
+
+	var tracer dbTracer
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "\n\t\tSELECT status FROM task_progress"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("conn closed")})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "postgres SELECT" || spans[0].Status.Code != codes.Error {
+		t.Fatalf("Wrong span of query. Plan: %v Fact: %v", "postgres SELECT with error", spans)
+	}
+}
+
+func TestWatchmanRequestCancel(t *testing.T) {
+	newTestServer()
+	setTestTracing(t)
+
+	release := make(chan struct{})
+	watchman := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer watchman.Close()
+	defer close(release)
+
+	// Client disconnected: request to watchman is cancelled instead of waiting for the run
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	body := []byte(`{}`)
+	done := make(chan error, 1)
+	go func() {
+		_, err := sendRequestToWatchman(ctx, watchman.URL+"/run", "python", &body)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Wrong error of cancelled request. Plan: %v Fact: %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Request to watchman isn't cancelled with context")
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
@@ -9,8 +10,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 )
 
 var addrWatchman = "http://127.0.0.1:8000/check"
@@ -32,13 +35,42 @@ type RunTaskResult struct {
 	TestsOutput    string `json:"tests_output,omitempty"`
 }
 
+// RunCodeResult is result of playground run. Playground id is empty if playground wasn't saved
+type RunCodeResult struct {
+	RunTaskResult
+	PlaygroundId string `json:"playground_id,omitempty"`
+	// Set if code is saved to fork of playground
+	ParentId string `json:"parent_id,omitempty"`
+	// Set if anonymous playground is created
+	EditToken string `json:"edit_token,omitempty"`
+}
+
 type PracticeReq struct {
 	ProjectContents string `json:"project_contents"`
 	ProjectId       string `json:"project_id"`
 	CourseId        string `json:"course_id"`
-	CmdLineArgs     string `json:"user_cmd_line_args"`
-	Action          string `json:"action"` // run, test, save
-	userId          string
+	// Deprecated: arguments are split to user_args and are not passed to watchman
+	CmdLineArgs string `json:"user_cmd_line_args,omitempty"`
+	Action      string `json:"action"` // run, test, save
+	// Set from container type: value of client is overwritten
+	TimeoutSec int `json:"timeout_sec,omitempty"`
+	ProgramInput
+	userId string
+}
+
+// validateProgramInput moves arguments of user_cmd_line_args to user_args and validates them:
+// arguments of user program reach watchman only through user_args
+func (p *PracticeReq) validateProgramInput() error {
+	if len(p.CmdLineArgs) > 0 {
+		if len(p.Args) > 0 {
+			return errors.New("user_cmd_line_args and user_args can't be set together")
+		}
+
+		p.Args = strings.Fields(p.CmdLineArgs)
+		p.CmdLineArgs = ""
+	}
+
+	return p.ProgramInput.Validate()
 }
 
 func extractOptionsPlayground(r *http.Request) (OptionsPlayground, error) {
@@ -57,7 +89,7 @@ func extractOptionsPlayground(r *http.Request) (OptionsPlayground, error) {
 		opts.UserCode = string(sourceCodeDecoded)
 	}
 
-	opts.LangId = GetContainerType(opts.LangId)
+	opts.LangId = GetLangId(opts.LangId)
 
 	opts.userId = GetUserId(r)
 	return opts, nil
@@ -67,7 +99,7 @@ func extractOptionsRunTask(r *http.Request) (Options, error) {
 	opts, err := ParseOptions(r)
 
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"Error":     err,
 			"http_body": r.Body,
 		}).Error("Couldn't parse HTTP request for running task")
@@ -91,6 +123,14 @@ func extractOptionsRunTask(r *http.Request) (Options, error) {
 		opts.SourceCodeOriginal = string(sourceCodeDecoded)
 	}
 
+	if len(opts.ExampleId) == 0 && !opts.ProgramInput.IsEmpty() {
+		return Options{}, errors.New("user_stdin and user_args are allowed only for examples")
+	}
+
+	if err = opts.ProgramInput.Validate(); err != nil {
+		return Options{}, err
+	}
+
 	opts.containerType = GetContainerType(opts.ChapterId)
 
 	if len(opts.containerType) == 0 {
@@ -100,13 +140,16 @@ func extractOptionsRunTask(r *http.Request) (Options, error) {
 	return opts, nil
 }
 
-func sendRequestToWatchman(api string, postBody *[]byte) ([]byte, error) {
+func sendRequestToWatchman(ctx context.Context, api string, containerType string, postBody *[]byte) (_ []byte, err error) {
+	defer observeWatchman(api, containerType)()
+
+	// No client timeout: run is limited by watchman. Request is cancelled with context of client request
 	client := &http.Client{
 		Timeout: 0,
 	}
-	req, err := http.NewRequest("POST", api, bytes.NewBuffer(*postBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", api, bytes.NewBuffer(*postBody))
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"api":   api,
 			"error": err,
 		},
@@ -115,15 +158,22 @@ func sendRequestToWatchman(api string, postBody *[]byte) ([]byte, error) {
 		return []byte{}, err
 	}
 
+	ctx, span := startWatchmanSpan(ctx, req, containerType)
+	defer func() { endSpan(span, err) }()
+	req = req.WithContext(ctx)
+
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if id := GetRequestId(ctx); len(id) > 0 {
+		req.Header.Set(HeaderRequestId, id)
+	}
 
 	//req.Header.Set("Connection", "close")
 	//req.Close = true
 
 	resp, err := client.Do(req)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"api":   api,
 			"error": err,
 		},
@@ -133,10 +183,11 @@ func sendRequestToWatchman(api string, postBody *[]byte) ([]byte, error) {
 	}
 
 	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(ctx).WithFields(log.Fields{
 			"api":   api,
 			"error": err,
 		},
@@ -154,18 +205,15 @@ func getRequestBodyRunTask(opts *Options) ([]byte, error) {
 	watchmanOpts.SourceCodeRun = opts.SourceCodeRun
 	watchmanOpts.SourceCodeTest = opts.SourceCodeTest
 
-	colorArg := "-c always"
-	if !opts.ColorOutput {
-		colorArg = "-c never"
-	}
-
-	watchmanOpts.CmdLineArgs = append(watchmanOpts.CmdLineArgs, colorArg)
-
-	if opts.RunStaticTypeChecker {
-		watchmanOpts.CmdLineArgs = append(watchmanOpts.CmdLineArgs, "-t strict")
+	container, ok := GetCatalog().Containers.Get(opts.containerType)
+	if !ok {
+		return nil, errors.New("unknown container type " + opts.containerType)
 	}
 
+	watchmanOpts.CmdLineArgs = container.GetRunnerFlags(opts.ColorOutput, opts.RunStaticTypeChecker)
 	watchmanOpts.CmdLineArgs = append(watchmanOpts.CmdLineArgs, "-v "+opts.TaskType)
+	watchmanOpts.TimeoutSec = container.TimeoutSec
+	watchmanOpts.ProgramInput = opts.ProgramInput
 
 	return json.Marshal(watchmanOpts)
 }
@@ -175,22 +223,28 @@ func getRequestBodyPlayground(opts *OptionsPlayground) ([]byte, error) {
 	watchmanOpts.ContainerType = opts.LangId
 	watchmanOpts.SourceCodeRun = opts.UserCode
 	watchmanOpts.Project = opts.Project
+	watchmanOpts.ProgramInput = opts.ProgramInput
+
+	if container, ok := GetCatalog().Containers.Get(opts.LangId); ok {
+		watchmanOpts.TimeoutSec = container.TimeoutSec
+	}
 
 	return json.Marshal(watchmanOpts)
 }
 
-func HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-type", "application/json")
 
 	opts, err := extractOptionsRunTask(r)
 	if err != nil {
+		setErrorClass(w, errorClassClient)
 		body, _ := json.Marshal(map[string]string{
 			"error": fmt.Sprintf("Invalid request: %s", err),
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 			"error":   err.Error(),
@@ -199,7 +253,7 @@ func HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id":         opts.userId,
 		"task_id":         opts.TaskId,
 		"source_code_len": len(opts.SourceCodeOriginal),
@@ -208,21 +262,22 @@ func HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 	opts.TaskType = "code"
 
 	if len(opts.userId) == 0 {
+		setErrorClass(w, errorClassClient)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Couldn't get user_id",
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 		}).Warning("/inject_playground_code: couldn't get user_id")
 		return
 	}
-	err = InjectCodeToWrapper(&opts, "wrapper_playground")
+	err = InjectCodeToWrapper(r.Context(), &opts, "wrapper_playground")
 
 	// There is no wrapper file
 	if err != nil {
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 		}).Info("/inject_playground_code: completed (no inject)")
@@ -233,7 +288,7 @@ func HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id": opts.userId,
 		"task_id": opts.TaskId,
 	}).Info("/inject_playground_code: completed")
@@ -243,7 +298,7 @@ func HandleInjectPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func HandleRunTask(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	countRunTaskTotal.Inc()
 
 	w.WriteHeader(http.StatusOK)
@@ -253,12 +308,13 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		countRunTaskErrClient.Inc()
 
+		setErrorClass(w, errorClassClient)
 		body, _ := json.Marshal(map[string]string{
 			"error": fmt.Sprintf("Invalid request: %s", err),
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 			"error":   err.Error(),
@@ -271,7 +327,7 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 		opts.TaskType = "code"
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id":      opts.userId,
 		"task_id":      opts.TaskId,
 		"color_output": opts.ColorOutput,
@@ -281,31 +337,68 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	if len(opts.userId) == 0 {
 		countRunTaskErrClient.Inc()
 
+		setErrorClass(w, errorClassClient)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Couldn't get user_id",
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 		}).Warning("/run_task: couldn't get user_id")
 		return
 	}
 
+	if !s.checkAccess(r.Context(), w, "/run_task", opts.userId, opts.CourseId, opts.ChapterId) {
+		countRunTaskErrClient.Inc()
+		return
+	}
+
+	lock, err := s.GetMaterialLock(r.Context(), opts.userId, opts.CourseId, opts.ChapterId)
+	if err != nil {
+		countRunTaskErrServer.Inc()
+
+		setErrorClass(w, errorClassServer)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Couldn't check unlock rules",
+		})
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id": opts.userId,
+			"task_id": opts.TaskId,
+			"error":   err.Error(),
+		}).Error("/run_task: couldn't check unlock rules")
+		return
+	}
+
+	if lock != nil {
+		countRunTaskErrClient.Inc()
+
+		json.NewEncoder(w).Encode(lock)
+
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":      opts.userId,
+			"task_id":      opts.TaskId,
+			"required_ids": lock.RequiredIds,
+		}).Info("/run_task: chapter is locked for user")
+		return
+	}
+
 	// Replaces strange symbols (no-break space, ... for iOS users, etc)
 	// https://github.com/senjun-team/senjun-courses/issues/31
 	normalizeCode(&opts)
-	err = InjectCodeToTestWrapper(&opts)
+	err = InjectCodeToTestWrapper(r.Context(), &opts)
 
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't prepare tests for wrapper task runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id":    opts.userId,
 			"task_id":    opts.TaskId,
 			"chapter_id": opts.ChapterId,
@@ -315,17 +408,18 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = InjectCodeToWrapper(&opts, "wrapper_run")
+	err = InjectCodeToWrapper(r.Context(), &opts, "wrapper_run")
 
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't prepare run wrapper for task runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id":    opts.userId,
 			"task_id":    opts.TaskId,
 			"chapter_id": opts.ChapterId,
@@ -339,12 +433,13 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 			"error":   err.Error(),
@@ -352,17 +447,18 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bodyResp, err := sendRequestToWatchman(addrWatchman, &bodyReq)
+	bodyResp, err := sendRequestToWatchman(r.Context(), addrWatchman, opts.containerType, &bodyReq)
 
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id":     opts.userId,
 			"task_id":     opts.TaskId,
 			"raw_request": string(bodyReq[:]),
@@ -376,12 +472,13 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 			"error":   err.Error(),
@@ -389,14 +486,16 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if UpdateStatus(opts.userId, opts.TaskId, opts.ChapterId, opts.CourseId,
+	s.activity.Record(opts.userId, opts.ChapterId, ActionRunTask)
+
+	if s.UpdateStatus(r.Context(), opts.userId, opts.TaskId, opts.ChapterId, opts.CourseId,
 		res.StatusCode == 0, opts.SourceCodeOriginal) {
 		countRunTaskOk.Inc()
 	} else {
 		countRunTaskErrServer.Inc()
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id":     opts.userId,
 		"task_id":     opts.TaskId,
 		"status_code": res.StatusCode,
@@ -405,18 +504,19 @@ func HandleRunTask(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-func HandleSaveTask(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleSaveTask(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-type", "application/json")
 
 	opts, err := extractOptionsRunTask(r)
 	if err != nil {
+		setErrorClass(w, errorClassClient)
 		body, _ := json.Marshal(map[string]string{
 			"error": fmt.Sprintf("Invalid request: %s", err),
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 			"error":   err.Error(),
@@ -429,17 +529,18 @@ func HandleSaveTask(w http.ResponseWriter, r *http.Request) {
 		opts.TaskType = "code"
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id": opts.userId,
 		"task_id": opts.TaskId,
 	}).Info("/save_task: parsed options")
 
 	if len(opts.userId) == 0 {
+		setErrorClass(w, errorClassClient)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Couldn't get user_id",
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 		}).Warning("/save_task: couldn't get user_id")
@@ -450,12 +551,13 @@ func HandleSaveTask(w http.ResponseWriter, r *http.Request) {
 	// https://github.com/senjun-team/senjun-courses/issues/31
 	normalizeCode(&opts)
 
-	if !SaveTask(opts.userId, opts.TaskId, opts.ChapterId, opts.CourseId, opts.SourceCodeOriginal) {
-		Logger.WithFields(log.Fields{
+	if !s.SaveTask(r.Context(), opts.userId, opts.TaskId, opts.ChapterId, opts.CourseId, opts.SourceCodeOriginal) {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"task_id": opts.TaskId,
 		}).Error("/save_task: couldn't save to DB")
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]int{
 			"status_code": 1,
 		})
@@ -464,7 +566,7 @@ func HandleSaveTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id": opts.userId,
 		"task_id": opts.TaskId,
 	}).Info("/save_task: completed")
@@ -476,22 +578,30 @@ func HandleSaveTask(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-func HandleRunCode(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleRunCode(w http.ResponseWriter, r *http.Request) {
 	countRunCodeTotal.Inc()
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-type", "application/json")
 
 	opts, err := extractOptionsPlayground(r)
+	if err == nil {
+		err = applyTemplate(&opts)
+	}
+	if err == nil {
+		err = opts.ProgramInput.Validate()
+	}
+
 	if err != nil {
 		countRunCodeErrClient.Inc()
 
+		setErrorClass(w, errorClassClient)
 		body, _ := json.Marshal(map[string]string{
 			"error": fmt.Sprintf("Invalid request: %s", err),
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id":       opts.userId,
 			"lang_id":       opts.LangId,
 			"playground_id": opts.PlaygroundId,
@@ -501,7 +611,7 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id":       opts.userId,
 		"lang_id":       opts.LangId,
 		"playground_id": opts.PlaygroundId,
@@ -510,11 +620,12 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 	if len(opts.Project) == 0 {
 		countRunCodeErrClient.Inc()
 
+		setErrorClass(w, errorClassClient)
 		json.NewEncoder(w).Encode(map[string]int{
 			"status_code": 1,
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"lang_id": opts.LangId,
 		}).Warning("/run_code: required fields not set in request")
@@ -525,16 +636,35 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 	// https://github.com/senjun-team/senjun-courses/issues/31
 	normalizeCodePlayground(&opts)
 
+	// Code is saved even if it fails to run. Playground is optional for run: errors are only logged
+	saved := false
+	err = validatePlayground(&opts)
+	if err == nil {
+		err = s.SavePlayground(r.Context(), &opts)
+	}
+
+	if err != nil {
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
+			"user_id":       opts.userId,
+			"lang_id":       opts.LangId,
+			"playground_id": opts.PlaygroundId,
+			"error":         err.Error(),
+		}).Warning("/run_code: couldn't save playground")
+	} else {
+		saved = true
+	}
+
 	bodyReq, err := getRequestBodyPlayground(&opts)
 	if err != nil {
 		countRunCodeErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"lang_id": opts.LangId,
 			"error":   err.Error(),
@@ -542,17 +672,18 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bodyResp, err := sendRequestToWatchman(addrWatchmanPlayground, &bodyReq)
+	bodyResp, err := sendRequestToWatchman(r.Context(), addrWatchmanPlayground, opts.LangId, &bodyReq)
 
 	if err != nil {
 		countRunCodeErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"lang_id": opts.LangId,
 			"error":   err.Error(),
@@ -565,12 +696,13 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		countRunTaskErrServer.Inc()
 
+		setErrorClass(w, errorClassServer)
 		body, _ := json.Marshal(map[string]string{
 			"error": "Couldn't communicate with tasks runner",
 		})
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"lang_id": opts.LangId,
 			"error":   err.Error(),
@@ -578,36 +710,38 @@ func HandleRunCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: update record in 'playgrounds' table
+	result := RunCodeResult{RunTaskResult: *res}
+	if saved {
+		result.PlaygroundId, result.ParentId, result.EditToken = opts.PlaygroundId, opts.parentId, opts.newEditToken
+	}
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"user_id":       opts.userId,
 		"lang_id":       opts.LangId,
 		"playground_id": opts.PlaygroundId,
 		"status_code":   res.StatusCode,
 	}).Info("/run_code: completed")
 
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(result)
 
 	countRunCodeOk.Inc()
 }
 
-func HandleGetPlaygroundCode(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleGetPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-type", "application/json")
 
 	opts, err := extractOptionsPlayground(r)
 
 	if err != nil {
-		//countUpdateCourseProgressClientError.Inc()
-
+		setErrorClass(w, errorClassClient)
 		body, _ := json.Marshal(map[string]int{
 			"status_code": 1,
 		})
 
 		w.Write(body)
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id": opts.userId,
 			"lang_id": opts.LangId,
 			"error":   err.Error(),
@@ -616,49 +750,48 @@ func HandleGetPlaygroundCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(opts.PlaygroundId) == 0 {
-		//countUpdateCourseProgressClientError.Inc()
-
+		setErrorClass(w, errorClassClient)
 		json.NewEncoder(w).Encode(map[string]int{
 			"status_code": 1,
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"user_id":       opts.userId,
 			"playground_id": opts.PlaygroundId,
 		}).Warning("/get_playground_code: required fields not set in request")
 		return
 	}
 
-	userCode, err := GetPlaygroundCode(opts.PlaygroundId)
+	playground, err := s.GetPlayground(r.Context(), opts.PlaygroundId, opts.userId)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			setErrorClass(w, errorClassClient)
 			json.NewEncoder(w).Encode(map[string]int{
 				"status_code": 2,
 			})
 
-			Logger.WithFields(log.Fields{
+			Logger.WithContext(r.Context()).WithFields(log.Fields{
 				"playground_id": opts.PlaygroundId,
 			}).Info("/get_playground_code: no playground found")
 			return
 
 		}
 
+		setErrorClass(w, errorClassServer)
 		json.NewEncoder(w).Encode(map[string]int{
 			"status_code": 3,
 		})
 
-		Logger.WithFields(log.Fields{
+		Logger.WithContext(r.Context()).WithFields(log.Fields{
 			"playground_id": opts.PlaygroundId,
 			"error":         err.Error(),
 		}).Warning("/get_playground_code: couldn't select playground")
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"user_code": userCode,
-	})
+	json.NewEncoder(w).Encode(playground)
 
-	Logger.WithFields(log.Fields{
+	Logger.WithContext(r.Context()).WithFields(log.Fields{
 		"playground_id": opts.PlaygroundId,
 	}).Info("/get_playground_code: completed")
 }